@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"demo-storage/internal/app/repository"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/db"
+)
+
+const usage = `Usage: apikey [-config=<config file>] <command>
+
+Commands:
+  create -owner=<owner> [-quota=<bytes>]   create S3 gateway key, prints access and secret key
+  disable <access key>                     disable key
+`
+
+// Управление ключами доступа S3 шлюза
+func main() {
+	confFile := flag.String("config", "conf/application.conf", "-config=<config file name>")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config.MustConfig(*confFile)
+	conf := config.GetConfig()
+
+	d := db.Connect(conf.DB)
+	defer d.Close()
+
+	keys := repository.NewKeyRepository(d, conf.DB.QueryTimeout)
+	switch flag.Arg(0) {
+	case "create":
+		cmd := flag.NewFlagSet("create", flag.ExitOnError)
+		owner := cmd.String("owner", "", "-owner=<owner>")
+		quota := cmd.Int64("quota", 0, "-quota=<bytes>, 0 - unlimited")
+		_ = cmd.Parse(flag.Args()[1:])
+		if *owner == "" {
+			log.Fatal("owner is required")
+		}
+
+		key := &structs.APIKey{
+			AccessKey:  strings.ToUpper(random(15))[:20],
+			SecretKey:  random(30),
+			Owner:      *owner,
+			QuotaBytes: sql.NullInt64{Int64: *quota, Valid: *quota > 0},
+		}
+		if err := keys.CreateKey(context.Background(), key); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Access key: %s\nSecret key: %s\n", key.AccessKey, key.SecretKey)
+	case "disable":
+		if flag.NArg() < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := keys.DisableKey(context.Background(), flag.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// random строка из n случайных байт в base64 без спецсимволов, удобная для конфигов клиентов
+func random(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return strings.NewReplacer("+", "A", "/", "B").Replace(base64.RawStdEncoding.EncodeToString(b))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/db"
+	"demo-storage/internal/pkg/migrations"
+)
+
+const usage = `Usage: migrator [-config=<config file>] [-migrations-path=<dir>] <command>
+
+Commands:
+  up              apply all pending migrations
+  down [n]        roll back n last migrations (default 1)
+  to <version>    migrate up or down to the version, 0 rolls back everything
+  status          show migrations and when they were applied
+`
+
+func main() {
+	confFile := flag.String("config", "conf/application.conf", "-config=<config file name>")
+	migrationsPath := flag.String("migrations-path", "", "-migrations-path=<dir>, embedded migrations are used by default")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config.MustConfig(*confFile)
+	conf := config.GetConfig()
+
+	d := db.Connect(conf.DB)
+	defer d.Close()
+
+	var m *migrations.Migrator
+	var err error
+	if *migrationsPath != "" {
+		m, err = migrations.NewFromFS(d, os.DirFS(*migrationsPath))
+	} else {
+		m, err = migrations.New(d)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	switch flag.Arg(0) {
+	case "up":
+		err = m.Up(ctx)
+	case "down":
+		steps := 1
+		if flag.NArg() > 1 {
+			steps, err = strconv.Atoi(flag.Arg(1))
+			if err != nil || steps < 1 {
+				log.Fatal("down: steps must be a positive number")
+			}
+		}
+		err = m.Down(ctx, steps)
+	case "to":
+		if flag.NArg() < 2 {
+			log.Fatal("to: version is required")
+		}
+		version, convErr := strconv.Atoi(flag.Arg(1))
+		if convErr != nil || version < 0 {
+			log.Fatal("to: version must be a non-negative number")
+		}
+		err = m.To(ctx, version)
+	case "status":
+		var statuses []migrations.Status
+		statuses, err = m.Status(ctx)
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s  %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
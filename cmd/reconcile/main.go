@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	minio "demo-storage/internal/app/service"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/db"
+)
+
+// Сверка бакета и таблицы files.
+// По умолчанию только отчет, с -fix импортирует объекты без записей и помечает пропавшие объекты MISSING
+func main() {
+	confFile := flag.String("config", "conf/application.conf", "-config=<config file name>")
+	fix := flag.Bool("fix", false, "-fix: import orphan objects and mark dangling rows MISSING")
+	asJSON := flag.Bool("json", false, "-json: print report as JSON")
+	flag.Parse()
+
+	config.MustConfig(*confFile)
+	conf := config.GetConfig()
+	if conf.Minio.AccessKey == "" || conf.Minio.SecretKey == "" {
+		log.Fatal("Empty access or secret key. Exiting...")
+	}
+
+	d := db.Connect(conf.DB)
+	defer d.Close()
+
+	client, err := minio.NewS3(conf.Minio)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := minio.New(conf, d, client)
+	report, err := s.Reconcile(context.Background(), *fix)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	fmt.Printf("Objects in bucket: %d\n", report.Objects)
+	fmt.Printf("Rows in files:     %d\n", report.Rows)
+	fmt.Printf("\nObjects without rows (%d):\n", len(report.OrphanObjects))
+	for _, o := range report.OrphanObjects {
+		fmt.Printf("  %s\t%d\t%s\n", o.Key, o.Size, o.Etag)
+	}
+	fmt.Printf("\nREADY rows without objects (%d):\n", len(report.DanglingRows))
+	for _, name := range report.DanglingRows {
+		fmt.Printf("  %s\n", name)
+	}
+	if report.Fixed {
+		fmt.Printf("\nImported: %d, marked MISSING: %d\n", report.Imported, report.MarkedMissing)
+	}
+}
@@ -1,20 +1,23 @@
 package main
 
 import (
+	"context"
+	minio "demo-storage/internal/app/service"
 	"demo-storage/internal/pkg/db"
 	"demo-storage/internal/pkg/logging"
+	"demo-storage/internal/pkg/migrations"
+	"demo-storage/internal/pkg/tracing"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"runtime"
+	"time"
 
 	"demo-storage/internal/config"
 	"demo-storage/internal/pkg/app"
 	"demo-storage/internal/utils"
 	"demo-storage/internal/utils/gs"
 
-	"github.com/SandQuattro/logdoc-go-appender/common"
 	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
 	"github.com/jmoiron/sqlx"
 )
@@ -26,37 +29,41 @@ func main() {
 	port := flag.String("port", "", "-port=<service port>")
 	flag.Parse()
 
+	// и подгрузим конфиг
+	config.MustConfig(*confFile)
+	conf := config.GetConfig()
+
+	if *port == "" {
+		*port = conf.Server.Port
+	}
 	if *port == "" {
 		log.Fatal("Empty service port. Exiting...")
 	}
-
-	access := os.Getenv("MINIO_ACCESS")
-	secret := os.Getenv("MINIO_SECRET")
-	if access == "" || secret == "" {
+	if conf.Minio.AccessKey == "" || conf.Minio.SecretKey == "" {
 		log.Fatal("Empty access or secret key. Exiting...")
 	}
 
-	// и подгрузим конфиг
-	config.MustConfig(*confFile)
-	conf := config.GetConfig()
-
-	// Создаем подсистему логгирования LogDoc
-	conn, err := logging.LDSubsystemInit()
-	logger := logdoc.GetLogger()
-	if err == nil {
-		logger.Info(fmt.Sprintf(
-			"LogDoc subsystem initialized successfully@@source=%s:%d",
-			common.GetSourceName(runtime.Caller(0)), // фреймы не скипаем, не exception
-			common.GetSourceLineNum(runtime.Caller(0)),
-		))
+	// Создаем подсистему логгирования: stdout, файл и/или LogDoc по секции logging
+	closeLogging, err := logging.Init(conf)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("Error logging subsystem initialization: %v", err))
 	}
+	defer closeLogging()
+	logger := logdoc.GetLogger()
+	logger.Info(fmt.Sprintf("Logging subsystem initialized, sinks: %v", conf.Logging.Sinks))
 
-	c := *conn
-	if c != nil {
-		defer c.Close()
-	} else {
-		logger.Error("Error LogDoc subsystem initialization")
+	// Трассировка OpenTelemetry, экспорт настраивается в секции tracing
+	shutdownTracing, err := tracing.Init(conf.Tracing)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Error initializing tracing: %v", err))
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error(fmt.Sprintf("Error flushing traces: %v", err))
+		}
+	}()
 
 	utils.CreatePID()
 	defer func() {
@@ -67,13 +74,7 @@ func main() {
 	}()
 
 	// Коннектимся к базе
-	dbPass := os.Getenv("PGPASS")
-	if dbPass == "" {
-		logger.Fatal("db password is empty")
-	}
-
-	// Коннектимся к базе
-	d := db.Connect(conf, dbPass)
+	d := db.Connect(conf.DB)
 	defer func(d *sqlx.DB) {
 		err := d.Close()
 		if err != nil {
@@ -82,8 +83,27 @@ func main() {
 	}(d)
 	logger.Info(">> DATABASE CONNECTION SUCCESSFUL")
 
+	// Накатываем миграции при старте, если включено. Параллельный запуск нескольких
+	// экземпляров защищен advisory lock внутри мигратора
+	if conf.DB.AutoMigrate {
+		m, err := migrations.New(d)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if err = m.Up(context.Background()); err != nil {
+			logger.Fatal(fmt.Sprintf("Error applying migrations: %v", err))
+		}
+		logger.Info(">> DATABASE MIGRATIONS APPLIED")
+	}
+
+	// Общий клиент S3 с пулом соединений на все приложение
+	client, err := minio.NewS3(conf.Minio)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Error creating S3 client: %v", err))
+	}
+
 	// Создадим приложение
-	a, err := app.New(conf, *port, access, secret, d)
+	a, err := app.New(conf, *port, d, client)
 	if err != nil {
 		logger.Error("Ошибка создания приложения")
 	}
@@ -96,5 +116,7 @@ func main() {
 			logger.Fatal(err)
 		}
 	}()
+	// SIGHUP перечитывает настройки, которые меняются без перезапуска
+	go gs.ReloadOnSIGHUP(a)
 	gs.GracefulShutdown(a)
 }
@@ -17,6 +17,6 @@ func New(s interfaces.MinioService) *Endpoint {
 }
 
 func (e *Endpoint) BucketsHandler(ctx echo.Context) error {
-	res := e.s.ListBuckets()
+	res := e.s.ListBuckets(ctx.Request().Context())
 	return ctx.JSON(http.StatusOK, res)
 }
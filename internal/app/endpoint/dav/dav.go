@@ -0,0 +1,140 @@
+package dav
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"demo-storage/internal/app/interfaces"
+	"demo-storage/internal/app/repository"
+	jwtservice "demo-storage/internal/app/security"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/logging"
+
+	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+)
+
+// Prefix путь, под которым смонтирован WebDAV, клиентам указывается как адрес сетевого диска
+const Prefix = "/webdav"
+
+// Methods методы WebDAV, которые нужно зарегистрировать в роутере
+var Methods = []string{
+	http.MethodOptions, http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// Endpoint WebDAV доступ к бакету хранилища. Протокол реализует golang.org/x/net/webdav,
+// файловая система отображает пути на ключи бакета и ведет учет в files.
+// Вход по JWT (Bearer или пароль basic auth) дает полный доступ, по ключу S3 шлюза
+// (access key как логин, secret key как пароль) - те же права владельца и квота, что и в шлюзе
+type Endpoint struct {
+	s     interfaces.MinioService
+	files *repository.FileRepository
+	keys  *repository.KeyRepository
+	locks webdav.LockSystem
+}
+
+func New(s interfaces.MinioService, db *sqlx.DB, conf *config.Config) *Endpoint {
+	// Создаем endpoint и возвращаем
+	return &Endpoint{
+		s:     s,
+		files: repository.New(db, conf.DB.QueryTimeout),
+		keys:  repository.NewKeyRepository(db, conf.DB.QueryTimeout),
+		locks: webdav.NewMemLS(),
+	}
+}
+
+// DavHandler проверяет учетные данные и передает запрос обработчику WebDAV
+func (e *Endpoint) DavHandler(ctx echo.Context) error {
+	req := ctx.Request()
+
+	key, ok := e.authenticate(req)
+	if !ok {
+		ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="storage"`)
+		return ctx.NoContent(http.StatusUnauthorized)
+	}
+	if key != nil {
+		req = req.WithContext(logging.WithFields(req.Context(), logrus.Fields{logging.FieldUser: key.Owner}))
+	}
+	logger := logging.FromContext(req.Context())
+
+	fs := &fileSystem{
+		ctx:     req.Context(),
+		s:       e.s,
+		files:   e.files,
+		key:     key,
+		maxSize: config.GetConfig().Upload.MaxSize,
+		stats:   map[string]*fileInfo{},
+		// webdav закрывает файл и после оборванного тела, по длине отличаем его от загруженного целиком
+		contentLength: -1,
+	}
+	// PUT проверяем до чтения тела: обработчик webdav на любую ошибку открытия файла отвечает 404
+	if req.Method == http.MethodPut {
+		fs.contentLength = req.ContentLength
+		if err := fs.checkPut(strings.TrimPrefix(req.URL.Path, Prefix), req.ContentLength); err != nil {
+			switch {
+			case errors.Is(err, os.ErrPermission):
+				return ctx.NoContent(http.StatusForbidden)
+			case errors.Is(err, errQuotaExceeded):
+				return ctx.NoContent(http.StatusInsufficientStorage)
+			case errors.Is(err, os.ErrNotExist):
+				return ctx.NoContent(http.StatusConflict)
+			case errors.Is(err, os.ErrExist):
+				return ctx.NoContent(http.StatusMethodNotAllowed)
+			default:
+				return ctx.String(http.StatusBadRequest, err.Error())
+			}
+		}
+	}
+
+	handler := &webdav.Handler{
+		Prefix:     Prefix,
+		FileSystem: fs,
+		LockSystem: e.locks,
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				logger.Debug(fmt.Sprintf(">> WebDAV > %s %s: %v", r.Method, r.URL.Path, err))
+			}
+		},
+	}
+	handler.ServeHTTP(ctx.Response(), req)
+	return nil
+}
+
+// authenticate возвращает ключ S3 шлюза, под которым работает клиент, или nil для входа по JWT
+func (e *Endpoint) authenticate(req *http.Request) (*structs.APIKey, bool) {
+	if auth := req.Header.Get(echo.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+		return nil, e.validToken(auth)
+	}
+
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+	key, err := e.keys.FindKey(req.Context(), user)
+	if err == nil {
+		if key.Disabled || subtle.ConstantTimeCompare([]byte(password), []byte(key.SecretKey)) != 1 {
+			return nil, false
+		}
+		return key, true
+	}
+	if !errors.Is(err, repository.ErrKeyNotFound) {
+		logdoc.GetLogger().Error(fmt.Sprintf(">> WebDAV > unable to load key %s: %v", user, err))
+		return nil, false
+	}
+	// клиенты без поддержки Bearer передают JWT паролем
+	return nil, e.validToken(password)
+}
+
+func (e *Endpoint) validToken(token string) bool {
+	valid, err := jwtservice.ValidateToken(token)
+	return err == nil && valid
+}
@@ -0,0 +1,331 @@
+package dav
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"demo-storage/internal/app/interfaces"
+	"demo-storage/internal/app/metrics"
+	minio "demo-storage/internal/app/service"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/pkg/compress"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/net/webdav"
+)
+
+// fileInfo сведения об объекте или каталоге. ETag и content type берутся из бакета,
+// чтобы webdav не вычитывал содержимое файлов при PROPFIND
+type fileInfo struct {
+	name        string
+	size        int64
+	modTime     time.Time
+	dir         bool
+	etag        string
+	contentType string
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() any           { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (fi *fileInfo) ETag(context.Context) (string, error) {
+	if fi.etag == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.etag, nil
+}
+
+func (fi *fileInfo) ContentType(context.Context) (string, error) {
+	if fi.contentType != "" {
+		return fi.contentType, nil
+	}
+	if ct := mime.TypeByExtension(path.Ext(fi.name)); ct != "" {
+		return ct, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// readFile читает объект лениво: запрос в бакет уходит при первом Read с текущего смещения,
+// Seek только запоминает новое смещение
+type readFile struct {
+	ctx    context.Context
+	s      interfaces.MinioService
+	key    string
+	info   *fileInfo
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *readFile) Read(p []byte) (int, error) {
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+// open открывает объект с текущего смещения. Сжатый объект читается с начала,
+// смещение пропускается в распакованных данных
+func (f *readFile) open() error {
+	var byteRange string
+	if f.offset > 0 {
+		byteRange = fmt.Sprintf("bytes=%d-", f.offset)
+	}
+	object, err := f.s.GetObject(f.ctx, f.key, byteRange)
+	if err != nil {
+		return err
+	}
+	codec := minio.ObjectCodec(object)
+	if codec == compress.NONE {
+		f.body = object.Body
+		return nil
+	}
+
+	if byteRange != "" {
+		object.Body.Close()
+		if object, err = f.s.GetObject(f.ctx, f.key, ""); err != nil {
+			return err
+		}
+	}
+	reader, err := compress.NewReader(codec, object.Body)
+	if err != nil {
+		object.Body.Close()
+		return err
+	}
+	f.body = &decompressed{ReadCloser: reader, body: object.Body}
+	if _, err = io.CopyN(io.Discard, reader, f.offset); err != nil {
+		f.body.Close()
+		f.body = nil
+		return err
+	}
+	return nil
+}
+
+func (f *readFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	default:
+		return 0, os.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *readFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
+
+func (f *readFile) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+func (f *readFile) Stat() (os.FileInfo, error)         { return f.info, nil }
+func (f *readFile) Write([]byte) (int, error)          { return 0, os.ErrPermission }
+
+// decompressed закрывает распаковщик вместе с телом объекта
+type decompressed struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (d *decompressed) Close() error {
+	d.ReadCloser.Close()
+	return d.body.Close()
+}
+
+// writeFile передает записанные данные через pipe в потоковую загрузку.
+// Close дожидается ее окончания, ошибка загрузки возвращается из Close.
+// Если записано меньше заявленного size, загрузка завершается ошибкой и файл не сохраняется
+type writeFile struct {
+	fs      *fileSystem
+	key     string
+	left    int64
+	size    int64
+	written int64
+	pw      *io.PipeWriter
+	done    chan error
+	closed  bool
+}
+
+func newWriteFile(fs *fileSystem, key string, left int64, size int64) *writeFile {
+	pr, pw := io.Pipe()
+	f := &writeFile{fs: fs, key: key, left: left, size: size, pw: pw, done: make(chan error, 1)}
+	header := &structs.UploadHeader{Filename: key, Size: structs.UnknownSize}
+	if size >= 0 {
+		header.Size = int(size)
+	}
+	if fs.key != nil {
+		header.Owner = fs.key.Owner
+	}
+	finished := metrics.StartUploadSession(metrics.ProtocolWebDAV)
+	go func() {
+		defer finished()
+		_, err := fs.s.UploadFileStream(fs.ctx, header, "", pr)
+		// загрузка может оборваться раньше, чем клиент допишет тело
+		pr.CloseWithError(err)
+		f.done <- err
+	}()
+	return f
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	if int64(len(p)) > f.left-f.written {
+		f.pw.CloseWithError(errQuotaExceeded)
+		return 0, errQuotaExceeded
+	}
+	n, err := f.pw.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+func (f *writeFile) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	// webdav вызывает Close и когда клиент оборвал тело PUT
+	if f.size >= 0 && f.written < f.size {
+		f.pw.CloseWithError(io.ErrUnexpectedEOF)
+	} else {
+		f.pw.Close()
+	}
+	return <-f.done
+}
+
+func (f *writeFile) Stat() (os.FileInfo, error) {
+	return &fileInfo{name: path.Base(f.key), size: f.written, modTime: time.Now()}, nil
+}
+
+func (f *writeFile) Read([]byte) (int, error)           { return 0, os.ErrInvalid }
+func (f *writeFile) Seek(int64, int) (int64, error)     { return 0, os.ErrInvalid }
+func (f *writeFile) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+
+// dirFile каталог: листинг бакета по префиксу с разделителем "/"
+type dirFile struct {
+	fs      *fileSystem
+	key     string
+	info    *fileInfo
+	entries []os.FileInfo
+	loaded  bool
+	pos     int
+}
+
+func (d *dirFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.loaded {
+		if err := d.load(); err != nil {
+			return nil, err
+		}
+	}
+	rest := d.entries[d.pos:]
+	if count <= 0 {
+		d.pos = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(rest))
+	d.pos += n
+	return rest[:n], nil
+}
+
+// load читает каталог всеми страницами листинга. Сведения о файлах сразу попадают в кэш Stat
+func (d *dirFile) load() error {
+	var prefix string
+	if d.key != "" {
+		prefix = d.key + "/"
+	}
+	input := &s3.ListObjectsV2Input{Prefix: aws.String(prefix), Delimiter: aws.String("/")}
+	for {
+		page, err := d.fs.s.ListObjectsPage(d.fs.ctx, input)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(page.Contents))
+		for _, object := range page.Contents {
+			names = append(names, aws.StringValue(object.Key))
+		}
+		rows, err := d.fs.files.FindFiles(d.fs.ctx, names)
+		if err != nil {
+			return err
+		}
+
+		for _, object := range page.Contents {
+			key := aws.StringValue(object.Key)
+			name := strings.TrimPrefix(key, prefix)
+			// маркер самого каталога
+			if name == "" {
+				continue
+			}
+			row, ok := rows[key]
+			if ok && !d.fs.readable(&row) {
+				continue
+			}
+			fi := &fileInfo{
+				name:    name,
+				size:    originalSize(row, aws.Int64Value(object.Size)),
+				modTime: aws.TimeValue(object.LastModified),
+				etag:    aws.StringValue(object.ETag),
+			}
+			d.entries = append(d.entries, fi)
+			d.fs.stats[key] = fi
+		}
+		for _, p := range page.CommonPrefixes {
+			key := strings.TrimSuffix(aws.StringValue(p.Prefix), "/")
+			name := strings.TrimPrefix(key, prefix)
+			if name == "" {
+				continue
+			}
+			fi := &fileInfo{name: name, dir: true}
+			d.entries = append(d.entries, fi)
+			d.fs.stats[key] = fi
+		}
+
+		if !aws.BoolValue(page.IsTruncated) {
+			break
+		}
+		input.ContinuationToken = page.NextContinuationToken
+	}
+	d.loaded = true
+	return nil
+}
+
+func (d *dirFile) Stat() (os.FileInfo, error)     { return d.info, nil }
+func (d *dirFile) Read([]byte) (int, error)       { return 0, os.ErrInvalid }
+func (d *dirFile) Seek(int64, int) (int64, error) { return 0, nil }
+func (d *dirFile) Write([]byte) (int, error)      { return 0, os.ErrPermission }
+func (d *dirFile) Close() error                   { return nil }
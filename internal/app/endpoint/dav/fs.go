@@ -0,0 +1,450 @@
+package dav
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"demo-storage/internal/app/endpoint/upload"
+	"demo-storage/internal/app/interfaces"
+	"demo-storage/internal/app/repository"
+	minio "demo-storage/internal/app/service"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/pkg/compress"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	deleteReason = "deleted by WebDAV"
+	moveReason   = "moved by WebDAV to "
+)
+
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// fileSystem webdav.FileSystem поверх бакета. Путь /a/b соответствует ключу a/b,
+// каталог - общий префикс ключей a/b/ или пустой объект-маркер с таким ключом.
+// Создается на каждый запрос с правами клиента: key == nil - полный доступ,
+// иначе действуют правила S3 шлюза (чужие файлы не видны, файлы без владельца только на чтение)
+type fileSystem struct {
+	// ctx контекст запроса: обращения к бакету и базе отменяются вместе с ним
+	ctx     context.Context
+	s       interfaces.MinioService
+	files   *repository.FileRepository
+	key     *structs.APIKey
+	maxSize int
+	// contentLength заявленный размер тела PUT, -1 - неизвестен
+	contentLength int64
+	// stats кэш Stat на время запроса: PROPFIND запрашивает каждый элемент каталога несколько раз
+	stats map[string]*fileInfo
+}
+
+var _ webdav.FileSystem = (*fileSystem)(nil)
+
+// resolve ключ бакета по пути WebDAV, для корня пустая строка. Служебные хвосты tus не видны
+func (fs *fileSystem) resolve(name string) (string, error) {
+	key := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if key+"/" == minio.TusTailPrefix || strings.HasPrefix(key, minio.TusTailPrefix) {
+		return "", os.ErrNotExist
+	}
+	return key, nil
+}
+
+func (fs *fileSystem) Stat(_ context.Context, name string) (os.FileInfo, error) {
+	key, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.stat(key)
+}
+
+func (fs *fileSystem) stat(key string) (*fileInfo, error) {
+	if fi, ok := fs.stats[key]; ok {
+		return fi, nil
+	}
+	if key == "" {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+
+	head, err := fs.s.HeadObject(fs.ctx, key)
+	if err == nil {
+		rows, err := fs.files.FindFiles(fs.ctx, []string{key})
+		if err != nil {
+			return nil, err
+		}
+		row, ok := rows[key]
+		if ok && !fs.readable(&row) {
+			return nil, os.ErrNotExist
+		}
+		fi := &fileInfo{
+			name:        path.Base(key),
+			size:        originalSize(row, aws.Int64Value(head.ContentLength)),
+			modTime:     aws.TimeValue(head.LastModified),
+			etag:        aws.StringValue(head.ETag),
+			contentType: aws.StringValue(head.ContentType),
+		}
+		fs.stats[key] = fi
+		return fi, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+
+	page, err := fs.s.ListObjectsPage(fs.ctx, &s3.ListObjectsV2Input{
+		Prefix:  aws.String(key + "/"),
+		MaxKeys: aws.Int64(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Contents) == 0 && len(page.CommonPrefixes) == 0 {
+		return nil, os.ErrNotExist
+	}
+	fi := &fileInfo{name: path.Base(key), dir: true}
+	fs.stats[key] = fi
+	return fi, nil
+}
+
+func (fs *fileSystem) OpenFile(_ context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	key, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		return fs.create(key)
+	}
+
+	fi, err := fs.stat(key)
+	if err != nil {
+		return nil, err
+	}
+	if fi.dir {
+		return &dirFile{fs: fs, key: key, info: fi}, nil
+	}
+	return &readFile{ctx: fs.ctx, s: fs.s, key: key, info: fi}, nil
+}
+
+// create открывает файл на запись. Содержимое потоком уходит в UploadFileStream,
+// файл появляется в бакете после Close
+func (fs *fileSystem) create(key string) (webdav.File, error) {
+	if err := fs.checkWrite(key); err != nil {
+		return nil, err
+	}
+	left, err := fs.quotaLeft(key)
+	if err != nil {
+		return nil, err
+	}
+	fs.reset()
+	return newWriteFile(fs, key, left, fs.contentLength), nil
+}
+
+// checkPut проверяет до приема тела, что файл можно записать и size байт уместятся в квоту
+func (fs *fileSystem) checkPut(name string, size int64) error {
+	key, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	if err = fs.checkWrite(key); err != nil {
+		return err
+	}
+	left, err := fs.quotaLeft(key)
+	if err != nil {
+		return err
+	}
+	if size > left {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// checkWrite по пути key можно записать файл: имя допустимо, родительский каталог есть,
+// каталога с таким именем нет и файл не принадлежит другому владельцу
+func (fs *fileSystem) checkWrite(key string) error {
+	if err := upload.ValidateHeader(&structs.UploadHeader{Filename: key, Size: structs.UnknownSize}, fs.maxSize); err != nil {
+		return err
+	}
+	if fi, err := fs.stat(key); err == nil && fi.dir {
+		return os.ErrExist
+	}
+	if parent, err := fs.stat(path.Dir("/" + key)[1:]); err != nil {
+		return err
+	} else if !parent.dir {
+		return os.ErrNotExist
+	}
+	return fs.writable(key)
+}
+
+func (fs *fileSystem) Mkdir(_ context.Context, name string, _ os.FileMode) error {
+	key, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return os.ErrExist
+	}
+	if err = upload.ValidateHeader(&structs.UploadHeader{Filename: key, Size: structs.UnknownSize}, fs.maxSize); err != nil {
+		return err
+	}
+	if _, err = fs.stat(key); err == nil {
+		return os.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if parent, err := fs.stat(path.Dir("/" + key)[1:]); err != nil {
+		return err
+	} else if !parent.dir {
+		return os.ErrNotExist
+	}
+
+	fs.reset()
+	return fs.s.CreateDirectory(fs.ctx, key+"/")
+}
+
+// RemoveAll удаляет файл или каталог со всем содержимым. Права проверяются до удаления,
+// чтобы каталог не оказался удален частично
+func (fs *fileSystem) RemoveAll(_ context.Context, name string) error {
+	key, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return os.ErrPermission
+	}
+	fi, err := fs.stat(key)
+	if err != nil {
+		return err
+	}
+	fs.reset()
+
+	if !fi.dir {
+		if err = fs.writable(key); err != nil {
+			return err
+		}
+		return fs.s.DeleteObject(fs.ctx, key, deleteReason)
+	}
+
+	keys, err := fs.s.ListObjectKeys(fs.ctx, key+"/")
+	if err != nil {
+		return err
+	}
+	if err = fs.writableAll(keys); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err = fs.s.DeleteObject(fs.ctx, k, deleteReason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename переносит файл или каталог. S3 не умеет переименовывать, поэтому каждый объект
+// копируется внутри бакета и удаляется. Владелец файла переходит к копии
+func (fs *fileSystem) Rename(_ context.Context, oldName string, newName string) error {
+	src, err := fs.resolve(oldName)
+	if err != nil {
+		return err
+	}
+	dst, err := fs.resolve(newName)
+	if err != nil {
+		return err
+	}
+	if src == "" || dst == "" {
+		return os.ErrPermission
+	}
+	fi, err := fs.stat(src)
+	if err != nil {
+		return err
+	}
+
+	if !fi.dir {
+		if err = fs.checkWrite(dst); err != nil {
+			return err
+		}
+		if err = fs.writable(src); err != nil {
+			return err
+		}
+		if err = fs.checkMoveQuota([]string{src}, []string{dst}); err != nil {
+			return err
+		}
+		fs.reset()
+		return fs.move(src, dst)
+	}
+
+	if strings.HasPrefix(dst+"/", src+"/") {
+		return os.ErrInvalid
+	}
+	if err = upload.ValidateHeader(&structs.UploadHeader{Filename: dst, Size: structs.UnknownSize}, fs.maxSize); err != nil {
+		return err
+	}
+	keys, err := fs.s.ListObjectKeys(fs.ctx, src+"/")
+	if err != nil {
+		return err
+	}
+	targets := make([]string, len(keys))
+	for i, k := range keys {
+		targets[i] = dst + "/" + strings.TrimPrefix(k, src+"/")
+		// маркеры каталогов оканчиваются на "/", проверяем имя без него
+		if err = upload.ValidateHeader(&structs.UploadHeader{Filename: strings.TrimSuffix(targets[i], "/"), Size: structs.UnknownSize}, fs.maxSize); err != nil {
+			return err
+		}
+	}
+	// и перенос, и перезапись целей должны быть разрешены: иначе MOVE перезапишет файлы другого владельца
+	if err = fs.writableAll(keys); err != nil {
+		return err
+	}
+	if err = fs.writableAll(targets); err != nil {
+		return err
+	}
+	if err = fs.checkMoveQuota(keys, targets); err != nil {
+		return err
+	}
+	fs.reset()
+	for i, k := range keys {
+		target := targets[i]
+		if strings.HasSuffix(k, "/") {
+			// маркер каталога переносим без записи в files
+			if err = fs.s.CreateDirectory(fs.ctx, target); err != nil {
+				return err
+			}
+			err = fs.s.DeleteObject(fs.ctx, k, moveReason+target)
+		} else {
+			err = fs.move(k, target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *fileSystem) move(src string, dst string) error {
+	owners, err := fs.files.FindOwners(fs.ctx, []string{src})
+	if err != nil {
+		return err
+	}
+	if err = fs.s.CopyObject(fs.ctx, src, dst); err != nil {
+		return err
+	}
+	if owner, ok := owners[src]; ok {
+		if err = fs.files.SetFileOwner(fs.ctx, dst, owner); err != nil {
+			return err
+		}
+	}
+	return fs.s.DeleteObject(fs.ctx, src, moveReason+dst)
+}
+
+// readable файл виден клиенту
+func (fs *fileSystem) readable(f *structs.File) bool {
+	return fs.key == nil || !f.Owner.Valid || f.Owner.String == fs.key.Owner
+}
+
+// writable клиент может перезаписать или удалить файл key
+func (fs *fileSystem) writable(key string) error {
+	if fs.key == nil {
+		return nil
+	}
+	f := fs.files.FindFileByName(fs.ctx, key)
+	if f == nil {
+		return errors.New("unable to load file " + key)
+	}
+	if !f.WritableBy(fs.key.Owner) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+func (fs *fileSystem) writableAll(keys []string) error {
+	if fs.key == nil {
+		return nil
+	}
+	rows, err := fs.files.FindFiles(fs.ctx, keys)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if !row.WritableBy(fs.key.Owner) {
+			return os.ErrPermission
+		}
+	}
+	return nil
+}
+
+// quotaLeft сколько байт еще можно записать в файл key. Перезапись своего файла освобождает его прежний объем
+func (fs *fileSystem) quotaLeft(key string) (int64, error) {
+	if fs.key == nil || !fs.key.QuotaBytes.Valid {
+		return math.MaxInt64, nil
+	}
+	usage, err := fs.files.OwnerUsage(fs.ctx, fs.key.Owner)
+	if err != nil {
+		return 0, err
+	}
+	if f := fs.files.FindFileByName(fs.ctx, key); f != nil {
+		usage -= fs.freed(*f)
+	}
+	return max(fs.key.QuotaBytes.Int64-usage, 0), nil
+}
+
+// checkMoveQuota файлы переносятся по одному: копия, затем удаление источника. Пока источник не удален,
+// копия своего файла занимает квоту второй раз, поэтому каждая должна уместиться в остаток
+func (fs *fileSystem) checkMoveQuota(srcs []string, dsts []string) error {
+	if fs.key == nil || !fs.key.QuotaBytes.Valid {
+		return nil
+	}
+	sources, err := fs.files.FindFiles(fs.ctx, srcs)
+	if err != nil {
+		return err
+	}
+	targets, err := fs.files.FindFiles(fs.ctx, dsts)
+	if err != nil {
+		return err
+	}
+	usage, err := fs.files.OwnerUsage(fs.ctx, fs.key.Owner)
+	if err != nil {
+		return err
+	}
+	left := fs.key.QuotaBytes.Int64 - usage
+	for i, src := range srcs {
+		f, ok := sources[src]
+		// чужие и ничьи файлы переносятся без владельца и квоту не занимают
+		if !ok || !f.Owner.Valid || f.Owner.String != fs.key.Owner {
+			continue
+		}
+		if f.BytesTotal > left+fs.freed(targets[dsts[i]]) {
+			return errQuotaExceeded
+		}
+	}
+	return nil
+}
+
+// freed сколько квоты освобождает перезапись файла f: только свой загруженный файл
+func (fs *fileSystem) freed(f structs.File) int64 {
+	if f.Owner.Valid && f.Owner.String == fs.key.Owner && f.UploadStatus == structs.StatusReady {
+		return f.BytesTotal
+	}
+	return 0
+}
+
+// reset сбрасывает кэш Stat после изменения бакета
+func (fs *fileSystem) reset() {
+	clear(fs.stats)
+}
+
+// originalSize размер содержимого файла: для сжатых объектов размер в бакете меньше
+func originalSize(row structs.File, size int64) int64 {
+	if row.Codec.Valid && row.Codec.String != compress.NONE && row.OriginalSize.Valid {
+		return row.OriginalSize.Int64
+	}
+	return size
+}
+
+func isNotFound(err error) bool {
+	var reqErr awserr.RequestFailure
+	return errors.As(err, &reqErr) && reqErr.StatusCode() == 404
+}
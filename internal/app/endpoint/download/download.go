@@ -1,10 +1,21 @@
 package download
 
 import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"demo-storage/internal/app/interfaces"
+	minio "demo-storage/internal/app/service"
+	"demo-storage/internal/pkg/compress"
+
+	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/labstack/echo/v4"
 )
 
@@ -23,13 +34,129 @@ func (e *Endpoint) DownloadHandler(ctx echo.Context) error { // Source
 		return echo.NewHTTPError(http.StatusBadRequest, "Please provide file name")
 	}
 
-	res := e.s.DownloadFile(file)
-	defer res.Body.Close()
-	buff, err := io.ReadAll(res.Body)
+	res, err := e.s.DownloadFile(ctx.Request().Context(), file)
+	if errors.Is(err, minio.ErrObjectNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("File not found with name %s", file))
+	}
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Error reading objects")
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error reading file")
+	}
+	defer res.Body.Close()
+
+	contentType := aws.StringValue(res.ContentType)
+	if contentType == "" {
+		contentType = echo.MIMEOctetStream
+	}
+
+	var body io.Reader = res.Body
+	codec := minio.ObjectCodec(res)
+	switch {
+	case codec == compress.NONE:
+	case codec == compress.GZIP && acceptsGzip(ctx.Request()):
+		// Клиент умеет gzip, отдаем объект как есть без распаковки
+		ctx.Response().Header().Set(echo.HeaderContentEncoding, compress.GZIP)
+		ctx.Response().Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+	default:
+		reader, err := compress.NewReader(codec, res.Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error reading objects")
+		}
+		defer reader.Close()
+		body = reader
 	}
 
 	ctx.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename="+file)
-	return ctx.Blob(http.StatusOK, *res.ContentType, buff)
+	return ctx.Stream(http.StatusOK, contentType, body)
+}
+
+// ArchiveHandler отдает ZIP архив из списка файлов (?file=a&file=b) или всех файлов под префиксом (?prefix=dir/).
+// Архив формируется потоково, без буферизации в памяти
+func (e *Endpoint) ArchiveHandler(ctx echo.Context) error {
+	logger := logdoc.GetLogger()
+
+	files := ctx.QueryParams()["file"]
+	prefix := ctx.QueryParam("prefix")
+	if len(files) == 0 && prefix == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Please provide file names or prefix")
+	}
+
+	if prefix != "" {
+		keys, err := e.s.ListObjectKeys(ctx.Request().Context(), prefix)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error reading objects")
+		}
+		files = append(files, keys...)
+	}
+	if len(files) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "No files found with prefix ", prefix)
+	}
+
+	name := "archive.zip"
+	if prefix != "" {
+		name = strings.TrimSuffix(prefix, "/") + ".zip"
+	}
+	ctx.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	ctx.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename="+name)
+	ctx.Response().WriteHeader(http.StatusOK)
+
+	// После начала отдачи поменять статус ответа уже нельзя,
+	// поэтому ошибки только логируем и обрываем архив
+	zw := zip.NewWriter(ctx.Response())
+	for _, file := range files {
+		entry := strings.TrimPrefix(file, prefix)
+		if entry == "" || strings.HasSuffix(entry, "/") {
+			continue
+		}
+		if err := e.writeEntry(ctx.Request().Context(), zw, file, entry); err != nil {
+			logger.Error(fmt.Sprintf(">> ArchiveHandler > error adding %s to archive: %v", file, err))
+			return nil
+		}
+		ctx.Response().Flush()
+	}
+	if err := zw.Close(); err != nil {
+		logger.Error(fmt.Sprintf(">> ArchiveHandler > error closing archive: %v", err))
+	}
+	return nil
+}
+
+func (e *Endpoint) writeEntry(ctx context.Context, zw *zip.Writer, file string, entry string) error {
+	res, err := e.s.DownloadFile(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := compress.NewReader(minio.ObjectCodec(res), res.Body)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	header := &zip.FileHeader{Name: entry, Method: zip.Deflate}
+	if res.LastModified != nil {
+		header.Modified = *res.LastModified
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, body)
+	return err
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get(echo.HeaderAcceptEncoding), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) != compress.GZIP {
+			continue
+		}
+		// gzip;q=0 означает явный отказ клиента от gzip
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !found {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
 }
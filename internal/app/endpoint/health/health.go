@@ -0,0 +1,148 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"demo-storage/internal/app/interfaces"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/drain"
+	"demo-storage/internal/pkg/logging"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// Состояния проверки и сервиса в целом. Отказ обязательной проверки (база, бакет) - down,
+// отказ остальных - degraded: сервис принимает запросы, но требует внимания
+const (
+	StatusUp       = "up"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+type Endpoint struct {
+	s       interfaces.MinioService
+	db      *sqlx.DB
+	drain   *drain.Drainer
+	timeout time.Duration
+	// diskPath каталог, свободное место в котором проверяется, minFreeDisk - минимум свободных байт
+	diskPath    string
+	minFreeDisk uint64
+	// maxMemory предел памяти, полученной процессом у ОС, 0 - не проверять
+	maxMemory uint64
+}
+
+type Check struct {
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Latency  string `json:"latency"`
+	Error    string `json:"error,omitempty"`
+	// Details значения, по которым принято решение, например свободное место
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// check проверка готовности: ошибка - отказ, details попадают в ответ в любом случае
+type check struct {
+	name     string
+	critical bool
+	run      func(ctx context.Context) (map[string]any, error)
+}
+
+func New(s interfaces.MinioService, db *sqlx.DB, d *drain.Drainer, conf *config.Config) *Endpoint {
+	// Создаем endpoint и возвращаем
+	return &Endpoint{
+		s:           s,
+		db:          db,
+		drain:       d,
+		timeout:     conf.Health.Timeout,
+		diskPath:    conf.Health.DiskPath,
+		minFreeDisk: uint64(conf.Health.MinFreeDisk),
+		maxMemory:   uint64(conf.Health.MaxMemory),
+	}
+}
+
+// LivenessHandler процесс жив и обслуживает HTTP. Зависимости не проверяются,
+// чтобы оркестратор не перезапускал сервис из-за недоступной базы или S3
+func (e *Endpoint) LivenessHandler(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, Report{Status: StatusUp, Checks: map[string]Check{}})
+}
+
+// ReadinessHandler выполняет все проверки параллельно. 503 только если отказала обязательная проверка
+func (e *Endpoint) ReadinessHandler(ctx echo.Context) error {
+	report := e.run(ctx.Request().Context())
+	code := http.StatusOK
+	if report.Status == StatusDown {
+		code = http.StatusServiceUnavailable
+	}
+	return ctx.JSON(code, report)
+}
+
+func (e *Endpoint) checks() []check {
+	return []check{
+		{name: "postgres", critical: true, run: func(ctx context.Context) (map[string]any, error) {
+			return nil, e.db.PingContext(ctx)
+		}},
+		{name: "bucket", critical: true, run: func(ctx context.Context) (map[string]any, error) {
+			return map[string]any{"bucket": e.s.Bucket()}, e.s.PingBucket(ctx)
+		}},
+		// во время остановки балансировщик должен перестать слать новые запросы
+		{name: "shutdown", critical: true, run: func(context.Context) (map[string]any, error) {
+			if !e.drain.Draining() {
+				return nil, nil
+			}
+			return map[string]any{"active_uploads": e.drain.Active()}, drain.ErrDraining
+		}},
+		{name: "logdoc", run: func(context.Context) (map[string]any, error) {
+			configured, err := logging.LDStatus()
+			return map[string]any{"configured": configured}, err
+		}},
+		{name: "disk", run: e.checkDisk},
+		{name: "memory", run: e.checkMemory},
+	}
+}
+
+func (e *Endpoint) run(parent context.Context) Report {
+	ctx, cancel := context.WithTimeout(parent, e.timeout)
+	defer cancel()
+
+	checks := e.checks()
+	results := make([]Check, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			started := time.Now()
+			details, err := c.run(ctx)
+			res := Check{Status: StatusUp, Critical: c.critical, Latency: time.Since(started).String(), Details: details}
+			if err != nil {
+				res.Error = err.Error()
+				res.Status = StatusDegraded
+				if c.critical {
+					res.Status = StatusDown
+				}
+			}
+			results[i] = res
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusUp, Checks: make(map[string]Check, len(checks))}
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		switch {
+		case results[i].Status == StatusDown:
+			report.Status = StatusDown
+		case results[i].Status == StatusDegraded && report.Status == StatusUp:
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
@@ -0,0 +1,35 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// checkDisk свободное место в health.disk-path: там лежат PID файл и локальные логи
+func (e *Endpoint) checkDisk(context.Context) (map[string]any, error) {
+	free, err := freeDisk(e.diskPath)
+	if err != nil {
+		return nil, err
+	}
+	details := map[string]any{"path": e.diskPath, "freeBytes": free, "minFreeBytes": e.minFreeDisk}
+	if free < e.minFreeDisk {
+		return details, fmt.Errorf("only %d bytes free on %s, need at least %d", free, e.diskPath, e.minFreeDisk)
+	}
+	return details, nil
+}
+
+// checkMemory память, полученная рантаймом у ОС, против health.max-memory
+func (e *Endpoint) checkMemory(context.Context) (map[string]any, error) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	details := map[string]any{"sysBytes": m.Sys, "heapInuseBytes": m.HeapInuse, "goroutines": runtime.NumGoroutine()}
+	if e.maxMemory == 0 {
+		return details, nil
+	}
+	details["maxBytes"] = e.maxMemory
+	if m.Sys > e.maxMemory {
+		return details, fmt.Errorf("process holds %d bytes, limit is %d", m.Sys, e.maxMemory)
+	}
+	return details, nil
+}
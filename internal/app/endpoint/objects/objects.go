@@ -22,7 +22,7 @@ func (e *Endpoint) ObjectsHandler(ctx echo.Context) error { // Source
 		return echo.NewHTTPError(http.StatusBadRequest, "Please provide bucket name")
 	}
 
-	res := e.s.ListObjects(bucket)
+	res := e.s.ListObjects(ctx.Request().Context(), bucket)
 	if res == nil {
 		return ctx.String(http.StatusInternalServerError, "Ошибка получения данных")
 	} else {
@@ -0,0 +1,35 @@
+package reconcile
+
+import (
+	"net/http"
+
+	"demo-storage/internal/app/interfaces"
+	"github.com/labstack/echo/v4"
+)
+
+type Endpoint struct {
+	s interfaces.MinioService
+}
+
+func New(s interfaces.MinioService) *Endpoint {
+	// Создаем endpoint и возвращаем
+	return &Endpoint{s: s}
+}
+
+// ReportHandler только показывает расхождения бакета и БД
+func (e *Endpoint) ReportHandler(ctx echo.Context) error {
+	return e.reconcile(ctx, false)
+}
+
+// FixHandler показывает расхождения и исправляет их
+func (e *Endpoint) FixHandler(ctx echo.Context) error {
+	return e.reconcile(ctx, true)
+}
+
+func (e *Endpoint) reconcile(ctx echo.Context, fix bool) error {
+	res, err := e.s.Reconcile(ctx.Request().Context(), fix)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Reconciliation failed: "+err.Error())
+	}
+	return ctx.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,28 @@
+package reload
+
+import (
+	"net/http"
+
+	"demo-storage/internal/config"
+	"github.com/labstack/echo/v4"
+)
+
+type Endpoint struct {
+	reload func() (*config.Report, error)
+}
+
+// New reload перечитывает конфигурацию и применяет ее так же, как по SIGHUP
+func New(reload func() (*config.Report, error)) *Endpoint {
+	// Создаем endpoint и возвращаем
+	return &Endpoint{reload: reload}
+}
+
+// ReloadHandler перечитывает application.conf. Ошибки проверки возвращаются все сразу с 400,
+// действующие настройки при этом не меняются
+func (e *Endpoint) ReloadHandler(ctx echo.Context) error {
+	report, err := e.reload()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Configuration is not reloaded: "+err.Error())
+	}
+	return ctx.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,66 @@
+package s3gw
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"demo-storage/internal/app/endpoint/upload"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/config"
+)
+
+// Правила доступа шлюза:
+//   - объект другого владельца не виден и недоступен;
+//   - объекты без владельца (загруженные websocket, HTTP или tus) доступны всем ключам только на чтение;
+//   - записывать и удалять можно свои объекты и ключи, под которыми нет живого файла
+
+// canRead объект не принадлежит другому владельцу
+func (e *Endpoint) canRead(ctx context.Context, key *structs.APIKey, name string) (bool, error) {
+	owners, err := e.files.FindOwners(ctx, []string{name})
+	if err != nil {
+		return false, err
+	}
+	owner, ok := owners[name]
+	return !ok || owner == key.Owner, nil
+}
+
+// canWrite ключ может создать, перезаписать или удалить объект name
+func (e *Endpoint) canWrite(ctx context.Context, key *structs.APIKey, name string) (bool, error) {
+	f := e.files.FindFileByName(ctx, name)
+	if f == nil {
+		return false, errors.New("unable to load file " + name)
+	}
+	return f.WritableBy(key.Owner), nil
+}
+
+// checkQuota проверяет, что еще size байт под именем name уместятся в квоту владельца.
+// Перезапись своего файла освобождает его прежний объем
+func (e *Endpoint) checkQuota(ctx context.Context, key *structs.APIKey, name string, size int64) (bool, error) {
+	if !key.QuotaBytes.Valid {
+		return true, nil
+	}
+	usage, err := e.files.OwnerUsage(ctx, key.Owner)
+	if err != nil {
+		return false, err
+	}
+	if f := e.files.FindFileByName(ctx, name); f != nil && f.Owner.Valid && f.Owner.String == key.Owner && f.UploadStatus == structs.StatusReady {
+		usage -= f.BytesTotal
+	}
+	return usage+size <= key.QuotaBytes.Int64, nil
+}
+
+// validateKey проверяет ключ объекта теми же правилами, что и имя файла других способов загрузки.
+// S3 клиенты создают пустые объекты с "/" на конце как маркеры каталогов, их пропускаем
+func (e *Endpoint) validateKey(name string, size int64) *apiError {
+	header := &structs.UploadHeader{Filename: strings.TrimSuffix(name, "/"), Size: int(size)}
+	maxSize := config.GetConfig().Upload.MaxSize
+	err := upload.ValidateHeader(header, maxSize)
+	if err == nil || errors.Is(err, upload.ErrEmptyFile) {
+		return nil
+	}
+	if header.Size > 0 && maxSize > 0 && header.Size > maxSize {
+		return errEntityTooLarge
+	}
+	return &apiError{Status: errInvalidArgument.Status, Code: "InvalidObjectName", Message: err.Error()}
+}
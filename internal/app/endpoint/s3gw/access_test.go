@@ -0,0 +1,246 @@
+package s3gw
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"demo-storage/internal/app/interfaces"
+	"demo-storage/internal/app/structs"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/labstack/echo/v4"
+)
+
+// fakeFiles files в памяти с тем же подсчетом объема владельца, что и в OwnerUsage
+type fakeFiles struct {
+	files map[string]*structs.File
+}
+
+func newFakeFiles(files ...*structs.File) *fakeFiles {
+	f := &fakeFiles{files: map[string]*structs.File{}}
+	for i, file := range files {
+		file.Id = i + 1
+		f.files[file.Name] = file
+	}
+	return f
+}
+
+func (f *fakeFiles) FindFileByName(_ context.Context, name string) *structs.File {
+	if file, ok := f.files[name]; ok {
+		return file
+	}
+	return &structs.File{}
+}
+
+func (f *fakeFiles) FindOwners(_ context.Context, names []string) (map[string]string, error) {
+	owners := map[string]string{}
+	for _, name := range names {
+		if file, ok := f.files[name]; ok && file.Owner.Valid {
+			owners[name] = file.Owner.String
+		}
+	}
+	return owners, nil
+}
+
+func (f *fakeFiles) SetFileOwner(_ context.Context, name string, owner string) error {
+	f.files[name].Owner = sql.NullString{String: owner, Valid: true}
+	return nil
+}
+
+func (f *fakeFiles) OwnerUsage(_ context.Context, owner string) (int64, error) {
+	var usage int64
+	for _, file := range f.files {
+		switch file.UploadStatus {
+		case structs.StatusUploading, structs.StatusAssembling, structs.StatusProcessing, structs.StatusReady:
+			if file.Owner.Valid && file.Owner.String == owner {
+				usage += max(file.BytesTotal, file.BytesUploaded)
+			}
+		}
+	}
+	return usage, nil
+}
+
+func (f *fakeFiles) UpdateFileSize(_ context.Context, name string, size int64) sql.Result {
+	f.files[name].BytesTotal = size
+	return nil
+}
+
+func (f *fakeFiles) AddUploadedBytes(_ context.Context, name string, n int) sql.Result {
+	f.files[name].BytesUploaded += int64(n)
+	return nil
+}
+
+// fakeParts multipart загрузка в памяти: как и хранилище, повтор части заменяет прежнюю,
+// а загруженные байты учитываются на каждую успешную загрузку части
+type fakeParts struct {
+	interfaces.MinioService
+	files *fakeFiles
+	parts map[int]int64
+}
+
+func (s *fakeParts) MultipartSession(key string, uploadId string) (*s3.S3, *s3.CreateMultipartUploadOutput) {
+	return nil, &s3.CreateMultipartUploadOutput{Key: aws.String(key), UploadId: aws.String(uploadId)}
+}
+
+func (s *fakeParts) UploadedPartSize(_ context.Context, _ string, _ string, partNum int) (int64, error) {
+	return s.parts[partNum], nil
+}
+
+func (s *fakeParts) UploadPartToS3(ctx context.Context, _ *s3.S3, session *s3.CreateMultipartUploadOutput, data []byte, partNum int) structs.PartUploadResult {
+	s.parts[partNum] = int64(len(data))
+	s.files.AddUploadedBytes(ctx, aws.StringValue(session.Key), len(data))
+	return structs.PartUploadResult{CompletedPart: &s3.CompletedPart{
+		ETag:       aws.String(fmt.Sprintf(`"part-%d"`, partNum)),
+		PartNumber: aws.Int64(int64(partNum)),
+	}}
+}
+
+func owned(name string, owner string, status structs.FileStatus, total int64, uploaded int64) *structs.File {
+	return &structs.File{
+		Name:          name,
+		Owner:         sql.NullString{String: owner, Valid: owner != ""},
+		UploadStatus:  status,
+		BytesTotal:    total,
+		BytesUploaded: uploaded,
+	}
+}
+
+func quota(n int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: n, Valid: true}
+}
+
+func TestCheckQuota(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []*structs.File
+		quota sql.NullInt64
+		key   string
+		size  int64
+		want  bool
+	}{
+		{
+			name:  "no quota",
+			files: []*structs.File{owned("a", "alice", structs.StatusReady, 100, 100)},
+			key:   "new",
+			size:  1 << 40,
+			want:  true,
+		},
+		{
+			name:  "fits",
+			files: []*structs.File{owned("a", "alice", structs.StatusReady, 60, 60)},
+			quota: quota(100),
+			key:   "new",
+			size:  40,
+			want:  true,
+		},
+		{
+			name:  "exceeds",
+			files: []*structs.File{owned("a", "alice", structs.StatusReady, 60, 60)},
+			quota: quota(100),
+			key:   "new",
+			size:  41,
+		},
+		{
+			name:  "uploading files count by uploaded bytes",
+			files: []*structs.File{owned("a", "alice", structs.StatusUploading, 0, 70)},
+			quota: quota(100),
+			key:   "new",
+			size:  31,
+		},
+		{
+			name: "failed and deleted files are free",
+			files: []*structs.File{
+				owned("a", "alice", structs.StatusFailed, 60, 60),
+				owned("b", "alice", structs.StatusDeleted, 60, 60),
+			},
+			quota: quota(100),
+			key:   "new",
+			size:  100,
+			want:  true,
+		},
+		{
+			name:  "other owners are not counted",
+			files: []*structs.File{owned("a", "bob", structs.StatusReady, 90, 90)},
+			quota: quota(100),
+			key:   "new",
+			size:  100,
+			want:  true,
+		},
+		{
+			name:  "overwrite frees the previous size",
+			files: []*structs.File{owned("a", "alice", structs.StatusReady, 80, 80)},
+			quota: quota(100),
+			key:   "a",
+			size:  90,
+			want:  true,
+		},
+		{
+			name:  "overwrite of an unfinished upload frees nothing",
+			files: []*structs.File{owned("a", "alice", structs.StatusUploading, 80, 40)},
+			quota: quota(100),
+			key:   "a",
+			size:  30,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Endpoint{files: newFakeFiles(tt.files...)}
+			key := &structs.APIKey{Owner: "alice", QuotaBytes: tt.quota}
+			ok, err := e.checkQuota(context.Background(), key, tt.key, tt.size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.want {
+				t.Errorf("checkQuota = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadPartRetry(t *testing.T) {
+	files := newFakeFiles(owned("big", "alice", structs.StatusUploading, 0, 0))
+	e := &Endpoint{
+		s:           &fakeParts{files: files, parts: map[int]int64{}},
+		files:       files,
+		maxPartSize: 1 << 20,
+	}
+	key := &structs.APIKey{Owner: "alice", QuotaBytes: quota(10)}
+
+	steps := []struct {
+		part     int
+		size     int
+		status   int
+		uploaded int64
+	}{
+		{part: 1, size: 6, status: http.StatusOK, uploaded: 6},
+		// клиент не получил ответ и повторил ту же часть: в квоте она по-прежнему одна
+		{part: 1, size: 6, status: http.StatusOK, uploaded: 6},
+		{part: 1, size: 4, status: http.StatusOK, uploaded: 4},
+		{part: 2, size: 6, status: http.StatusOK, uploaded: 10},
+		{part: 3, size: 1, status: http.StatusForbidden, uploaded: 10},
+		{part: 2, size: 5, status: http.StatusOK, uploaded: 9},
+	}
+	for i, step := range steps {
+		url := fmt.Sprintf("/s3/bucket/big?partNumber=%d&uploadId=u1", step.part)
+		req := httptest.NewRequest(http.MethodPut, url, bytes.NewReader(make([]byte, step.size)))
+		rec := httptest.NewRecorder()
+		ctx := echo.New().NewContext(req, rec)
+		ctx.Set(keyContext, key)
+
+		if err := e.uploadPart(ctx, "big"); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if rec.Code != step.status {
+			t.Errorf("step %d: part %d of %d bytes: status %d, want %d: %s", i, step.part, step.size, rec.Code, step.status, rec.Body)
+		}
+		if got := files.files["big"].BytesUploaded; got != step.uploaded {
+			t.Errorf("step %d: uploaded %d bytes, want %d", i, got, step.uploaded)
+		}
+	}
+}
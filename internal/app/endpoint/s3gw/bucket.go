@@ -0,0 +1,205 @@
+package s3gw
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/labstack/echo/v4"
+)
+
+const maxKeys = 1000
+
+// BucketHandler операции над бакетом: HeadBucket, GetBucketLocation, ListObjects и ListObjectsV2
+func (e *Endpoint) BucketHandler(ctx echo.Context) error {
+	if ctx.Param("bucket") != e.s.Bucket() {
+		return e.fail(ctx, errNoSuchBucket)
+	}
+
+	query := ctx.QueryParams()
+	switch ctx.Request().Method {
+	case http.MethodHead:
+		return ctx.NoContent(http.StatusOK)
+	case http.MethodGet:
+		switch {
+		case query.Has("location"):
+			return ctx.XML(http.StatusOK, locationConstraint{Xmlns: s3Namespace})
+		case query.Has("uploads"), query.Has("versions"), query.Has("acl"), query.Has("policy"):
+			return e.fail(ctx, errNotImplemented)
+		case query.Get("list-type") == "2":
+			return e.listObjectsV2(ctx, query)
+		default:
+			return e.listObjectsV1(ctx, query)
+		}
+	case http.MethodPut, http.MethodDelete:
+		// бакет один и управляется хранилищем, создавать и удалять бакеты через шлюз нельзя
+		return e.fail(ctx, errAccessDenied)
+	default:
+		return e.fail(ctx, errMethodNotAllowed)
+	}
+}
+
+func (e *Endpoint) listObjectsV2(ctx echo.Context, query url.Values) error {
+	limit, apiErr := parseMaxKeys(query)
+	if apiErr != nil {
+		return e.fail(ctx, apiErr)
+	}
+
+	input := &s3.ListObjectsV2Input{MaxKeys: aws.Int64(limit)}
+	setIfPresent(&input.Prefix, query.Get("prefix"))
+	setIfPresent(&input.Delimiter, query.Get("delimiter"))
+	setIfPresent(&input.ContinuationToken, query.Get("continuation-token"))
+	setIfPresent(&input.StartAfter, query.Get("start-after"))
+
+	page, err := e.s.ListObjectsPage(ctx.Request().Context(), input)
+	if err != nil {
+		return e.storageError(ctx, err)
+	}
+	contents, prefixes, err := e.visible(ctx, page)
+	if err != nil {
+		return e.storageError(ctx, err)
+	}
+
+	encode := encoder(query.Get("encoding-type"))
+	return ctx.XML(http.StatusOK, listBucketResultV2{
+		Xmlns:                 s3Namespace,
+		Name:                  e.s.Bucket(),
+		Prefix:                encode(query.Get("prefix")),
+		Delimiter:             encode(query.Get("delimiter")),
+		MaxKeys:               limit,
+		KeyCount:              len(contents) + len(prefixes),
+		IsTruncated:           aws.BoolValue(page.IsTruncated),
+		ContinuationToken:     query.Get("continuation-token"),
+		NextContinuationToken: aws.StringValue(page.NextContinuationToken),
+		StartAfter:            encode(query.Get("start-after")),
+		EncodingType:          query.Get("encoding-type"),
+		Contents:              encodeObjects(contents, encode),
+		CommonPrefixes:        encodePrefixes(prefixes, encode),
+	})
+}
+
+// listObjectsV1 старый листинг с marker. Его по умолчанию используют rclone и s3fs для
+// S3 совместимых хранилищ. Marker соответствует StartAfter листинга V2
+func (e *Endpoint) listObjectsV1(ctx echo.Context, query url.Values) error {
+	limit, apiErr := parseMaxKeys(query)
+	if apiErr != nil {
+		return e.fail(ctx, apiErr)
+	}
+
+	input := &s3.ListObjectsV2Input{MaxKeys: aws.Int64(limit)}
+	setIfPresent(&input.Prefix, query.Get("prefix"))
+	setIfPresent(&input.Delimiter, query.Get("delimiter"))
+	setIfPresent(&input.StartAfter, query.Get("marker"))
+
+	page, err := e.s.ListObjectsPage(ctx.Request().Context(), input)
+	if err != nil {
+		return e.storageError(ctx, err)
+	}
+
+	// Следующий marker - последний ключ или префикс страницы до фильтрации по владельцу
+	var nextMarker string
+	if aws.BoolValue(page.IsTruncated) {
+		for _, object := range page.Contents {
+			nextMarker = max(nextMarker, aws.StringValue(object.Key))
+		}
+		for _, prefix := range page.CommonPrefixes {
+			nextMarker = max(nextMarker, aws.StringValue(prefix.Prefix))
+		}
+	}
+
+	contents, prefixes, err := e.visible(ctx, page)
+	if err != nil {
+		return e.storageError(ctx, err)
+	}
+
+	encode := encoder(query.Get("encoding-type"))
+	return ctx.XML(http.StatusOK, listBucketResultV1{
+		Xmlns:          s3Namespace,
+		Name:           e.s.Bucket(),
+		Prefix:         encode(query.Get("prefix")),
+		Marker:         encode(query.Get("marker")),
+		NextMarker:     encode(nextMarker),
+		Delimiter:      encode(query.Get("delimiter")),
+		MaxKeys:        limit,
+		IsTruncated:    aws.BoolValue(page.IsTruncated),
+		EncodingType:   query.Get("encoding-type"),
+		Contents:       encodeObjects(contents, encode),
+		CommonPrefixes: encodePrefixes(prefixes, encode),
+	})
+}
+
+// visible убирает из страницы объекты других владельцев
+func (e *Endpoint) visible(ctx echo.Context, page *s3.ListObjectsV2Output) ([]object, []commonPrefix, error) {
+	key := apiKey(ctx)
+
+	names := make([]string, 0, len(page.Contents))
+	for _, o := range page.Contents {
+		names = append(names, aws.StringValue(o.Key))
+	}
+	owners, err := e.files.FindOwners(ctx.Request().Context(), names)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contents := []object{}
+	for _, o := range page.Contents {
+		if owner, ok := owners[aws.StringValue(o.Key)]; ok && owner != key.Owner {
+			continue
+		}
+		contents = append(contents, object{
+			Key:          aws.StringValue(o.Key),
+			LastModified: aws.TimeValue(o.LastModified).UTC(),
+			ETag:         aws.StringValue(o.ETag),
+			Size:         aws.Int64Value(o.Size),
+			StorageClass: "STANDARD",
+		})
+	}
+
+	prefixes := []commonPrefix{}
+	for _, p := range page.CommonPrefixes {
+		prefixes = append(prefixes, commonPrefix{Prefix: aws.StringValue(p.Prefix)})
+	}
+	return contents, prefixes, nil
+}
+
+func parseMaxKeys(query url.Values) (int64, *apiError) {
+	if !query.Has("max-keys") {
+		return maxKeys, nil
+	}
+	limit, err := strconv.ParseInt(query.Get("max-keys"), 10, 64)
+	if err != nil || limit < 0 {
+		return 0, errInvalidArgument.withMessage("Invalid max-keys")
+	}
+	return min(limit, maxKeys), nil
+}
+
+func setIfPresent(field **string, value string) {
+	if value != "" {
+		*field = aws.String(value)
+	}
+}
+
+// encoder при encoding-type=url кодирует ключи в ответе, клиенты (aws cli) раскодируют их сами
+func encoder(encodingType string) func(string) string {
+	if strings.EqualFold(encodingType, "url") {
+		return url.QueryEscape
+	}
+	return func(s string) string { return s }
+}
+
+func encodeObjects(objects []object, encode func(string) string) []object {
+	for i := range objects {
+		objects[i].Key = encode(objects[i].Key)
+	}
+	return objects
+}
+
+func encodePrefixes(prefixes []commonPrefix, encode func(string) string) []commonPrefix {
+	for i := range prefixes {
+		prefixes[i].Prefix = encode(prefixes[i].Prefix)
+	}
+	return prefixes
+}
@@ -0,0 +1,170 @@
+package s3gw
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"demo-storage/internal/app/metrics"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/pkg/compress"
+	"demo-storage/internal/pkg/logging"
+
+	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxPartNumber = 10000
+	// maxCompleteBody CompleteMultipartUpload со всеми 10000 частями укладывается в 1-2 MiB
+	maxCompleteBody = 4 << 20
+)
+
+// createMultipartUpload открывает multipart сессию хранилища. Ее UploadId клиент использует как есть
+func (e *Endpoint) createMultipartUpload(ctx echo.Context, name string) error {
+	logger := logdoc.GetLogger()
+	key := apiKey(ctx)
+
+	if apiErr := e.validateKey(name, structs.UnknownSize); apiErr != nil {
+		return e.fail(ctx, apiErr)
+	}
+	if ok, err := e.canWrite(ctx.Request().Context(), key, name); err != nil || !ok {
+		return e.denied(ctx, err, errAccessDenied)
+	}
+
+	// размер заранее неизвестен, квота проверяется по мере загрузки частей
+	_, session, err := e.s.CreateMultipartSession(ctx.Request().Context(), name, 0, compress.NONE)
+	if err != nil {
+		return e.storageError(ctx, err)
+	}
+	if err = e.files.SetFileOwner(ctx.Request().Context(), name, key.Owner); err != nil {
+		logger.Error(fmt.Sprintf(">> S3 gateway > unable to set owner of %s: %v", name, err))
+	}
+
+	return ctx.XML(http.StatusOK, initiateMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Bucket:   e.s.Bucket(),
+		Key:      name,
+		UploadId: aws.StringValue(session.UploadId),
+	})
+}
+
+// uploadPart буферизует часть в памяти (не больше s3gateway.max-part-size) и загружает ее
+// с повторами, как части websocket загрузки
+func (e *Endpoint) uploadPart(ctx echo.Context, name string) error {
+	key := apiKey(ctx)
+	query := ctx.QueryParams()
+
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		return e.fail(ctx, errInvalidArgument.withMessage("Part number must be an integer between 1 and 10000, inclusive"))
+	}
+	size, apiErr := contentLength(ctx.Request())
+	if apiErr != nil {
+		return e.fail(ctx, apiErr)
+	}
+	if size > int64(e.maxPartSize) {
+		return e.fail(ctx, errEntityTooLarge.withMessage(fmt.Sprintf("Part size must not exceed %d bytes", e.maxPartSize)))
+	}
+	if ok, err := e.canWrite(ctx.Request().Context(), key, name); err != nil || !ok {
+		return e.denied(ctx, err, errAccessDenied)
+	}
+	// Повтор части заменяет прежнюю: в квоте и в загруженных байтах учитывается только разница
+	uploadId := query.Get("uploadId")
+	previous, err := e.s.UploadedPartSize(ctx.Request().Context(), name, uploadId, partNumber)
+	if err != nil {
+		return e.storageError(ctx, err)
+	}
+	if ok, err := e.checkQuota(ctx.Request().Context(), key, name, size-previous); err != nil || !ok {
+		return e.denied(ctx, err, errQuotaExceeded)
+	}
+	defer metrics.StartUploadSession(metrics.ProtocolS3)()
+
+	// Читаем тело до конца: только так проверяется его контрольная сумма или подпись
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if _, err = buf.ReadFrom(io.LimitReader(ctx.Request().Body, size+1)); err != nil {
+		return e.storageError(ctx, err)
+	}
+	if int64(buf.Len()) != size {
+		return e.fail(ctx, &apiError{Status: http.StatusBadRequest, Code: "IncompleteBody", Message: "The request body does not match Content-Length"})
+	}
+
+	conn, session := e.s.MultipartSession(name, uploadId)
+	reqCtx := logging.WithFields(ctx.Request().Context(), logrus.Fields{logging.FieldFile: name, logging.FieldSession: uploadId})
+	res := e.s.UploadPartToS3(reqCtx, conn, session, buf.Bytes(), partNumber)
+	if res.Err != nil {
+		return e.storageError(ctx, res.Err)
+	}
+	if previous > 0 {
+		e.files.AddUploadedBytes(context.WithoutCancel(reqCtx), name, -int(previous))
+	}
+
+	ctx.Response().Header().Set("ETag", aws.StringValue(res.CompletedPart.ETag))
+	return ctx.NoContent(http.StatusOK)
+}
+
+func (e *Endpoint) completeMultipartUpload(ctx echo.Context, name string) error {
+	logger := logdoc.GetLogger()
+
+	if ok, err := e.canWrite(ctx.Request().Context(), apiKey(ctx), name); err != nil || !ok {
+		return e.denied(ctx, err, errAccessDenied)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(ctx.Request().Body, maxCompleteBody))
+	if err != nil {
+		return e.storageError(ctx, err)
+	}
+	var request completeMultipartUpload
+	if err = xml.Unmarshal(data, &request); err != nil || len(request.Parts) == 0 {
+		return e.fail(ctx, errMalformedXML)
+	}
+
+	parts := make([]*s3.CompletedPart, 0, len(request.Parts))
+	for i, part := range request.Parts {
+		if part.PartNumber < 1 || part.PartNumber > maxPartNumber || i > 0 && part.PartNumber <= request.Parts[i-1].PartNumber {
+			return e.fail(ctx, &apiError{Status: http.StatusBadRequest, Code: "InvalidPartOrder", Message: "The list of parts was not in ascending order"})
+		}
+		parts = append(parts, &s3.CompletedPart{PartNumber: aws.Int64(part.PartNumber), ETag: aws.String(part.ETag)})
+	}
+
+	conn, session := e.s.MultipartSession(name, ctx.QueryParam("uploadId"))
+	if err = e.s.CompleteMultipartUpload(ctx.Request().Context(), conn, session, parts); err != nil {
+		return e.storageError(ctx, err)
+	}
+
+	// размер файла становится известен только после сборки
+	head, err := e.s.HeadObject(ctx.Request().Context(), name)
+	if err != nil {
+		return e.storageError(ctx, err)
+	}
+	if e.files.UpdateFileSize(ctx.Request().Context(), name, aws.Int64Value(head.ContentLength)) == nil {
+		logger.Error(fmt.Sprintf(">> S3 gateway > unable to update size of %s", name))
+	}
+
+	return ctx.XML(http.StatusOK, completeMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Location: fmt.Sprintf("%s://%s%s", ctx.Scheme(), ctx.Request().Host, ctx.Request().URL.Path),
+		Bucket:   e.s.Bucket(),
+		Key:      name,
+		ETag:     aws.StringValue(head.ETag),
+	})
+}
+
+func (e *Endpoint) abortMultipartUpload(ctx echo.Context, name string) error {
+	if ok, err := e.canWrite(ctx.Request().Context(), apiKey(ctx), name); err != nil || !ok {
+		return e.denied(ctx, err, errAccessDenied)
+	}
+
+	conn, session := e.s.MultipartSession(name, ctx.QueryParam("uploadId"))
+	if err := e.s.AbortMultipartUpload(ctx.Request().Context(), conn, session, structs.StatusCancelled, "aborted by S3 client"); err != nil {
+		return e.storageError(ctx, err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
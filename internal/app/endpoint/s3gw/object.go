@@ -0,0 +1,276 @@
+package s3gw
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"demo-storage/internal/app/metrics"
+	minio "demo-storage/internal/app/service"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/pkg/compress"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/labstack/echo/v4"
+)
+
+// ObjectHandler операции над объектом и его multipart загрузкой
+func (e *Endpoint) ObjectHandler(ctx echo.Context) error {
+	if ctx.Param("bucket") != e.s.Bucket() {
+		return e.fail(ctx, errNoSuchBucket)
+	}
+	// ключ берем из пути целиком: в нем могут быть любые символы, включая "/"
+	name := strings.TrimPrefix(ctx.Request().URL.Path, Prefix+"/"+ctx.Param("bucket")+"/")
+	if name == "" {
+		return e.BucketHandler(ctx)
+	}
+
+	query := ctx.QueryParams()
+	req := ctx.Request()
+	switch req.Method {
+	case http.MethodHead:
+		return e.headObject(ctx, name)
+	case http.MethodGet:
+		if query.Has("uploadId") || query.Has("acl") || query.Has("tagging") {
+			return e.fail(ctx, errNotImplemented)
+		}
+		return e.getObject(ctx, name)
+	case http.MethodPut:
+		if req.Header.Get("X-Amz-Copy-Source") != "" || query.Has("acl") || query.Has("tagging") {
+			return e.fail(ctx, errNotImplemented)
+		}
+		if query.Has("uploadId") {
+			return e.uploadPart(ctx, name)
+		}
+		return e.putObject(ctx, name)
+	case http.MethodPost:
+		if query.Has("uploads") {
+			return e.createMultipartUpload(ctx, name)
+		}
+		if query.Has("uploadId") {
+			return e.completeMultipartUpload(ctx, name)
+		}
+		return e.fail(ctx, errNotImplemented)
+	case http.MethodDelete:
+		if query.Has("uploadId") {
+			return e.abortMultipartUpload(ctx, name)
+		}
+		return e.deleteObject(ctx, name)
+	default:
+		return e.fail(ctx, errMethodNotAllowed)
+	}
+}
+
+func (e *Endpoint) headObject(ctx echo.Context, name string) error {
+	if ok, err := e.canRead(ctx.Request().Context(), apiKey(ctx), name); err != nil || !ok {
+		return e.denied(ctx, err, errNoSuchKey)
+	}
+
+	head, err := e.s.HeadObject(ctx.Request().Context(), name)
+	if err != nil {
+		return e.storageError(ctx, err)
+	}
+
+	res := ctx.Response().Header()
+	res.Set(echo.HeaderContentType, aws.StringValue(head.ContentType))
+	res.Set(echo.HeaderContentLength, strconv.FormatInt(originalSize(head.Metadata, aws.Int64Value(head.ContentLength)), 10))
+	setObjectHeaders(res, head.ETag, head.LastModified, head.Metadata)
+	return ctx.NoContent(http.StatusOK)
+}
+
+func (e *Endpoint) getObject(ctx echo.Context, name string) error {
+	if ok, err := e.canRead(ctx.Request().Context(), apiKey(ctx), name); err != nil || !ok {
+		return e.denied(ctx, err, errNoSuchKey)
+	}
+
+	byteRange := ctx.Request().Header.Get("Range")
+	object, err := e.s.GetObject(ctx.Request().Context(), name, byteRange)
+	if err != nil {
+		return e.storageError(ctx, err)
+	}
+	// Диапазон сжатого объекта задан в байтах исходных данных: читаем объект целиком
+	// и отдаем нужный кусок распакованного потока
+	codec := minio.ObjectCodec(object)
+	if codec != compress.NONE && byteRange != "" {
+		object.Body.Close()
+		if object, err = e.s.GetObject(ctx.Request().Context(), name, ""); err != nil {
+			return e.storageError(ctx, err)
+		}
+	}
+	defer object.Body.Close()
+
+	reader, err := compress.NewReader(codec, object.Body)
+	if err != nil {
+		return e.storageError(ctx, err)
+	}
+	defer reader.Close()
+
+	res := ctx.Response().Header()
+	status := http.StatusOK
+	var body io.Reader = reader
+	switch size := originalSize(object.Metadata, -1); {
+	case codec == compress.NONE:
+		res.Set(echo.HeaderContentLength, strconv.FormatInt(aws.Int64Value(object.ContentLength), 10))
+		if object.ContentRange != nil {
+			res.Set("Content-Range", *object.ContentRange)
+			status = http.StatusPartialContent
+		}
+	case byteRange != "":
+		start, end, ok := parseRange(byteRange, size)
+		if !ok {
+			return e.fail(ctx, errInvalidRange)
+		}
+		if _, err = io.CopyN(io.Discard, reader, start); err != nil {
+			return e.storageError(ctx, err)
+		}
+		body = io.LimitReader(reader, end-start+1)
+		res.Set(echo.HeaderContentLength, strconv.FormatInt(end-start+1, 10))
+		res.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		status = http.StatusPartialContent
+	case size >= 0:
+		res.Set(echo.HeaderContentLength, strconv.FormatInt(size, 10))
+	}
+	setObjectHeaders(res, object.ETag, object.LastModified, object.Metadata)
+	return ctx.Stream(status, aws.StringValue(object.ContentType), body)
+}
+
+// parseRange разбирает заголовок Range вида bytes=a-b, bytes=a- или bytes=-n для объекта размера size.
+// Несколько диапазонов S3 не поддерживает. Возвращает границы включительно
+func parseRange(header string, size int64) (int64, int64, bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || size <= 0 || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		return max(size-n, 0), size - 1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	if last == "" {
+		return start, size - 1, true
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, min(end, size-1), true
+}
+
+func (e *Endpoint) putObject(ctx echo.Context, name string) error {
+	key := apiKey(ctx)
+
+	size, apiErr := contentLength(ctx.Request())
+	if apiErr != nil {
+		return e.fail(ctx, apiErr)
+	}
+	if apiErr = e.validateKey(name, size); apiErr != nil {
+		return e.fail(ctx, apiErr)
+	}
+	if ok, err := e.canWrite(ctx.Request().Context(), key, name); err != nil || !ok {
+		return e.denied(ctx, err, errAccessDenied)
+	}
+	if ok, err := e.checkQuota(ctx.Request().Context(), key, name, size); err != nil || !ok {
+		return e.denied(ctx, err, errQuotaExceeded)
+	}
+
+	header := &structs.UploadHeader{
+		Filename:    name,
+		Size:        int(size),
+		ContentType: ctx.Request().Header.Get(echo.HeaderContentType),
+		Owner:       key.Owner,
+	}
+	finished := metrics.StartUploadSession(metrics.ProtocolS3)
+	uploaded, err := e.s.UploadFileStream(ctx.Request().Context(), header, "", ctx.Request().Body)
+	finished()
+	if err != nil {
+		return e.storageError(ctx, err)
+	}
+	if uploaded.ETag != nil {
+		ctx.Response().Header().Set("ETag", *uploaded.ETag)
+	}
+	return ctx.NoContent(http.StatusOK)
+}
+
+func (e *Endpoint) deleteObject(ctx echo.Context, name string) error {
+	key := apiKey(ctx)
+
+	f := e.files.FindFileByName(ctx.Request().Context(), name)
+	if f != nil && f.Id == 0 {
+		// S3 отвечает успехом и на удаление несуществующего ключа
+		return ctx.NoContent(http.StatusNoContent)
+	}
+	if ok, err := e.canWrite(ctx.Request().Context(), key, name); err != nil || !ok {
+		return e.denied(ctx, err, errAccessDenied)
+	}
+
+	if err := e.s.DeleteObject(ctx.Request().Context(), name, "deleted by S3 gateway"); err != nil {
+		return e.storageError(ctx, err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// denied отвечает ошибкой проверки доступа или, если проверить не удалось, внутренней ошибкой
+func (e *Endpoint) denied(ctx echo.Context, err error, apiErr *apiError) error {
+	if err != nil {
+		return e.storageError(ctx, err)
+	}
+	return e.fail(ctx, apiErr)
+}
+
+// contentLength размер тела без обертки aws-chunked
+func contentLength(req *http.Request) (int64, *apiError) {
+	if decoded := req.Header.Get(headerDecodedLength); decoded != "" {
+		size, err := strconv.ParseInt(decoded, 10, 64)
+		if err != nil || size < 0 {
+			return 0, errInvalidArgument.withMessage("Invalid " + headerDecodedLength)
+		}
+		return size, nil
+	}
+	if req.ContentLength < 0 {
+		return 0, errMissingLength
+	}
+	return req.ContentLength, nil
+}
+
+// originalSize размер содержимого до сжатия, если объект хранится сжатым
+func originalSize(metadata map[string]*string, size int64) int64 {
+	for k, v := range metadata {
+		if http.CanonicalHeaderKey(k) == minio.MetaOriginalSize {
+			if original, err := strconv.ParseInt(aws.StringValue(v), 10, 64); err == nil {
+				return original
+			}
+		}
+	}
+	return size
+}
+
+// setObjectHeaders общие заголовки Head/GetObject. Служебные метаданные сжатия клиенту не отдаем
+func setObjectHeaders(res http.Header, etag *string, lastModified *time.Time, metadata map[string]*string) {
+	res.Set("Accept-Ranges", "bytes")
+	if etag != nil {
+		res.Set("ETag", *etag)
+	}
+	if lastModified != nil {
+		res.Set(echo.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+	for k, v := range metadata {
+		switch http.CanonicalHeaderKey(k) {
+		case minio.MetaCodec, minio.MetaOriginalSize:
+			continue
+		}
+		res.Set("X-Amz-Meta-"+k, aws.StringValue(v))
+	}
+}
@@ -0,0 +1,156 @@
+package s3gw
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxChunkSize ограничение размера одной части aws-chunked, клиенты шлют по 64 KiB - 1 MiB
+const maxChunkSize = 16 << 20
+
+var (
+	errPayloadHash      = errors.New("payload sha256 does not match x-amz-content-sha256")
+	errChunkSignature   = errors.New("chunk signature does not match")
+	errChunkedMalformed = errors.New("malformed aws-chunked payload")
+)
+
+// hashedReader проверяет sha256 тела, заявленный в x-amz-content-sha256.
+// Несовпадение обнаруживается только в конце тела, поэтому ошибка возвращается вместо io.EOF,
+// и загрузка в хранилище прерывается до фиксации объекта
+type hashedReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected string
+	err      error
+}
+
+func newHashedReader(r io.Reader, expected string) *hashedReader {
+	return &hashedReader{r: r, hash: sha256.New(), expected: strings.ToLower(expected)}
+}
+
+func (h *hashedReader) Read(p []byte) (int, error) {
+	if h.err != nil {
+		return 0, h.err
+	}
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+	if errors.Is(err, io.EOF) && hex.EncodeToString(h.hash.Sum(nil)) != h.expected {
+		h.err = errPayloadHash
+		return n, h.err
+	}
+	return n, err
+}
+
+// chunkedReader декодирует тело в формате aws-chunked:
+//
+//	hex(размер)[;chunk-signature=подпись]\r\n данные \r\n ... 0[;chunk-signature=подпись]\r\n [трейлеры] \r\n
+//
+// Для подписанного варианта каждая часть проверяется цепочкой подписей от подписи запроса.
+// Трейлеры (x-amz-checksum-*) пропускаются, их подпись не проверяется
+type chunkedReader struct {
+	r    *bufio.Reader
+	sig  *signature
+	key  []byte
+	prev string
+	data []byte
+	err  error
+}
+
+func newChunkedReader(r io.Reader, sig *signature, secret string) *chunkedReader {
+	c := &chunkedReader{r: bufio.NewReader(r)}
+	if sig != nil {
+		c.sig, c.key, c.prev = sig, sig.signingKey(secret), sig.signature
+	}
+	return c
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for len(c.data) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.readChunk()
+	}
+	n := copy(p, c.data)
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func (c *chunkedReader) readChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	sizeHex, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errChunkedMalformed
+	}
+
+	data := make([]byte, size)
+	if _, err = io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+
+	if c.sig != nil {
+		chunkSig, ok := strings.CutPrefix(ext, "chunk-signature=")
+		if !ok || !c.verify(data, chunkSig) {
+			return errChunkSignature
+		}
+		c.prev = chunkSig
+	}
+
+	if size == 0 {
+		// после последней части идут необязательные трейлеры и пустая строка
+		for {
+			line, err = c.readLine()
+			if err != nil {
+				return err
+			}
+			if line == "" {
+				return io.EOF
+			}
+		}
+	}
+	if line, err = c.readLine(); err != nil || line != "" {
+		return errChunkedMalformed
+	}
+	c.data = data
+	return nil
+}
+
+func (c *chunkedReader) verify(data []byte, chunkSig string) bool {
+	hash := sha256.Sum256(data)
+	stringToSign := strings.Join([]string{
+		chunkStringToSignHead,
+		c.sig.date.Format(amzDateFormat),
+		c.sig.scope,
+		c.prev,
+		emptySha256,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSha256(c.key, []byte(stringToSign)))
+	return hmac.Equal([]byte(expected), []byte(chunkSig))
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	// ReadSlice ограничивает длину строки размером буфера, бесконечный заголовок части не пройдет
+	line, err := c.r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", io.ErrUnexpectedEOF
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return "", errChunkedMalformed
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(string(line), "\r\n"), nil
+}
@@ -0,0 +1,193 @@
+package s3gw
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"demo-storage/internal/app/interfaces"
+	"demo-storage/internal/app/repository"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/logging"
+
+	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Prefix путь, под которым смонтирован шлюз. Клиентам он указывается как endpoint
+// с path-style адресацией, например aws --endpoint-url https://host/s3
+const Prefix = "/s3"
+
+const (
+	keyContext     = "s3gw.key"
+	payloadContext = "s3gw.payload"
+)
+
+// Endpoint S3 совместимый шлюз: ListBuckets, ListObjects(V2), Head/Get/Put/DeleteObject и multipart загрузка.
+// Запросы подписываются SigV4 ключами из api_keys, владелец ключа становится владельцем файлов,
+// учет ведется в files так же, как для остальных способов загрузки
+type Endpoint struct {
+	s           interfaces.MinioService
+	files       fileStore
+	keys        *repository.KeyRepository
+	maxPartSize int
+}
+
+func New(s interfaces.MinioService, db *sqlx.DB, conf *config.Config) *Endpoint {
+	// Создаем endpoint и возвращаем
+	return &Endpoint{
+		s:           s,
+		files:       repository.New(db, conf.DB.QueryTimeout),
+		keys:        repository.NewKeyRepository(db, conf.DB.QueryTimeout),
+		maxPartSize: conf.S3Gateway.MaxPartSize,
+	}
+}
+
+// fileStore записи files, с которыми работает шлюз
+type fileStore interface {
+	FindFileByName(ctx context.Context, name string) *structs.File
+	FindOwners(ctx context.Context, names []string) (map[string]string, error)
+	SetFileOwner(ctx context.Context, name string, owner string) error
+	OwnerUsage(ctx context.Context, owner string) (int64, error)
+	UpdateFileSize(ctx context.Context, name string, size int64) sql.Result
+	AddUploadedBytes(ctx context.Context, name string, n int) sql.Result
+}
+
+// payloadVerifier проверка тела запроса, ошибку которой s3 клиент хранилища не пробрасывает
+type payloadVerifier interface {
+	failed() error
+}
+
+func (h *hashedReader) failed() error {
+	return h.err
+}
+
+func (c *chunkedReader) failed() error {
+	if errors.Is(c.err, io.EOF) {
+		return nil
+	}
+	return c.err
+}
+
+type body struct {
+	io.Reader
+	io.Closer
+}
+
+// Authenticate проверяет подпись SigV4 и оборачивает тело запроса проверкой payload
+func (e *Endpoint) Authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		logger := logdoc.GetLogger()
+		req := ctx.Request()
+
+		sig, apiErr := parseSignature(req, time.Now().UTC())
+		if apiErr != nil {
+			return e.fail(ctx, apiErr)
+		}
+
+		key, err := e.keys.FindKey(ctx.Request().Context(), sig.accessKey)
+		if errors.Is(err, repository.ErrKeyNotFound) || err == nil && key.Disabled {
+			return e.fail(ctx, errInvalidAccessKey)
+		}
+		if err != nil {
+			logger.Error(fmt.Sprintf(">> S3 gateway > unable to load key %s: %v", sig.accessKey, err))
+			return e.fail(ctx, errInternal)
+		}
+		if !sig.verify(req, key.SecretKey) {
+			return e.fail(ctx, errSignatureMismatch)
+		}
+
+		switch sig.payloadHash {
+		case unsignedPayload:
+		case streamingPayload, streamingTrailer:
+			chunked := newChunkedReader(req.Body, sig, key.SecretKey)
+			req.Body = body{chunked, req.Body}
+			ctx.Set(payloadContext, chunked)
+		case streamingUnsigned:
+			chunked := newChunkedReader(req.Body, nil, "")
+			req.Body = body{chunked, req.Body}
+			ctx.Set(payloadContext, chunked)
+		default:
+			hashed := newHashedReader(req.Body, sig.payloadHash)
+			req.Body = body{hashed, req.Body}
+			ctx.Set(payloadContext, hashed)
+		}
+
+		ctx.Set(keyContext, key)
+		ctx.SetRequest(req.WithContext(logging.WithFields(req.Context(), logrus.Fields{logging.FieldUser: key.Owner})))
+		return next(ctx)
+	}
+}
+
+// ServiceHandler GET / - список бакетов. Шлюз показывает только бакет хранилища
+func (e *Endpoint) ServiceHandler(ctx echo.Context) error {
+	if ctx.Request().Method != http.MethodGet {
+		return e.fail(ctx, errMethodNotAllowed)
+	}
+
+	key := apiKey(ctx)
+	res := listAllMyBucketsResult{Xmlns: s3Namespace, Owner: owner{ID: key.Owner, DisplayName: key.Owner}}
+	for _, b := range e.s.ListBuckets(ctx.Request().Context()) {
+		if b.Name != nil && *b.Name == e.s.Bucket() {
+			res.Buckets = append(res.Buckets, bucket{Name: *b.Name, CreationDate: aws.TimeValue(b.CreationDate).UTC()})
+		}
+	}
+	return ctx.XML(http.StatusOK, res)
+}
+
+func apiKey(ctx echo.Context) *structs.APIKey {
+	return ctx.Get(keyContext).(*structs.APIKey)
+}
+
+// fail отвечает ошибкой в формате S3
+func (e *Endpoint) fail(ctx echo.Context, err *apiError) error {
+	return ctx.XML(err.Status, errorResponse{
+		Code:      err.Code,
+		Message:   err.Message,
+		Resource:  ctx.Request().URL.Path,
+		RequestID: logging.RequestID(ctx.Request().Context()),
+	})
+}
+
+// storageError переводит ошибку хранилища или проверки тела запроса в ответ S3
+func (e *Endpoint) storageError(ctx echo.Context, err error) error {
+	logger := logdoc.GetLogger()
+
+	if p, ok := ctx.Get(payloadContext).(payloadVerifier); ok {
+		switch p.failed() {
+		case nil:
+		case errPayloadHash:
+			return e.fail(ctx, errContentSha256Mismatch)
+		case errChunkSignature:
+			return e.fail(ctx, errSignatureMismatch)
+		default:
+			return e.fail(ctx, errInvalidArgument.withMessage(p.failed().Error()))
+		}
+	}
+
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		switch reqErr.Code() {
+		case "NotFound", "NoSuchKey":
+			return e.fail(ctx, errNoSuchKey)
+		case "NoSuchUpload":
+			return e.fail(ctx, errNoSuchUpload)
+		}
+		// ошибки клиента хранилища (InvalidPart, EntityTooSmall, InvalidRange...) отдаем как есть
+		if reqErr.StatusCode() >= 400 && reqErr.StatusCode() < 500 {
+			return e.fail(ctx, &apiError{Status: reqErr.StatusCode(), Code: reqErr.Code(), Message: reqErr.Message()})
+		}
+	}
+
+	logger.Error(fmt.Sprintf(">> S3 gateway > %s %s: %v", ctx.Request().Method, ctx.Request().URL.Path, err))
+	return e.fail(ctx, errInternal)
+}
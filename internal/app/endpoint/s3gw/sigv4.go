@@ -0,0 +1,247 @@
+package s3gw
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Проверка подписи AWS Signature Version 4 (заголовок Authorization и presigned URL)
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+
+const (
+	algorithm       = "AWS4-HMAC-SHA256"
+	amzDateFormat   = "20060102T150405Z"
+	maxClockSkew    = 15 * time.Minute
+	maxPresignValid = 7 * 24 * time.Hour
+
+	headerAuthorization   = "Authorization"
+	headerDate            = "X-Amz-Date"
+	headerContentSha256   = "X-Amz-Content-Sha256"
+	headerDecodedLength   = "X-Amz-Decoded-Content-Length"
+	unsignedPayload       = "UNSIGNED-PAYLOAD"
+	streamingPayload      = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingTrailer      = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsigned     = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptySha256           = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	queryAlgorithm        = "X-Amz-Algorithm"
+	queryCredential       = "X-Amz-Credential"
+	queryDate             = "X-Amz-Date"
+	queryExpires          = "X-Amz-Expires"
+	querySignedHeaders    = "X-Amz-SignedHeaders"
+	querySignature        = "X-Amz-Signature"
+	queryContentSha256    = "X-Amz-Content-Sha256"
+	chunkStringToSignHead = "AWS4-HMAC-SHA256-PAYLOAD"
+)
+
+// signature разобранная подпись запроса
+type signature struct {
+	accessKey     string
+	date          time.Time
+	scope         string // дата/регион/сервис/aws4_request
+	signedHeaders []string
+	signature     string
+	payloadHash   string
+	presigned     bool
+}
+
+// parseSignature достает подпись из заголовка Authorization или из параметров presigned URL
+func parseSignature(req *http.Request, now time.Time) (*signature, *apiError) {
+	if req.URL.Query().Get(queryAlgorithm) != "" {
+		return parsePresigned(req, now)
+	}
+
+	auth := req.Header.Get(headerAuthorization)
+	if auth == "" {
+		return nil, errAccessDenied
+	}
+	rest, ok := strings.CutPrefix(auth, algorithm+" ")
+	if !ok {
+		return nil, errSignatureVersion
+	}
+
+	sig := &signature{}
+	var credential string
+	for _, field := range strings.Split(rest, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch name {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			sig.signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			sig.signature = value
+		}
+	}
+	if credential == "" || len(sig.signedHeaders) == 0 || sig.signature == "" {
+		return nil, errAuthorizationMalformed
+	}
+	if err := sig.parseCredential(credential); err != nil {
+		return nil, err
+	}
+
+	date, err := time.Parse(amzDateFormat, req.Header.Get(headerDate))
+	if err != nil {
+		return nil, errAuthorizationMalformed
+	}
+	if date.Sub(now) > maxClockSkew || now.Sub(date) > maxClockSkew {
+		return nil, errRequestTimeTooSkewed
+	}
+	sig.date = date
+
+	sig.payloadHash = req.Header.Get(headerContentSha256)
+	if sig.payloadHash == "" {
+		return nil, errContentSha256Missing
+	}
+	return sig, nil
+}
+
+func parsePresigned(req *http.Request, now time.Time) (*signature, *apiError) {
+	query := req.URL.Query()
+	if query.Get(queryAlgorithm) != algorithm {
+		return nil, errSignatureVersion
+	}
+
+	sig := &signature{
+		signedHeaders: strings.Split(query.Get(querySignedHeaders), ";"),
+		signature:     query.Get(querySignature),
+		payloadHash:   unsignedPayload,
+		presigned:     true,
+	}
+	if err := sig.parseCredential(query.Get(queryCredential)); err != nil {
+		return nil, err
+	}
+	if hash := query.Get(queryContentSha256); hash != "" {
+		sig.payloadHash = hash
+	}
+
+	date, err := time.Parse(amzDateFormat, query.Get(queryDate))
+	if err != nil {
+		return nil, errAuthorizationMalformed
+	}
+	expires, err := strconv.Atoi(query.Get(queryExpires))
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignValid {
+		return nil, errAuthorizationMalformed
+	}
+	if date.Sub(now) > maxClockSkew {
+		return nil, errRequestTimeTooSkewed
+	}
+	if now.After(date.Add(time.Duration(expires) * time.Second)) {
+		return nil, errExpiredPresign
+	}
+	sig.date = date
+	return sig, nil
+}
+
+// parseCredential разбирает "ключ/дата/регион/s3/aws4_request"
+func (sig *signature) parseCredential(credential string) *apiError {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[3] != "s3" || parts[4] != "aws4_request" {
+		return errAuthorizationMalformed
+	}
+	sig.accessKey = parts[0]
+	sig.scope = strings.Join(parts[1:], "/")
+	return nil
+}
+
+// verify сверяет подпись запроса с вычисленной по секрету ключа
+func (sig *signature) verify(req *http.Request, secret string) bool {
+	if !strings.HasPrefix(sig.scope, sig.date.Format("20060102")+"/") {
+		return false
+	}
+	expected := hex.EncodeToString(hmacSha256(sig.signingKey(secret), sig.stringToSign(sig.canonicalRequest(req))))
+	return hmac.Equal([]byte(expected), []byte(sig.signature))
+}
+
+func (sig *signature) canonicalRequest(req *http.Request) string {
+	var headers strings.Builder
+	for _, name := range sig.signedHeaders {
+		var value string
+		if name == "host" {
+			value = req.Host
+		} else {
+			value = strings.Join(req.Header.Values(name), ",")
+		}
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		encodePath(req.URL.Path),
+		canonicalQuery(req.URL.Query(), sig.presigned),
+		headers.String(),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payloadHash,
+	}, "\n")
+}
+
+func (sig *signature) stringToSign(canonicalRequest string) []byte {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	return []byte(strings.Join([]string{
+		algorithm,
+		sig.date.Format(amzDateFormat),
+		sig.scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n"))
+}
+
+func (sig *signature) signingKey(secret string) []byte {
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(sig.scope, "/") {
+		key = hmacSha256(key, []byte(part))
+	}
+	return key
+}
+
+// canonicalQuery сортирует параметры и кодирует их по правилам SigV4.
+// В presigned URL сама подпись в каноническую строку не входит
+func canonicalQuery(query url.Values, presigned bool) string {
+	var pairs []string
+	for name, values := range query {
+		if presigned && name == querySignature {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, encode(name, true)+"="+encode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// encodePath кодирует путь один раз, как это делают клиенты S3 (в отличие от других сервисов AWS)
+func encodePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return encode(path, false)
+}
+
+// encode URI кодирование SigV4: не кодируются только A-Z, a-z, 0-9, '-', '.', '_', '~' и, в пути, '/'
+func encode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSha256(key []byte, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -2,9 +2,12 @@ package status
 
 import (
 	"net/http"
+	"time"
 
 	"demo-storage/internal/app/interfaces"
 	"demo-storage/internal/app/repository"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/config"
 	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
 )
@@ -13,17 +16,54 @@ type Endpoint struct {
 	r interfaces.UserRepository
 }
 
-func New(db *sqlx.DB) *Endpoint {
+type FileStatus struct {
+	Name          string             `json:"name"`
+	Status        structs.FileStatus `json:"status"`
+	ErrorReason   string             `json:"errorReason,omitempty"`
+	BytesTotal    int64              `json:"bytesTotal"`
+	BytesUploaded int64              `json:"bytesUploaded"`
+	CreatedAt     time.Time          `json:"createdAt"`
+	UpdatedAt     time.Time          `json:"updatedAt"`
+	Transitions   []Transition       `json:"transitions"`
+}
+
+type Transition struct {
+	From   string             `json:"from,omitempty"`
+	To     structs.FileStatus `json:"to"`
+	Reason string             `json:"reason,omitempty"`
+	At     time.Time          `json:"at"`
+}
+
+func New(db *sqlx.DB, conf *config.Config) *Endpoint {
 	// Создаем endpoint и возвращаем
-	r := repository.New(db)
+	r := repository.New(db, conf.DB.QueryTimeout)
 	return &Endpoint{r: r}
 }
 
 func (e *Endpoint) StatusHandler(ctx echo.Context) error {
 	name := ctx.QueryParam("file")
-	res := e.r.FindFileByName(name)
+	res := e.r.FindFileByName(ctx.Request().Context(), name)
 	if res == nil || res.Name == "" {
 		return echo.NewHTTPError(http.StatusNotFound, "File not found with name ", name)
 	}
-	return ctx.JSON(http.StatusOK, res)
+
+	status := FileStatus{
+		Name:          res.Name,
+		Status:        res.UploadStatus,
+		ErrorReason:   res.ErrorReason.String,
+		BytesTotal:    res.BytesTotal,
+		BytesUploaded: res.BytesUploaded,
+		CreatedAt:     res.CreatedAt,
+		UpdatedAt:     res.UpdatedAt,
+		Transitions:   []Transition{},
+	}
+	for _, t := range e.r.FindTransitions(ctx.Request().Context(), res.Id) {
+		status.Transitions = append(status.Transitions, Transition{
+			From:   t.From.String,
+			To:     t.To,
+			Reason: t.Reason.String,
+			At:     t.CreatedAt,
+		})
+	}
+	return ctx.JSON(http.StatusOK, status)
 }
@@ -0,0 +1,117 @@
+package tus
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	"demo-storage/internal/app/structs"
+)
+
+// Заголовки и значения протокола tus 1.0 (https://tus.io/protocols/resumable-upload)
+const (
+	Version    = "1.0.0"
+	Extensions = "creation,termination,checksum,expiration"
+
+	HeaderResumable         = "Tus-Resumable"
+	HeaderVersion           = "Tus-Version"
+	HeaderExtension         = "Tus-Extension"
+	HeaderMaxSize           = "Tus-Max-Size"
+	HeaderChecksumAlgorithm = "Tus-Checksum-Algorithm"
+	HeaderUploadLength      = "Upload-Length"
+	HeaderUploadDeferLength = "Upload-Defer-Length"
+	HeaderUploadOffset      = "Upload-Offset"
+	HeaderUploadMetadata    = "Upload-Metadata"
+	HeaderUploadChecksum    = "Upload-Checksum"
+	HeaderUploadExpires     = "Upload-Expires"
+
+	ContentTypeOffset = "application/offset+octet-stream"
+
+	// StatusChecksumMismatch код ответа checksum расширения при несовпадении суммы
+	StatusChecksumMismatch = 460
+)
+
+var algorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// ChecksumAlgorithms значение Tus-Checksum-Algorithm
+const ChecksumAlgorithms = "md5,sha1,sha256"
+
+var errUnsupportedAlgorithm = errors.New("unsupported checksum algorithm")
+
+// parseMetadata разбирает Upload-Metadata: пары "ключ base64(значение)" через запятую,
+// значение может отсутствовать
+func parseMetadata(header string) (map[string]string, error) {
+	res := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return res, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata value of %s: %w", key, err)
+		}
+		res[key] = string(value)
+	}
+	return res, nil
+}
+
+// FormatMetadata собирает Upload-Metadata из пар ключ-значение, пустые значения пропускаются
+func FormatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + " " + base64.StdEncoding.EncodeToString([]byte(metadata[key]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// firstOf возвращает первое непустое значение метаданных из перечисленных ключей.
+// Разные клиенты называют одно и то же по-разному: tus-js-client filename, Uppy name
+func firstOf(metadata map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if v := metadata[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseChecksum разбирает Upload-Checksum вида "алгоритм base64(сумма)"
+func parseChecksum(header string) (*structs.TusChecksum, error) {
+	if header == "" {
+		return nil, nil
+	}
+	algorithm, encoded, ok := strings.Cut(header, " ")
+	if !ok {
+		return nil, fmt.Errorf("invalid Upload-Checksum header %q", header)
+	}
+	newHash, ok := algorithms[strings.ToLower(algorithm)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnsupportedAlgorithm, algorithm)
+	}
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checksum value: %w", err)
+	}
+	return &structs.TusChecksum{Hash: newHash(), Sum: sum}, nil
+}
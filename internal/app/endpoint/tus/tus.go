@@ -0,0 +1,228 @@
+package tus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"demo-storage/internal/app/endpoint/upload"
+	"demo-storage/internal/app/interfaces"
+	"demo-storage/internal/app/metrics"
+	minio "demo-storage/internal/app/service"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/budget"
+	"demo-storage/internal/pkg/logging"
+	"demo-storage/internal/pkg/s3part"
+
+	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Path адрес tus загрузок. По нему же продолжаются websocket загрузки, прерванные остановкой сервиса
+const Path = "/storage/tus/files"
+
+// Endpoint реализует ядро tus 1.0 и расширения creation, termination, checksum и expiration.
+// Каждая tus загрузка - это S3 multipart сессия, смещение хранится в Postgres
+type Endpoint struct {
+	s          interfaces.MinioService
+	partSize   int
+	expiration time.Duration
+	// budget бюджет памяти, общий с websocket загрузками: PATCH держит в памяти одну часть загрузки
+	budget *budget.Memory
+}
+
+func New(s interfaces.MinioService, conf *config.Config, mem *budget.Memory) *Endpoint {
+	// Создаем endpoint и возвращаем
+	return &Endpoint{
+		s:          s,
+		partSize:   conf.Upload.PartSize,
+		expiration: conf.Tus.Expiration,
+		budget:     mem,
+	}
+}
+
+// Resumable проверяет версию протокола клиента и добавляет Tus-Resumable в каждый ответ
+func (e *Endpoint) Resumable(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		res := ctx.Response().Header()
+		res.Set(HeaderResumable, Version)
+		// OPTIONS клиенты шлют до того, как узнают поддерживаемую версию
+		if ctx.Request().Method != http.MethodOptions && ctx.Request().Header.Get(HeaderResumable) != Version {
+			res.Set(HeaderVersion, Version)
+			return ctx.NoContent(http.StatusPreconditionFailed)
+		}
+		return next(ctx)
+	}
+}
+
+// OptionsHandler сообщает возможности сервера
+func (e *Endpoint) OptionsHandler(ctx echo.Context) error {
+	res := ctx.Response().Header()
+	res.Set(HeaderVersion, Version)
+	res.Set(HeaderExtension, Extensions)
+	res.Set(HeaderChecksumAlgorithm, ChecksumAlgorithms)
+	if maxSize := config.GetConfig().Upload.MaxSize; maxSize > 0 {
+		res.Set(HeaderMaxSize, strconv.Itoa(maxSize))
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// CreateHandler создает загрузку (расширение creation). Имя файла берется из метаданных
+func (e *Endpoint) CreateHandler(ctx echo.Context) error {
+	logger := logdoc.GetLogger()
+	req := ctx.Request()
+
+	if req.Header.Get(HeaderUploadDeferLength) != "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Upload-Defer-Length is not supported")
+	}
+	length, err := strconv.ParseInt(req.Header.Get(HeaderUploadLength), 10, 64)
+	if err != nil || length < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Upload-Length header")
+	}
+	maxSize := config.GetConfig().Upload.MaxSize
+	if maxSize > 0 && length > int64(maxSize) {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload is too large, maximum size is %d bytes", maxSize))
+	}
+
+	rawMetadata := req.Header.Get(HeaderUploadMetadata)
+	metadata, err := parseMetadata(rawMetadata)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Upload-Metadata header: "+err.Error())
+	}
+
+	header := &structs.UploadHeader{
+		Filename:    firstOf(metadata, "filename", "name"),
+		Size:        int(length),
+		ContentType: firstOf(metadata, "filetype", "type"),
+	}
+	if err = upload.ValidateHeader(header, maxSize); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	u, err := e.s.CreateTusUpload(ctx.Request().Context(), header.Filename, length, int64(s3part.SizeFor(length, e.partSize)), rawMetadata, time.Now().Add(e.expiration))
+	if err != nil {
+		logger.Error(fmt.Sprintf(">> tus > unable to create upload of %s: %v", header.Filename, err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error creating upload: "+err.Error())
+	}
+	logger.Debug(fmt.Sprintf(">> tus > upload %s of %s created, length %d", u.Id, u.Name, u.Length))
+
+	res := ctx.Response().Header()
+	res.Set(echo.HeaderLocation, fmt.Sprintf("%s://%s%s/%s", ctx.Scheme(), req.Host, strings.TrimSuffix(req.URL.Path, "/"), u.Id))
+	res.Set(HeaderUploadExpires, u.ExpiresAt.UTC().Format(http.TimeFormat))
+	return ctx.NoContent(http.StatusCreated)
+}
+
+// HeadHandler возвращает текущее смещение загрузки, с него клиент продолжает после обрыва
+func (e *Endpoint) HeadHandler(ctx echo.Context) error {
+	u, err := e.s.FindTusUpload(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return uploadError(err)
+	}
+
+	res := ctx.Response().Header()
+	res.Set(echo.HeaderCacheControl, "no-store")
+	res.Set(HeaderUploadOffset, strconv.FormatInt(u.Offset, 10))
+	res.Set(HeaderUploadLength, strconv.FormatInt(u.Length, 10))
+	if u.Metadata.Valid {
+		res.Set(HeaderUploadMetadata, u.Metadata.String)
+	}
+	if !u.Completed() {
+		res.Set(HeaderUploadExpires, u.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	return ctx.NoContent(http.StatusOK)
+}
+
+// PatchHandler дописывает тело запроса в загрузку с указанного смещения
+func (e *Endpoint) PatchHandler(ctx echo.Context) error {
+	req := ctx.Request()
+	id := ctx.Param("id")
+
+	if req.Header.Get(echo.HeaderContentType) != ContentTypeOffset {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, "Content-Type must be "+ContentTypeOffset)
+	}
+	offset, err := strconv.ParseInt(req.Header.Get(HeaderUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid Upload-Offset header")
+	}
+	checksum, err := parseChecksum(req.Header.Get(HeaderUploadChecksum))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// Двум запросам одной загрузки писать части одновременно нельзя, в том числе на разных экземплярах сервиса
+	unlock, err := e.s.LockTusUpload(req.Context(), id)
+	if err != nil {
+		return uploadError(err)
+	}
+	defer unlock()
+	defer metrics.StartUploadSession(metrics.ProtocolTus)()
+
+	u, err := e.s.FindTusUpload(req.Context(), id)
+	if err != nil {
+		return uploadError(err)
+	}
+	if offset != u.Offset {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("Upload-Offset %d does not match current offset %d", offset, u.Offset))
+	}
+	reqCtx := logging.WithFields(req.Context(), logrus.Fields{logging.FieldFile: u.Name, logging.FieldSession: u.Id})
+	logger := logging.FromContext(reqCtx)
+
+	// память под часть занимаем до чтения тела, ожидание прерывается обрывом запроса
+	weight, err := e.budget.Acquire(req.Context(), int(u.PartSize))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Upload cancelled while waiting for memory")
+	}
+	defer e.budget.Release(weight)
+
+	// Принятые до обрыва соединения байты сохраняем, поэтому запись не отменяется вместе с запросом
+	newOffset, err := e.s.WriteTusUpload(context.WithoutCancel(reqCtx), u, req.Body, checksum)
+	if err != nil {
+		logger.Error(fmt.Sprintf(">> tus > upload %s of %s: PATCH failed: %v", u.Id, u.Name, err))
+		return uploadError(err)
+	}
+	logger.Debug(fmt.Sprintf(">> tus > upload %s of %s: offset %d of %d", u.Id, u.Name, newOffset, u.Length))
+
+	res := ctx.Response().Header()
+	res.Set(HeaderUploadOffset, strconv.FormatInt(newOffset, 10))
+	if !u.Completed() {
+		res.Set(HeaderUploadExpires, u.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// DeleteHandler прерывает загрузку (расширение termination)
+func (e *Endpoint) DeleteHandler(ctx echo.Context) error {
+	u, err := e.s.FindTusUpload(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil && !errors.Is(err, minio.ErrUploadExpired) {
+		return uploadError(err)
+	}
+	if err = e.s.TerminateTusUpload(ctx.Request().Context(), u, structs.StatusCancelled, "terminated by client"); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error terminating upload: "+err.Error())
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func uploadError(err error) error {
+	switch {
+	case errors.Is(err, minio.ErrUploadNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "Upload not found")
+	case errors.Is(err, minio.ErrUploadExpired):
+		return echo.NewHTTPError(http.StatusGone, "Upload expired")
+	case errors.Is(err, minio.ErrOffsetConflict):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, minio.ErrUploadLocked):
+		return echo.NewHTTPError(http.StatusConflict, "Upload is locked by another request")
+	case errors.Is(err, minio.ErrLengthExceeded):
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, minio.ErrChecksumMismatch):
+		return echo.NewHTTPError(StatusChecksumMismatch, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+}
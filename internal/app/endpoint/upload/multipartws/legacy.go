@@ -0,0 +1,98 @@
+package multipartws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"demo-storage/internal/app/structs"
+	"github.com/gorilla/websocket"
+)
+
+// UploadStatus статус прежнего протокола. Part - номер загруженной части
+// или 100 по окончании загрузки одной частью
+type UploadStatus struct {
+	Code   int    `json:"code,omitempty"`
+	Status string `json:"status,omitempty"`
+	Pct    *int64 `json:"part,omitempty"` // File processing AFTER uploading is done.
+	pct    int64
+}
+
+// legacyProtocol прежний протокол: заголовок - JSON structs.UploadHeader, управляющие сообщения -
+// строки NEXT, UPLOAD_COMPLETED, COMPLETED и CANCEL, остальное - UploadStatus
+type legacyProtocol struct {
+	*conn
+}
+
+func (p *legacyProtocol) header(mt int, message []byte) (*structs.UploadHeader, error) {
+	if mt != websocket.TextMessage {
+		return nil, errors.New("Invalid message received, expecting file name and length")
+	}
+	header := new(structs.UploadHeader)
+	if err := json.Unmarshal(message, header); err != nil {
+		return nil, errors.New("Error receiving file name and length: " + err.Error())
+	}
+	return header, nil
+}
+
+func (p *legacyProtocol) cancelled(mt int, message []byte) bool {
+	return mt == websocket.TextMessage && string(message) == "CANCEL"
+}
+
+func (p *legacyProtocol) ready() error {
+	return p.sendStatus(200, "READY")
+}
+
+func (p *legacyProtocol) next(int) error {
+	return p.writeText([]byte("NEXT"))
+}
+
+func (p *legacyProtocol) partUploaded(_ int, _ int, completed int64) error {
+	return p.sendPct(completed)
+}
+
+func (p *legacyProtocol) received(int) error {
+	return p.writeText([]byte("UPLOAD_COMPLETED"))
+}
+
+func (p *legacyProtocol) stored(int) error {
+	return p.sendPct(100)
+}
+
+func (p *legacyProtocol) uploaded(res *UploadResult) error {
+	return p.sendStatus(200, fmt.Sprintf("File upload successful: %s (%d bytes)", res.Key, res.Size))
+}
+
+func (p *legacyProtocol) extracted(ex *Extraction) error {
+	if ex.Error != "" {
+		return p.sendStatus(400, fmt.Sprintf("Archive extraction failed after %d files: %s", ex.Files, ex.Error))
+	}
+	return p.sendStatus(200, fmt.Sprintf("Archive extracted: %d files", ex.Files))
+}
+
+func (p *legacyProtocol) completed(*UploadResult) error {
+	return p.writeText([]byte("COMPLETED"))
+}
+
+func (p *legacyProtocol) fail(_ string, message string) error {
+	return p.sendStatus(400, message)
+}
+
+func (p *legacyProtocol) interrupted(resume *Resume) error {
+	if resume == nil {
+		return p.sendStatus(503, "Upload interrupted by service shutdown, start it again later")
+	}
+	return p.sendStatus(503, fmt.Sprintf("Upload interrupted by service shutdown, resume it with tus: %s from offset %d", resume.UploadURL, resume.Offset))
+}
+
+func (p *legacyProtocol) sendStatus(code int, status string) error {
+	return p.writeJSON(UploadStatus{Code: code, Status: status})
+}
+
+// sendPct ошибку отправки прогресса не возвращает: прогресс не должен прерывать загрузку
+func (p *legacyProtocol) sendPct(pct int64) error {
+	stat := UploadStatus{pct: pct, Status: "part upload completed"}
+	stat.Pct = &stat.pct
+	_ = p.writeJSON(stat)
+	return nil
+}
@@ -1,139 +1,262 @@
 package multipartws
 
 import (
+	"context"
+	"crypto/sha256"
+	"demo-storage/internal/app/endpoint/tus"
+	minio "demo-storage/internal/app/service"
 	"demo-storage/internal/app/structs"
+	"demo-storage/internal/pkg/compress"
+	"demo-storage/internal/pkg/drain"
+	"demo-storage/internal/pkg/logging"
+	"demo-storage/internal/pkg/s3part"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/gorilla/websocket"
-	"sort"
-	"sync"
-	"sync/atomic"
+	"strings"
+	"time"
 )
 
-func (e *Endpoint) multipartUpload(ws *websocket.Conn, mu sync.Locker, header *structs.UploadHeader) (int, error) {
+var (
+	// ErrFileTooLarge заявленный размер файла не укладывается в пределы multipart загрузки S3
+	ErrFileTooLarge = errors.New("file is too large")
+	// ErrSizeMismatch клиент прислал больше байт, чем заявил
+	ErrSizeMismatch = errors.New("received more bytes than declared")
+)
+
+func (e *Endpoint) multipartUpload(ctx context.Context, in frames, p protocol, header *structs.UploadHeader) (*UploadResult, error) {
 	bytesRead := 0
+	checksum := sha256.New()
 
-	logger := logdoc.GetLogger()
+	logger := logging.FromContext(ctx)
 
 	logger.Debug(fmt.Sprintf("Multipart upload started. File name:%s, Size:%d bytes", header.Filename, header.Size))
 	logger.Debug("Ready for receiving file chunks...")
 
-	var ch = make(chan structs.PartUploadResult)
-	var wg = sync.WaitGroup{}
-	var completedParts []*s3.CompletedPart
 	var partNum = 1
-	var cnt int64
+	// Сжимаем поток до нарезки на части. Несжимаемые данные после сжатия немного больше исходных,
+	// запас нужен, чтобы они уложились в 10000 частей
+	codec := e.s.MultipartCodec(header)
+	partSize := s3part.SizeFor(int64(header.Size), e.partSize)
+	if codec != compress.NONE {
+		partSize = s3part.SizeFor(int64(header.Size+header.Size/100), e.partSize)
+	}
+
+	if header.Size > s3part.MaxSize*s3part.MaxCount {
+		cause := fmt.Errorf("%w, maximum size is %d bytes", ErrFileTooLarge, s3part.MaxSize*s3part.MaxCount)
+		if er := p.fail(ErrorFileTooLarge, cause.Error()); er != nil {
+			logger.Errorf("Error sending status: %v", er)
+		}
+		return nil, cause
+	}
 
 	// Инициируем S3 Multipart Upload сессию
-	s3connection, uploadSession, er := e.s.CreateMultipartSession(header.Filename)
+	s3connection, uploadSession, er := e.s.CreateMultipartSession(ctx, header.Filename, header.Size, codec)
 	if er != nil {
-		er = e.sendStatus(ws, 400, "Error initiating multipart upload: "+er.Error())
-		if er != nil {
-			logger.Errorf("Error sending status: %v", er)
-			return bytesRead, er
+		if err := p.fail(ErrorUploadFailed, "Error initiating multipart upload: "+er.Error()); err != nil {
+			logger.Errorf("Error sending status: %v", err)
+		}
+		return nil, er
+	}
+
+	// Части грузятся в S3 пулом ограниченного размера, при выходе с ошибкой
+	// незавершенные части отменяются
+	uploader := e.newPartUploader(ctx, p, s3connection, uploadSession)
+	defer uploader.Cancel()
+	logger.Debug(fmt.Sprintf("Multipart upload %s: S3 part size %d bytes, codec %q", header.Filename, partSize, codec))
+
+	abort := func(status structs.FileStatus, reason string) {
+		uploader.Cancel()
+		if err := e.s.AbortMultipartUpload(ctx, s3connection, uploadSession, status, reason); err != nil {
+			logger.Error("Abort multipart upload failed: " + err.Error())
+		}
+	}
+
+	splitter, er := newPartSplitter(codec, partSize, uploader)
+	if er != nil {
+		abort(structs.StatusFailed, er.Error())
+		if err := p.fail(ErrorUploadFailed, "Error initiating multipart upload: "+er.Error()); err != nil {
+			logger.Errorf("Error sending status: %v", err)
+		}
+		return nil, er
+	}
+	// незаполненная часть при выходе с ошибкой освобождает свою память в бюджете
+	defer splitter.Release()
+	// первый блок клиент шлет сразу после start, память под него занимаем до чтения
+	if er = splitter.Reserve(); er != nil {
+		abort(structs.StatusFailed, er.Error())
+		if err := p.fail(ErrorUploadFailed, "Error initiating multipart upload: "+er.Error()); err != nil {
+			logger.Errorf("Error sending status: %v", err)
 		}
-		return bytesRead, er
+		return nil, er
 	}
 
 	for {
-		mt, message, err := ws.ReadMessage()
+		mt, message, err := in.ReadMessage()
+		if err != nil && e.drain.Interrupted().Err() != nil {
+			return nil, e.suspend(ctx, p, header, uploader, uploadSession, partSize, partNum-1, codec == compress.NONE, abort)
+		}
 		if err != nil {
-			err = e.sendStatus(ws, 400, "Error receiving file block: "+err.Error())
-			if err != nil {
-				logger.Error("Error sending status:", err)
-				return bytesRead, err
+			abort(structs.StatusFailed, "error receiving file block: "+err.Error())
+			if er := p.fail(ErrorReceiveFailed, "Error receiving file block: "+err.Error()); er != nil {
+				logger.Error("Error sending status:", er)
 			}
-
-			return bytesRead, err
+			return nil, err
 		}
 
 		if mt != websocket.BinaryMessage {
-			if mt == websocket.TextMessage {
-				if string(message) == "CANCEL" {
-					err = e.s.AbortMultipartUpload(s3connection, uploadSession)
-					if err != nil {
-						logger.Error("Abort multipart upload failed: " + err.Error())
-						return bytesRead, err
-					}
-					err = e.sendStatus(ws, 400, "Upload canceled")
-					if err != nil {
-						logger.Error("Error sending status:", err)
-						return bytesRead, err
-					}
-					return bytesRead, err
+			if p.cancelled(mt, message) {
+				abort(structs.StatusCancelled, "cancelled by client")
+				if err = p.fail(ErrorCancelled, "Upload canceled"); err != nil {
+					logger.Error("Error sending status:", err)
 				}
+				return nil, minio.ErrUploadCanceled
 			}
 
+			abort(structs.StatusFailed, "invalid file block received")
 			logger.Debug("Invalid file block received, expecting binary chunk, closing")
-			err = e.sendStatus(ws, 400, "Invalid file block received, expecting binary chunk, closing")
-			if err != nil {
+			if err = p.fail(ErrorInvalidMessage, "Invalid file block received, expecting binary chunk, closing"); err != nil {
 				logger.Error("Error sending status:", err)
-				return bytesRead, err
 			}
-
-			return bytesRead, err
+			return nil, errors.New("invalid file block received")
 		}
 
-		wg.Add(1)
-		go func(s3connection *s3.S3, uploadSession *s3.CreateMultipartUploadOutput, message []byte, partNum int, wg *sync.WaitGroup) {
-			uploadPartResult := e.s.UploadPartToS3(s3connection, uploadSession, message, partNum)
-			e.sendPct(ws, mu, atomic.AddInt64(&cnt, 1))
-			ch <- uploadPartResult
-			wg.Done()
-		}(s3connection, uploadSession, message, partNum, &wg)
-
 		bytesRead += len(message)
 		logger.Debug(fmt.Sprintf(">> Websocket multipart receiver > binary chunk received, size:%d, total bytes received:%d of total size:%d", len(message), bytesRead, header.Size))
 
+		if bytesRead > header.Size {
+			abort(structs.StatusFailed, ErrSizeMismatch.Error())
+			cause := fmt.Errorf("%w: received %d bytes, declared size is %d", ErrSizeMismatch, bytesRead, header.Size)
+			if err = p.fail(ErrorSizeMismatch, cause.Error()); err != nil {
+				logger.Error("Error sending status:", err)
+			}
+			return nil, cause
+		}
+		checksum.Write(message)
+
+		// Копим блоки клиента в части нужного размера. Последняя часть уходит при завершении
+		parts, err := splitter.Write(message)
+		if err == nil && bytesRead == header.Size {
+			var rest []part
+			rest, err = splitter.Flush()
+			parts = append(parts, rest...)
+		}
+		if err != nil {
+			uploader.release(weightOf(parts))
+			abort(structs.StatusFailed, err.Error())
+			if er := p.fail(ErrorUploadFailed, "Error compressing file: "+err.Error()); er != nil {
+				logger.Error("Error sending status:", er)
+			}
+			return nil, err
+		}
+
+		// Submit блокируется, пока не освободится слот пула, до этого следующий блок у клиента не запрашиваем
+		for i, chunk := range parts {
+			if err = uploader.Submit(chunk, partNum); err != nil {
+				uploader.release(weightOf(parts[i+1:]))
+				abort(structs.StatusFailed, err.Error())
+				logger.Error("Part upload failed:", err)
+				if er := p.fail(ErrorUploadFailed, "Error uploading file: "+err.Error()); er != nil {
+					logger.Error("Error sending status:", er)
+				}
+				return nil, err
+			}
+			partNum++
+		}
+
 		if bytesRead == header.Size {
-			// Ждем, пока отработают все горутины, и закроем канал
-			err = e.sendUploadCompleted(ws)
+			err = p.received(bytesRead)
 			if err != nil {
 				logger.Error("Error sending status:", err)
-				return bytesRead, err
+				return nil, err
 			}
-			go func() {
-				wg.Wait()
-				close(ch)
-			}()
-
-			for result := range ch {
-				if result.Err != nil {
-					err = e.s.AbortMultipartUpload(s3connection, uploadSession)
-					if err != nil {
-						logger.Error("Error sending status:", err)
-						return bytesRead, err
-					}
+
+			// Ждем, пока загрузятся все части
+			completedParts, err := uploader.Wait()
+			if err != nil {
+				abort(structs.StatusFailed, err.Error())
+				if er := p.fail(ErrorUploadFailed, "Error uploading file: "+err.Error()); er != nil {
+					logger.Error("Error sending status:", er)
 				}
-				completedParts = append(completedParts, result.CompletedPart)
+				return nil, err
 			}
 
-			// сортируем куски по PartNumber тк
-			// каждая часть может грузиться в произвольном порядке
-			sort.Slice(completedParts, func(i, j int) bool {
-				return *completedParts[i].PartNumber < *completedParts[j].PartNumber
-			})
-
 			// Сигналим AWS S3 хранилищу, что наша multiPart загрузка завершена,
 			// AWS начинает сборку кусков в единый файл на своей стороне
-			err = e.s.CompleteMultipartUpload(s3connection, uploadSession, completedParts)
+			err = e.s.CompleteMultipartUpload(ctx, s3connection, uploadSession, completedParts)
 			if err != nil {
-				logger.Error("Error sending status:", err)
-				return bytesRead, err
+				logger.Error("Error completing multipart upload:", err)
+				if er := p.fail(ErrorUploadFailed, "Error completing multipart upload: "+err.Error()); er != nil {
+					logger.Error("Error sending status:", er)
+				}
+				return nil, err
 			}
 
 			logger.Debug("Multipart upload completed")
 			break
 		}
 
-		partNum++
-		err = e.requestNextBlock(ws)
+		// следующий блок ляжет в часть, память под которую уже занята в бюджете
+		if err = splitter.Reserve(); err != nil {
+			abort(structs.StatusFailed, err.Error())
+			if er := p.fail(ErrorUploadFailed, "Error uploading file: "+err.Error()); er != nil {
+				logger.Error("Error sending status:", er)
+			}
+			return nil, err
+		}
+		err = p.next(bytesRead)
 		if err != nil {
 			logger.Error("Error receiving next block:", err)
-			return bytesRead, err
+			return nil, err
+		}
+	}
+
+	res := &UploadResult{
+		Key:      header.Filename,
+		Size:     bytesRead,
+		Checksum: hex.EncodeToString(checksum.Sum(nil)),
+	}
+	// ETag собранного объекта известен только хранилищу
+	if head, err := e.s.HeadObject(ctx, header.Filename); err == nil {
+		res.ETag = strings.Trim(aws.StringValue(head.ETag), `"`)
+	} else {
+		logger.Error(fmt.Sprintf("Unable to read ETag of %s: %v", header.Filename, err))
+	}
+	return res, nil
+}
+
+// suspend сохраняет загрузку, прерванную остановкой сервиса, как tus загрузку, которую клиент продолжит
+// с конца последней отправленной в S3 части. Принятые байты неполной части теряются.
+// Сжатую загрузку продолжить нельзя: границы частей сжатого потока не совпадают со смещением в файле.
+// Если сохранить состояние не удалось или загрузка не resumable, она прерывается как обычно
+func (e *Endpoint) suspend(ctx context.Context, p protocol, header *structs.UploadHeader, uploader *partUploader, uploadSession *s3.CreateMultipartUploadOutput,
+	partSize int, parts int, resumable bool, abort func(status structs.FileStatus, reason string)) error {
+	logger := logging.FromContext(ctx)
+
+	_, err := uploader.Wait()
+	if err == nil && !resumable {
+		err = errors.New("compressed upload cannot be resumed")
+	}
+	if err == nil {
+		metadata := tus.FormatMetadata(map[string]string{"filename": header.Filename, "filetype": header.ContentType})
+		var u *structs.TusUpload
+		u, err = e.s.SuspendMultipartUpload(ctx, uploadSession, int64(header.Size), int64(partSize), parts, metadata, time.Now().Add(e.expiration))
+		if err == nil {
+			logger.Warn(fmt.Sprintf("Multipart upload %s interrupted by shutdown, saved as tus upload %s at offset %d", header.Filename, u.Id, u.Offset))
+			if er := p.interrupted(p.resume(u.Id, u.Offset)); er != nil {
+				logger.Error("Error sending status:", er)
+			}
+			return drain.ErrInterrupted
 		}
 	}
 
-	return bytesRead, nil
+	logger.Error(fmt.Sprintf("Unable to save interrupted multipart upload %s: %v", header.Filename, err))
+	abort(structs.StatusFailed, drain.ErrInterrupted.Error())
+	if er := p.interrupted(nil); er != nil {
+		logger.Error("Error sending status:", er)
+	}
+	return drain.ErrInterrupted
 }
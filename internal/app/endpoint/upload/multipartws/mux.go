@@ -0,0 +1,196 @@
+package multipartws
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"demo-storage/internal/config"
+	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolMux мультиплексированный вариант ProtocolV1: по одному соединению одновременно
+// загружается несколько файлов. Поток загрузки клиент открывает сообщением start с номером stream (не 0),
+// все сообщения потока в обе стороны содержат этот номер. Бинарный блок начинается с 4 байт номера
+// потока (big endian), за ними идут данные файла. Прогресс, отмена и завершение у потоков независимы,
+// одновременно открыто не больше upload.max-streams потоков
+const ProtocolMux = "storage-upload.mux.v1"
+
+const (
+	streamHeaderSize = 4
+	// streamBacklog сколько сообщений потока ждут обработки. Клиент шлет следующий блок
+	// только после next, а next уходит, когда память под блок уже занята в бюджете. Поэтому в очереди
+	// только этот блок, cancel его вытесняет
+	streamBacklog = 1
+)
+
+var errStreamClosed = errors.New("upload stream closed")
+
+type frame struct {
+	mt   int
+	data []byte
+}
+
+// muxStream поток загрузки: источник блоков для загрузки и протокол с номером потока.
+// Номер освобождается до отправки последнего сообщения, чтобы клиент мог сразу открыть поток с тем же номером
+type muxStream struct {
+	*protocolV1
+	frames  chan frame
+	release func()
+}
+
+func (s *muxStream) ReadMessage() (int, []byte, error) {
+	f, ok := <-s.frames
+	if !ok {
+		return 0, nil, errStreamClosed
+	}
+	return f.mt, f.data, nil
+}
+
+func (s *muxStream) completed(res *UploadResult) error {
+	s.release()
+	return s.protocolV1.completed(res)
+}
+
+func (s *muxStream) fail(code string, message string) error {
+	s.release()
+	return s.protocolV1.fail(code, message)
+}
+
+func (s *muxStream) interrupted(resume *Resume) error {
+	s.release()
+	return s.protocolV1.interrupted(resume)
+}
+
+// muxLoop читает сообщения соединения и раскладывает их по потокам. Каждый поток загружается
+// в своей горутине тем же кодом, что и единственный файл соединения
+func (e *Endpoint) muxLoop(ctx context.Context, c *conn) {
+	logger := logdoc.GetLogger()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	streams := map[uint32]*muxStream{}
+	defer func() {
+		// соединение закрыто: незавершенные загрузки получают ошибку чтения и прерываются
+		mu.Lock()
+		for id, s := range streams {
+			close(s.frames)
+			delete(streams, id)
+		}
+		mu.Unlock()
+		wg.Wait()
+	}()
+
+	// предел размера на время соединения фиксируется при подключении, как и в ProtocolV1
+	maxSize := config.GetConfig().Upload.MaxSize
+	err := c.writeJSON(ReadyMessage{Type: MessageReady, Protocol: ProtocolMux, MaxSize: maxSize, MaxStreams: e.maxStreams})
+	if err != nil {
+		logger.Error("Error sending status:", err)
+		return
+	}
+	logger.Debug(">> WebSocketUploadHandler > Client connected in multiplexed mode")
+
+	// failStream ошибка потока, которому не удалось передать сообщение
+	failStream := func(id uint32, code string, message string) {
+		if err := (&protocolV1{conn: c, stream: id}).fail(code, message); err != nil {
+			logger.Error("Error sending status:", err)
+		}
+	}
+
+	for {
+		mt, message, err := c.ws.ReadMessage()
+		if err != nil {
+			logger.Debug(fmt.Sprintf(">> WebSocketUploadHandler > multiplexed connection closed: %v", err))
+			return
+		}
+
+		var id uint32
+		switch mt {
+		case websocket.BinaryMessage:
+			if len(message) < streamHeaderSize {
+				failStream(0, ErrorInvalidMessage, "Binary block must start with a 4-byte stream id")
+				continue
+			}
+			id = binary.BigEndian.Uint32(message)
+			message = message[streamHeaderSize:]
+		case websocket.TextMessage:
+			var msg ClientMessage
+			if err = json.Unmarshal(message, &msg); err != nil || msg.Stream == 0 {
+				failStream(0, ErrorInvalidMessage, "Invalid message, expecting JSON object with type and non-zero stream")
+				continue
+			}
+			id = msg.Stream
+			if msg.Type == MessageStart {
+				mu.Lock()
+				_, busy := streams[id]
+				full := len(streams) >= e.maxStreams
+				if busy || full {
+					mu.Unlock()
+					if busy {
+						failStream(id, ErrorStreamInUse, fmt.Sprintf("Stream %d is already open", id))
+					} else {
+						failStream(id, ErrorTooManyStreams, fmt.Sprintf("At most %d streams can be open at once", e.maxStreams))
+					}
+					continue
+				}
+				s := &muxStream{
+					protocolV1: &protocolV1{conn: c, maxSize: maxSize, stream: id},
+					frames:     make(chan frame, streamBacklog),
+				}
+				var once sync.Once
+				s.release = func() {
+					once.Do(func() {
+						mu.Lock()
+						if streams[id] == s {
+							delete(streams, id)
+						}
+						mu.Unlock()
+					})
+				}
+				streams[id] = s
+				mu.Unlock()
+
+				wg.Add(1)
+				go func(header *ClientMessage) {
+					defer wg.Done()
+					defer s.release()
+					e.uploadFile(ctx, s, s, header.header())
+				}(&msg)
+				continue
+			}
+		default:
+			continue
+		}
+
+		mu.Lock()
+		s, ok := streams[id]
+		if ok {
+			f := frame{mt: mt, data: message}
+			select {
+			case s.frames <- f:
+			default:
+				if mt == websocket.TextMessage {
+					// cancel приходит и пока блок ждет обработки: блок уже не нужен.
+					// Читает очередь только поток, поэтому после вытеснения место в ней есть
+					select {
+					case <-s.frames:
+					default:
+					}
+					s.frames <- f
+					break
+				}
+				// клиент не дождался next: прерываем только этот поток
+				close(s.frames)
+				delete(streams, id)
+			}
+		}
+		mu.Unlock()
+		if !ok {
+			failStream(id, ErrorUnknownStream, fmt.Sprintf("Stream %d is not open", id))
+		}
+	}
+}
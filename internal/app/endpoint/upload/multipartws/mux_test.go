@@ -0,0 +1,237 @@
+package multipartws
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"demo-storage/internal/app/interfaces"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/budget"
+	"demo-storage/internal/pkg/drain"
+	"demo-storage/internal/pkg/s3part"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gorilla/websocket"
+)
+
+// fakeStorage хранилище в памяти: файл одной частью вычитывается целиком
+type fakeStorage struct {
+	interfaces.MinioService
+}
+
+func (s *fakeStorage) UploadFileStream(_ context.Context, _ *structs.UploadHeader, _ string, body io.Reader) (*s3manager.UploadOutput, error) {
+	if _, err := io.ReadAll(body); err != nil {
+		return nil, err
+	}
+	return &s3manager.UploadOutput{ETag: aws.String(`"etag"`)}, nil
+}
+
+// loadConfig загружает минимальную конфигурацию: загрузка проверяет заголовок по upload.max-size
+func loadConfig(t *testing.T) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "application.conf")
+	conf := `jwt { issuer = storage, audience = clients }
+db { name = storage, password = secret }
+minio { address = minio, port = "9000", bucket = files }`
+	if err := os.WriteFile(file, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config.MustConfig(file)
+}
+
+// serverMessage поля сообщений сервера, которые проверяет тест
+type serverMessage struct {
+	Type       string `json:"type"`
+	Stream     uint32 `json:"stream"`
+	Code       string `json:"code"`
+	MaxStreams int    `json:"maxStreams"`
+}
+
+// muxStep сообщение клиента и ответ, который сервер присылает на него, nil - сервер молчит
+type muxStep struct {
+	text  string
+	block []byte
+	want  *serverMessage
+}
+
+func start(stream uint32, size int) string {
+	msg, _ := json.Marshal(ClientMessage{Type: MessageStart, Stream: stream, Filename: "file.txt", Size: size})
+	return string(msg)
+}
+
+func cancel(stream uint32) string {
+	msg, _ := json.Marshal(ClientMessage{Type: MessageCancel, Stream: stream})
+	return string(msg)
+}
+
+// block бинарный блок потока: 4 байта номера и данные
+func block(stream uint32, data string) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, stream), data...)
+}
+
+func expect(typ string, stream uint32) *serverMessage {
+	return &serverMessage{Type: typ, Stream: stream}
+}
+
+func expectError(stream uint32, code string) *serverMessage {
+	return &serverMessage{Type: MessageError, Stream: stream, Code: code}
+}
+
+func TestMuxLoop(t *testing.T) {
+	loadConfig(t)
+
+	tests := []struct {
+		name  string
+		steps []muxStep
+	}{
+		{
+			name: "open and complete",
+			steps: []muxStep{
+				{text: start(1, 3)},
+				{block: block(1, "abc"), want: expect(MessageReceived, 1)},
+				{want: expect(MessageResult, 1)},
+			},
+		},
+		{
+			name: "blocks of several streams",
+			steps: []muxStep{
+				{text: start(1, 6)},
+				{text: start(2, 3)},
+				{block: block(1, "abc"), want: expect(MessageNext, 1)},
+				{block: block(2, "xyz"), want: expect(MessageReceived, 2)},
+				{want: expect(MessageResult, 2)},
+				{block: block(1, "def"), want: expect(MessageReceived, 1)},
+				{want: expect(MessageResult, 1)},
+			},
+		},
+		{
+			name: "cancel",
+			steps: []muxStep{
+				{text: start(1, 6)},
+				{block: block(1, "abc"), want: expect(MessageNext, 1)},
+				{text: cancel(1), want: expectError(1, ErrorCancelled)},
+				// отмененный поток закрыт: блоки в него не принимаются
+				{block: block(1, "def"), want: expectError(1, ErrorUnknownStream)},
+			},
+		},
+		{
+			name: "stream id reused after completion",
+			steps: []muxStep{
+				{text: start(1, 3)},
+				{block: block(1, "abc"), want: expect(MessageReceived, 1)},
+				{want: expect(MessageResult, 1)},
+				{text: start(1, 3)},
+				{block: block(1, "abc"), want: expect(MessageReceived, 1)},
+				{want: expect(MessageResult, 1)},
+			},
+		},
+		{
+			name: "stream id reused after cancel",
+			steps: []muxStep{
+				{text: start(1, 6)},
+				{text: cancel(1), want: expectError(1, ErrorCancelled)},
+				{text: start(1, 3)},
+				{block: block(1, "abc"), want: expect(MessageReceived, 1)},
+				{want: expect(MessageResult, 1)},
+			},
+		},
+		{
+			name: "max streams",
+			steps: []muxStep{
+				{text: start(1, 6)},
+				{text: start(2, 6)},
+				{text: start(3, 6), want: expectError(3, ErrorTooManyStreams)},
+				{text: start(1, 6), want: expectError(1, ErrorStreamInUse)},
+				// завершенный поток освобождает место
+				{block: block(2, "abcdef"), want: expect(MessageReceived, 2)},
+				{want: expect(MessageResult, 2)},
+				{text: start(3, 3)},
+				{block: block(3, "abc"), want: expect(MessageReceived, 3)},
+				{want: expect(MessageResult, 3)},
+			},
+		},
+		{
+			name: "invalid messages",
+			steps: []muxStep{
+				{block: block(7, "abc"), want: expectError(7, ErrorUnknownStream)},
+				{block: []byte{0, 1}, want: expectError(0, ErrorInvalidMessage)},
+				{text: `{"type":"start"}`, want: expectError(0, ErrorInvalidMessage)},
+				{text: "not json", want: expectError(0, ErrorInvalidMessage)},
+				{text: cancel(5), want: expectError(5, ErrorUnknownStream)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Endpoint{
+				s:           &fakeStorage{},
+				parallelism: 1,
+				partSize:    s3part.MinSize,
+				maxStreams:  2,
+				budget:      budget.New(64 << 20),
+				drain:       drain.New(),
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer ws.Close()
+				e.muxLoop(r.Context(), &conn{ws: ws})
+			}))
+			defer srv.Close()
+
+			ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.Close()
+
+			read := func() serverMessage {
+				t.Helper()
+				_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+				_, data, err := ws.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var msg serverMessage
+				if err = json.Unmarshal(data, &msg); err != nil {
+					t.Fatalf("invalid server message %s: %v", data, err)
+				}
+				return msg
+			}
+
+			if ready := read(); ready.Type != MessageReady || ready.MaxStreams != e.maxStreams {
+				t.Fatalf("first message %+v, want ready with maxStreams %d", ready, e.maxStreams)
+			}
+			for i, step := range tt.steps {
+				switch {
+				case step.text != "":
+					err = ws.WriteMessage(websocket.TextMessage, []byte(step.text))
+				case step.block != nil:
+					err = ws.WriteMessage(websocket.BinaryMessage, step.block)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if step.want == nil {
+					continue
+				}
+				if got := read(); got.Type != step.want.Type || got.Stream != step.want.Stream || got.Code != step.want.Code {
+					t.Fatalf("step %d: got %+v, want %+v", i+1, got, *step.want)
+				}
+			}
+		})
+	}
+}
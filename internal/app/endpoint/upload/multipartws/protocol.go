@@ -0,0 +1,121 @@
+package multipartws
+
+import (
+	"encoding/json"
+	"sync"
+
+	"demo-storage/internal/app/structs"
+	"github.com/gorilla/websocket"
+)
+
+// protocol сообщения загрузки в формате, о котором договорились с клиентом при подключении.
+// Без Sec-WebSocket-Protocol используется прежний протокол из строк и UploadStatus,
+// с ProtocolV1 - типизированные JSON сообщения
+type protocol interface {
+	// header разбирает первое сообщение клиента с именем и размером файла
+	header(mt int, message []byte) (*structs.UploadHeader, error)
+	// cancelled сообщение клиента об отмене загрузки
+	cancelled(mt int, message []byte) bool
+
+	ready() error
+	// next запрашивает следующий блок, acknowledged - сколько байт файла уже принято
+	next(acknowledged int) error
+	// partUploaded часть multipart загрузки ушла в хранилище, completed - сколько частей загружено всего
+	partUploaded(partNum int, size int, completed int64) error
+	// received все байты multipart загрузки получены, оставшиеся части догружаются
+	received(total int) error
+	// stored файл загрузки одной частью сохранен в хранилище
+	stored(total int) error
+	// uploaded файл загружен, до распаковки архива
+	uploaded(res *UploadResult) error
+	extracted(ex *Extraction) error
+	// completed последнее сообщение успешной загрузки
+	completed(res *UploadResult) error
+	fail(code string, message string) error
+	// interrupted загрузка прервана остановкой сервиса. resume - откуда продолжить ее по tus,
+	// nil если состояние загрузки не сохранено и ее нужно начать заново
+	interrupted(resume *Resume) error
+	// resume откуда продолжить по tus загрузку id, сохраненную со смещением offset
+	resume(id string, offset int64) *Resume
+}
+
+// frames источник сообщений одной загрузки: websocket целиком или поток мультиплексированного соединения
+type frames interface {
+	ReadMessage() (messageType int, p []byte, err error)
+}
+
+// Коды ошибок загрузки. В прежнем протоколе все ошибки приходят с кодом 400
+const (
+	ErrorInvalidMessage = "INVALID_MESSAGE"
+	ErrorInvalidHeader  = "INVALID_HEADER"
+	ErrorFileTooLarge   = "FILE_TOO_LARGE"
+	ErrorSizeMismatch   = "SIZE_MISMATCH"
+	ErrorCancelled      = "CANCELLED"
+	ErrorReceiveFailed  = "RECEIVE_FAILED"
+	ErrorUploadFailed   = "UPLOAD_FAILED"
+	// сервис останавливается: новая загрузка не принята или активная не успела завершиться
+	ErrorShutdown = "SERVER_SHUTDOWN"
+	// только в мультиплексированном соединении
+	ErrorTooManyStreams = "TOO_MANY_STREAMS"
+	ErrorStreamInUse    = "STREAM_IN_USE"
+	ErrorUnknownStream  = "UNKNOWN_STREAM"
+)
+
+// UploadResult итог загрузки файла
+type UploadResult struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
+	ETag string `json:"etag"`
+	// Checksum SHA-256 принятых байт файла в hex
+	Checksum   string      `json:"checksum"`
+	Extraction *Extraction `json:"extraction,omitempty"`
+}
+
+// Resume откуда продолжить загрузку, прерванную остановкой сервиса: PATCH запросы tus
+// на UploadURL начиная со смещения Offset
+type Resume struct {
+	UploadURL string `json:"uploadUrl"`
+	Offset    int64  `json:"offset"`
+}
+
+// Extraction итог распаковки архива. Ошибка распаковки не отменяет загрузку самого архива
+type Extraction struct {
+	Files int    `json:"files"`
+	Error string `json:"error,omitempty"`
+}
+
+// conn websocket соединение загрузки. Писать в websocket может только 1 горутина
+// в один момент времени, а прогресс частей отправляют горутины загрузки, поэтому все записи идут под mu
+type conn struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+	// tusURL адрес tus загрузок, по которому продолжаются прерванные загрузки
+	tusURL string
+}
+
+func (c *conn) writeText(msg []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteMessage(websocket.TextMessage, msg)
+}
+
+func (c *conn) writeJSON(v any) error {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeText(msg)
+}
+
+// resume адрес tus загрузки id для продолжения со смещения offset
+func (c *conn) resume(id string, offset int64) *Resume {
+	return &Resume{UploadURL: c.tusURL + "/" + id, Offset: offset}
+}
+
+// newProtocol выбирает протокол по согласованному при подключении Sec-WebSocket-Protocol
+func newProtocol(c *conn, maxSize int) protocol {
+	if c.ws.Subprotocol() == ProtocolV1 {
+		return &protocolV1{conn: c, maxSize: maxSize}
+	}
+	return &legacyProtocol{conn: c}
+}
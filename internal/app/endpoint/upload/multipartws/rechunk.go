@@ -0,0 +1,166 @@
+package multipartws
+
+import (
+	"bytes"
+	"io"
+
+	"demo-storage/internal/pkg/compress"
+)
+
+// partMemory бюджет памяти под части: reserve занимает память под часть размера size и возвращает
+// занятый вес, release возвращает его
+type partMemory interface {
+	reserve(size int) (int64, error)
+	release(weight int64)
+}
+
+// part часть S3 вместе с занятой под нее памятью бюджета. Память освобождает тот, кто загрузил часть
+type part struct {
+	data   []byte
+	weight int64
+}
+
+// partBuffer копит websocket сообщения произвольного размера и нарезает их
+// на части S3 фиксированного размера. Размер клиентского блока от размера части не зависит.
+// Память под часть занимается в бюджете до ее заполнения и уходит вместе с готовой частью
+type partBuffer struct {
+	size   int
+	mem    partMemory
+	buf    []byte
+	weight int64
+}
+
+func newPartBuffer(size int, mem partMemory) *partBuffer {
+	return &partBuffer{size: size, mem: mem}
+}
+
+// Reserve занимает память под следующую часть, если она еще не занята. Вызывается до запроса
+// следующего блока у клиента, поэтому принятый блок ложится в уже учтенную в бюджете память
+func (b *partBuffer) Reserve() error {
+	if b.buf != nil {
+		return nil
+	}
+	weight, err := b.mem.reserve(b.size)
+	if err != nil {
+		return err
+	}
+	b.buf, b.weight = make([]byte, 0, b.size), weight
+	return nil
+}
+
+// Write добавляет данные в буфер и возвращает набравшиеся целиком части
+func (b *partBuffer) Write(data []byte) ([]part, error) {
+	var parts []part
+	for len(data) > 0 {
+		if err := b.Reserve(); err != nil {
+			b.mem.release(weightOf(parts))
+			return nil, err
+		}
+		n := min(b.size-len(b.buf), len(data))
+		b.buf = append(b.buf, data[:n]...)
+		data = data[n:]
+		if len(b.buf) == b.size {
+			parts = append(parts, part{data: b.buf, weight: b.weight})
+			b.buf, b.weight = nil, 0
+		}
+	}
+	return parts, nil
+}
+
+// Flush возвращает остаток буфера - последнюю часть, которая может быть меньше 5 MiB
+func (b *partBuffer) Flush() *part {
+	if len(b.buf) == 0 {
+		b.Release()
+		return nil
+	}
+	rest := &part{data: b.buf, weight: b.weight}
+	b.buf, b.weight = nil, 0
+	return rest
+}
+
+// Release освобождает память незаполненной части, если загрузка прервана
+func (b *partBuffer) Release() {
+	b.mem.release(b.weight)
+	b.buf, b.weight = nil, 0
+}
+
+func weightOf(parts []part) int64 {
+	var weight int64
+	for _, p := range parts {
+		weight += p.weight
+	}
+	return weight
+}
+
+// partSplitter нарезает блоки клиента на части S3
+type partSplitter interface {
+	// Write возвращает набравшиеся целиком части
+	Write(data []byte) ([]part, error)
+	// Flush возвращает оставшиеся части после последнего блока
+	Flush() ([]part, error)
+	// Reserve занимает память под часть, в которую ляжет следующий блок
+	Reserve() error
+	// Release освобождает память незаполненной части
+	Release()
+}
+
+func newPartSplitter(codec string, size int, mem partMemory) (partSplitter, error) {
+	if codec == compress.NONE {
+		return plainPartBuffer{newPartBuffer(size, mem)}, nil
+	}
+	return newCompressedPartBuffer(codec, size, mem)
+}
+
+type plainPartBuffer struct {
+	*partBuffer
+}
+
+func (p plainPartBuffer) Flush() ([]part, error) {
+	if rest := p.partBuffer.Flush(); rest != nil {
+		return []part{*rest}, nil
+	}
+	return nil, nil
+}
+
+// compressedPartBuffer сжимает блоки клиента и нарезает на части уже сжатый поток
+type compressedPartBuffer struct {
+	*partBuffer
+	out bytes.Buffer
+	w   io.WriteCloser
+}
+
+func newCompressedPartBuffer(codec string, size int, mem partMemory) (*compressedPartBuffer, error) {
+	b := &compressedPartBuffer{partBuffer: newPartBuffer(size, mem)}
+	w, err := compress.NewWriter(codec, &b.out)
+	if err != nil {
+		return nil, err
+	}
+	b.w = w
+	return b, nil
+}
+
+// Write сжимает данные и возвращает набравшиеся целиком части сжатого потока
+func (b *compressedPartBuffer) Write(data []byte) ([]part, error) {
+	if _, err := b.w.Write(data); err != nil {
+		return nil, err
+	}
+	parts, err := b.partBuffer.Write(b.out.Bytes())
+	b.out.Reset()
+	return parts, err
+}
+
+// Flush завершает сжатый поток и возвращает оставшиеся части
+func (b *compressedPartBuffer) Flush() ([]part, error) {
+	if err := b.w.Close(); err != nil {
+		return nil, err
+	}
+	parts, err := b.partBuffer.Write(b.out.Bytes())
+	b.out.Reset()
+	if err != nil {
+		return nil, err
+	}
+	if rest := b.partBuffer.Flush(); rest != nil {
+		parts = append(parts, *rest)
+	}
+	return parts, nil
+}
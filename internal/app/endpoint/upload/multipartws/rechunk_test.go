@@ -0,0 +1,186 @@
+package multipartws
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"demo-storage/internal/pkg/compress"
+)
+
+var errBudgetExhausted = errors.New("budget exhausted")
+
+// fakeMemory бюджет теста: считает занятую память и отказывает сверх limit вместо ожидания
+type fakeMemory struct {
+	held  int64
+	limit int64
+}
+
+func (m *fakeMemory) reserve(size int) (int64, error) {
+	if m.held+int64(size) > m.limit {
+		return 0, errBudgetExhausted
+	}
+	m.held += int64(size)
+	return int64(size), nil
+}
+
+func (m *fakeMemory) release(weight int64) {
+	m.held -= weight
+}
+
+func sizes(parts []part) []int {
+	var res []int
+	for _, p := range parts {
+		res = append(res, len(p.data))
+	}
+	return res
+}
+
+func TestPartBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		blocks []int
+		parts  []int
+	}{
+		{name: "blocks smaller than part", size: 10, blocks: []int{4, 4, 4, 4, 4}, parts: []int{10, 10}},
+		{name: "blocks equal to part", size: 10, blocks: []int{10, 10, 3}, parts: []int{10, 10, 3}},
+		{name: "blocks larger than part", size: 10, blocks: []int{25, 7}, parts: []int{10, 10, 10, 2}},
+		{name: "single short block", size: 10, blocks: []int{3}, parts: []int{3}},
+		{name: "empty blocks", size: 10, blocks: []int{0, 10, 0}, parts: []int{10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := &fakeMemory{limit: 1 << 20}
+			splitter, err := newPartSplitter(compress.NONE, tt.size, mem)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var input, output []byte
+			var parts []part
+			for i, n := range tt.blocks {
+				block := bytes.Repeat([]byte{byte('a' + i)}, n)
+				input = append(input, block...)
+				got, err := splitter.Write(block)
+				if err != nil {
+					t.Fatal(err)
+				}
+				parts = append(parts, got...)
+			}
+			rest, err := splitter.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts = append(parts, rest...)
+
+			if got := sizes(parts); !slices.Equal(got, tt.parts) {
+				t.Errorf("part sizes %v, want %v", got, tt.parts)
+			}
+			for _, p := range parts {
+				output = append(output, p.data...)
+			}
+			if !bytes.Equal(output, input) {
+				t.Error("parts do not add up to the input")
+			}
+			// память частей уходит вместе с ними, у буфера после Flush ничего не остается
+			if held := weightOf(parts); mem.held != held {
+				t.Errorf("budget holds %d bytes, parts carry %d", mem.held, held)
+			}
+		})
+	}
+}
+
+func TestCompressedPartBuffer(t *testing.T) {
+	for _, codec := range []string{compress.GZIP, compress.ZSTD} {
+		t.Run(codec, func(t *testing.T) {
+			const size = 1 << 10
+			mem := &fakeMemory{limit: 1 << 20}
+			splitter, err := newPartSplitter(codec, size, mem)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// несжимаемые данные дают больше одной части
+			input := make([]byte, 5000)
+			rand.New(rand.NewSource(1)).Read(input)
+			var parts []part
+			for off := 0; off < len(input); off += 700 {
+				got, err := splitter.Write(input[off:min(off+700, len(input))])
+				if err != nil {
+					t.Fatal(err)
+				}
+				parts = append(parts, got...)
+			}
+			rest, err := splitter.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts = append(parts, rest...)
+
+			var stream []byte
+			for i, p := range parts {
+				if i < len(parts)-1 && len(p.data) != size {
+					t.Errorf("part %d has %d bytes, want %d", i+1, len(p.data), size)
+				}
+				stream = append(stream, p.data...)
+			}
+			r, err := compress.NewReader(codec, bytes.NewReader(stream))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			output, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(output, input) {
+				t.Error("decompressed parts differ from the input")
+			}
+			if held := weightOf(parts); mem.held != held {
+				t.Errorf("budget holds %d bytes, parts carry %d", mem.held, held)
+			}
+		})
+	}
+}
+
+func TestPartBufferBudget(t *testing.T) {
+	mem := &fakeMemory{limit: 20}
+	splitter, err := newPartSplitter(compress.NONE, 10, mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// память под следующую часть занимается до прихода блока
+	if err = splitter.Reserve(); err != nil {
+		t.Fatal(err)
+	}
+	if mem.held != 10 {
+		t.Fatalf("reserved %d bytes, want 10", mem.held)
+	}
+
+	// блок на три части в бюджет на две не помещается: набранные части возвращаются в бюджет
+	if _, err = splitter.Write(make([]byte, 25)); !errors.Is(err, errBudgetExhausted) {
+		t.Fatalf("Write error %v, want %v", err, errBudgetExhausted)
+	}
+	if mem.held != 0 {
+		t.Errorf("budget holds %d bytes after failed write, want 0", mem.held)
+	}
+
+	parts, err := splitter.Write(make([]byte, 15))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem.held != 20 {
+		t.Errorf("budget holds %d bytes, want a full and a started part", mem.held)
+	}
+	mem.release(weightOf(parts))
+	// прерванная загрузка освобождает память незаполненной части
+	splitter.Release()
+	if mem.held != 0 {
+		t.Errorf("budget holds %d bytes after release, want 0", mem.held)
+	}
+}
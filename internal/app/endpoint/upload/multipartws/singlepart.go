@@ -1,70 +1,133 @@
 package multipartws
 
 import (
+	"context"
+	"crypto/sha256"
+	minio "demo-storage/internal/app/service"
 	"demo-storage/internal/app/structs"
+	"demo-storage/internal/pkg/drain"
+	"demo-storage/internal/pkg/logging"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/gorilla/websocket"
-	"sync"
+	"io"
+	"strings"
 )
 
-func (e *Endpoint) singlePartUpload(ws *websocket.Conn, mu sync.Locker, header *structs.UploadHeader) (int, error) {
-	logger := logdoc.GetLogger()
-	buf := make([]byte, 0, header.Size)
+func (e *Endpoint) singlePartUpload(ctx context.Context, in frames, p protocol, header *structs.UploadHeader) (*UploadResult, error) {
+	logger := logging.FromContext(ctx)
 	bytesRead := 0
+	checksum := sha256.New()
+
+	// грузим файл в s3 потоком: блоки из websocket пишутся в pipe, с другой стороны
+	// его читает загрузчик хранилища. Файл целиком в памяти не держим
+	pr, pw := io.Pipe()
+	uploaded := make(chan error, 1)
+	var etag string
+	go func() {
+		out, err := e.s.UploadFileStream(ctx, header, "", pr)
+		if err == nil {
+			etag = strings.Trim(aws.StringValue(out.ETag), `"`)
+		}
+		// если загрузка оборвалась раньше, разблокируем запись в pipe
+		pr.CloseWithError(err)
+		uploaded <- err
+	}()
+	fail := func(err error) {
+		pw.CloseWithError(err)
+		<-uploaded
+	}
 
-	// грузим файл в s3 через обычный метод
 	for {
-		mt, message, err := ws.ReadMessage()
+		mt, message, err := in.ReadMessage()
+		if err != nil && e.drain.Interrupted().Err() != nil {
+			// файл меньше одной части, продолжить его нельзя: клиент загрузит его заново
+			fail(drain.ErrInterrupted)
+			if er := p.interrupted(nil); er != nil {
+				logger.Error("Error sending status:", er)
+			}
+			return nil, drain.ErrInterrupted
+		}
 		if err != nil {
-			err = e.sendStatus(ws, 400, fmt.Sprintf("Error receiving file block: %s", err.Error()))
-			if err != nil {
-				logger.Error("Error sending status:", err)
-				return bytesRead, err
+			fail(err)
+			if er := p.fail(ErrorReceiveFailed, fmt.Sprintf("Error receiving file block: %s", err.Error())); er != nil {
+				logger.Error("Error sending status:", er)
 			}
-			return bytesRead, err
+			return nil, err
 		}
 
 		if mt != websocket.BinaryMessage {
-			if mt == websocket.TextMessage {
-				if string(message) == "CANCEL" {
-					err = e.sendStatus(ws, 400, "Upload canceled")
-					if err != nil {
-						logger.Error("Error sending status:", err)
-						return bytesRead, err
-					}
-					return bytesRead, err
+			if p.cancelled(mt, message) {
+				fail(minio.ErrUploadCanceled)
+				err = p.fail(ErrorCancelled, "Upload canceled")
+				if err != nil {
+					logger.Error("Error sending status:", err)
+					return nil, err
 				}
+				return nil, minio.ErrUploadCanceled
 			}
 
+			fail(errors.New("invalid file block received"))
 			logger.Debug("Invalid file block received, expecting binary chunk, closing")
-			err = e.sendStatus(ws, 400, "Invalid file block received")
+			err = p.fail(ErrorInvalidMessage, "Invalid file block received")
 			if err != nil {
-				return bytesRead, err
+				return nil, err
 			}
 
-			return bytesRead, err
+			return nil, errors.New("invalid file block received")
 		}
 
-		buf = append(buf, message...)
 		bytesRead += len(message)
 		logger.Debug(fmt.Sprintf(">> Websocket receiver > binary chunk received, size:%d. total bytes received:%d", len(message), bytesRead))
 
+		if bytesRead > header.Size {
+			cause := fmt.Errorf("received %d bytes, declared size is %d", bytesRead, header.Size)
+			fail(cause)
+			if err = p.fail(ErrorSizeMismatch, "Invalid file size: "+cause.Error()); err != nil {
+				logger.Error("Error sending status:", err)
+			}
+			return nil, cause
+		}
+		checksum.Write(message)
+
+		// Запись блокируется, пока загрузчик не заберет данные - так же работает backpressure
+		if _, err = pw.Write(message); err != nil {
+			cause := <-uploaded
+			if err = p.fail(ErrorUploadFailed, fmt.Sprintf("Error uploading file: %v", cause)); err != nil {
+				logger.Error("Error sending status:", err)
+			}
+			return nil, cause
+		}
+
 		if bytesRead == header.Size {
-			e.s.UploadFileAsBytes(header, buf)
-			err = e.sendPct(ws, mu, 100)
+			pw.Close()
+			if cause := <-uploaded; cause != nil {
+				if err = p.fail(ErrorUploadFailed, "Error uploading file: "+cause.Error()); err != nil {
+					logger.Error("Error sending status:", err)
+				}
+				return nil, cause
+			}
+			err = p.stored(bytesRead)
 			if err != nil {
-				return bytesRead, err
+				return nil, err
 			}
 			break
 		}
 
-		err = e.requestNextBlock(ws)
+		err = p.next(bytesRead)
 		if err != nil {
 			logger.Error("Error receiving next block:", err)
-			return bytesRead, err
+			fail(err)
+			return nil, err
 		}
 	}
 
-	return bytesRead, nil
+	return &UploadResult{
+		Key:      header.Filename,
+		Size:     bytesRead,
+		ETag:     etag,
+		Checksum: hex.EncodeToString(checksum.Sum(nil)),
+	}, nil
 }
@@ -0,0 +1,126 @@
+package multipartws
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// partUploader загружает части одной multipart сессии ограниченным числом горутин.
+// Submit блокируется, пока не освободится слот, а память под следующую часть занимается
+// до запроса следующего блока, поэтому NEXT клиенту уходит только после этого - так работает backpressure
+type partUploader struct {
+	e       *Endpoint
+	p       protocol
+	conn    *s3.S3
+	session *s3.CreateMultipartUploadOutput
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	slots  chan struct{}
+	wg     sync.WaitGroup
+	cnt    int64
+
+	resMu sync.Mutex
+	parts []*s3.CompletedPart
+	err   error
+}
+
+// ctx несет спан загрузки, отмена загрузки от него не зависит
+func (e *Endpoint) newPartUploader(ctx context.Context, p protocol, conn *s3.S3, session *s3.CreateMultipartUploadOutput) *partUploader {
+	ctx, cancel := context.WithCancel(ctx)
+	return &partUploader{
+		e:       e,
+		p:       p,
+		conn:    conn,
+		session: session,
+		ctx:     ctx,
+		cancel:  cancel,
+		slots:   make(chan struct{}, e.parallelism),
+	}
+}
+
+// Submit ставит часть в очередь на загрузку. Память под часть уже занята в бюджете при ее накоплении,
+// после загрузки или при ошибке Submit ее освобождает. Возвращает ошибку, если загрузка уже отменена
+// или одна из предыдущих частей не загрузилась
+func (u *partUploader) Submit(p part, partNum int) error {
+	if err := u.failed(); err != nil {
+		u.release(p.weight)
+		return err
+	}
+
+	select {
+	case u.slots <- struct{}{}:
+	case <-u.ctx.Done():
+		u.release(p.weight)
+		return u.ctx.Err()
+	}
+
+	u.wg.Add(1)
+	go func() {
+		defer func() {
+			<-u.slots
+			u.release(p.weight)
+			u.wg.Done()
+		}()
+
+		res := u.e.s.UploadPartToS3(u.ctx, u.conn, u.session, p.data, partNum)
+		u.resMu.Lock()
+		if res.Err != nil {
+			if u.err == nil {
+				u.err = res.Err
+				// Остальные части загружать уже бессмысленно
+				u.cancel()
+			}
+			u.resMu.Unlock()
+			return
+		}
+		u.parts = append(u.parts, res.CompletedPart)
+		u.resMu.Unlock()
+		_ = u.p.partUploaded(partNum, len(p.data), atomic.AddInt64(&u.cnt, 1))
+	}()
+	return nil
+}
+
+// reserve занимает память под часть в общем бюджете, ждет, пока ее освободят другие загрузки.
+// Ожидание прерывается отменой загрузки
+func (u *partUploader) reserve(size int) (int64, error) {
+	return u.e.budget.Acquire(u.ctx, size)
+}
+
+func (u *partUploader) release(weight int64) {
+	u.e.budget.Release(weight)
+}
+
+// Wait дожидается загрузки всех частей и возвращает их отсортированными по номеру
+func (u *partUploader) Wait() ([]*s3.CompletedPart, error) {
+	u.wg.Wait()
+	if err := u.failed(); err != nil {
+		return nil, err
+	}
+
+	// сортируем куски по PartNumber тк
+	// каждая часть может грузиться в произвольном порядке
+	sort.Slice(u.parts, func(i, j int) bool {
+		return *u.parts[i].PartNumber < *u.parts[j].PartNumber
+	})
+	return u.parts, nil
+}
+
+// Cancel прерывает все загружаемые в данный момент части и ждет завершения горутин
+func (u *partUploader) Cancel() {
+	u.cancel()
+	u.wg.Wait()
+}
+
+func (u *partUploader) failed() error {
+	u.resMu.Lock()
+	defer u.resMu.Unlock()
+	if u.err != nil {
+		return u.err
+	}
+	return u.ctx.Err()
+}
@@ -0,0 +1,179 @@
+package multipartws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"demo-storage/internal/app/structs"
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolV1 значение Sec-WebSocket-Protocol для протокола, в котором все текстовые сообщения -
+// JSON объекты с полем type. Данные файла, как и раньше, передаются бинарными сообщениями
+const ProtocolV1 = "storage-upload.v1"
+
+// Типы сообщений ProtocolV1.
+// Клиент: start - заголовок загрузки, cancel - отмена.
+// Сервер: ready - готов принять start, next - прислать следующий блок, part - часть ушла в хранилище,
+// received - все байты получены, result - загрузка завершена, error - загрузка прервана,
+// shutdown - сервис останавливается, незавершенные к дедлайну загрузки будут прерваны.
+// result и error - последние сообщения загрузки
+const (
+	MessageStart    = "start"
+	MessageCancel   = "cancel"
+	MessageReady    = "ready"
+	MessageNext     = "next"
+	MessagePart     = "part"
+	MessageReceived = "received"
+	MessageResult   = "result"
+	MessageError    = "error"
+	MessageShutdown = "shutdown"
+)
+
+// ClientMessage сообщение клиента. Поля заголовка заполняются только в start.
+// Stream - номер потока мультиплексированного соединения, в ProtocolV1 не используется
+type ClientMessage struct {
+	Type        string `json:"type"`
+	Stream      uint32 `json:"stream,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	Size        int    `json:"size,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Extract     bool   `json:"extract,omitempty"`
+	Prefix      string `json:"prefix,omitempty"`
+}
+
+type ReadyMessage struct {
+	Type       string `json:"type"`
+	Protocol   string `json:"protocol"`
+	MaxSize    int    `json:"maxSize,omitempty"`
+	MaxStreams int    `json:"maxStreams,omitempty"`
+}
+
+type NextMessage struct {
+	Type              string `json:"type"`
+	Stream            uint32 `json:"stream,omitempty"`
+	BytesAcknowledged int    `json:"bytesAcknowledged"`
+}
+
+type PartMessage struct {
+	Type           string `json:"type"`
+	Stream         uint32 `json:"stream,omitempty"`
+	PartNumber     int    `json:"partNumber"`
+	Size           int    `json:"size"`
+	PartsCompleted int64  `json:"partsCompleted"`
+}
+
+type ReceivedMessage struct {
+	Type          string `json:"type"`
+	Stream        uint32 `json:"stream,omitempty"`
+	BytesReceived int    `json:"bytesReceived"`
+}
+
+type ResultMessage struct {
+	Type   string `json:"type"`
+	Stream uint32 `json:"stream,omitempty"`
+	UploadResult
+}
+
+type ErrorMessage struct {
+	Type    string `json:"type"`
+	Stream  uint32 `json:"stream,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Resume только у загрузок, прерванных остановкой сервиса
+	Resume *Resume `json:"resume,omitempty"`
+}
+
+// ShutdownMessage отправляется всему соединению, поэтому без номера потока
+type ShutdownMessage struct {
+	Type     string    `json:"type"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// protocolV1 сообщения ProtocolV1. В мультиплексированном соединении stream - номер потока загрузки
+type protocolV1 struct {
+	*conn
+	maxSize int
+	stream  uint32
+}
+
+func (p *protocolV1) header(mt int, message []byte) (*structs.UploadHeader, error) {
+	if mt != websocket.TextMessage {
+		return nil, errors.New("Invalid message received, expecting start message")
+	}
+	var msg ClientMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return nil, errors.New("Invalid start message: " + err.Error())
+	}
+	if msg.Type != MessageStart {
+		return nil, fmt.Errorf("Unexpected message type %q, expecting %q", msg.Type, MessageStart)
+	}
+	return msg.header(), nil
+}
+
+func (msg *ClientMessage) header() *structs.UploadHeader {
+	return &structs.UploadHeader{
+		Filename:    msg.Filename,
+		Size:        msg.Size,
+		ContentType: msg.ContentType,
+		Extract:     msg.Extract,
+		Prefix:      msg.Prefix,
+	}
+}
+
+func (p *protocolV1) cancelled(mt int, message []byte) bool {
+	if mt != websocket.TextMessage {
+		return false
+	}
+	var msg ClientMessage
+	return json.Unmarshal(message, &msg) == nil && msg.Type == MessageCancel
+}
+
+func (p *protocolV1) ready() error {
+	return p.writeJSON(ReadyMessage{Type: MessageReady, Protocol: ProtocolV1, MaxSize: p.maxSize})
+}
+
+func (p *protocolV1) next(acknowledged int) error {
+	return p.writeJSON(NextMessage{Type: MessageNext, Stream: p.stream, BytesAcknowledged: acknowledged})
+}
+
+// partUploaded ошибку отправки прогресса не возвращает: прогресс не должен прерывать загрузку
+func (p *protocolV1) partUploaded(partNum int, size int, completed int64) error {
+	_ = p.writeJSON(PartMessage{Type: MessagePart, Stream: p.stream, PartNumber: partNum, Size: size, PartsCompleted: completed})
+	return nil
+}
+
+func (p *protocolV1) received(total int) error {
+	return p.writeJSON(ReceivedMessage{Type: MessageReceived, Stream: p.stream, BytesReceived: total})
+}
+
+func (p *protocolV1) stored(total int) error {
+	return p.received(total)
+}
+
+// uploaded и extracted ничего не отправляют: итог загрузки и распаковки приходит одним result
+func (p *protocolV1) uploaded(*UploadResult) error {
+	return nil
+}
+
+func (p *protocolV1) extracted(*Extraction) error {
+	return nil
+}
+
+func (p *protocolV1) completed(res *UploadResult) error {
+	return p.writeJSON(ResultMessage{Type: MessageResult, Stream: p.stream, UploadResult: *res})
+}
+
+func (p *protocolV1) fail(code string, message string) error {
+	return p.writeJSON(ErrorMessage{Type: MessageError, Stream: p.stream, Code: code, Message: message})
+}
+
+func (p *protocolV1) interrupted(resume *Resume) error {
+	msg := "Upload interrupted by service shutdown, start it again later"
+	if resume != nil {
+		msg = "Upload interrupted by service shutdown, resume it with tus"
+	}
+	return p.writeJSON(ErrorMessage{Type: MessageError, Stream: p.stream, Code: ErrorShutdown, Message: msg, Resume: resume})
+}
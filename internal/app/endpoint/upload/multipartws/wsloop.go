@@ -1,26 +1,33 @@
 package multipartws
 
 import (
+	"context"
+	"demo-storage/internal/app/endpoint/upload"
+	"demo-storage/internal/app/metrics"
 	"demo-storage/internal/app/structs"
-	"encoding/json"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/logging"
+	"demo-storage/internal/pkg/tracing"
 	"errors"
 	"fmt"
 	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
 	"github.com/gorilla/websocket"
-	"sync"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func (e *Endpoint) processingLoop(ws *websocket.Conn, mu sync.Locker) {
+func (e *Endpoint) processingLoop(ctx context.Context, ws *websocket.Conn, p protocol) {
 	logger := logdoc.GetLogger()
 
-	err := e.sendStatus(ws, 200, "READY")
+	err := p.ready()
 	if err != nil {
 		panic(err)
 	}
 	logger.Debug(">> WebSocketUploadHandler > Client connected, ready for interaction...")
 
 	// waiting for file name and length from client.
-	header := new(structs.UploadHeader)
 	mt, message, err := ws.ReadMessage()
 	if err != nil {
 		var closeErr *websocket.CloseError
@@ -34,105 +41,105 @@ func (e *Endpoint) processingLoop(ws *websocket.Conn, mu sync.Locker) {
 		}
 		return
 	}
-	if mt != websocket.TextMessage {
-		err = e.sendStatus(ws, 400, "Invalid message received, expecting file name and length")
-		logger.Error("Error receiving websocket message:", err)
-		if err != nil {
-			logger.Error("Error receiving websocket message:", err)
-			return
-		}
-		return
-	}
-	if err = json.Unmarshal(message, header); err != nil {
-		err = e.sendStatus(ws, 400, "Error receiving file name and length: "+err.Error())
-		if err != nil {
+	header, err := p.header(mt, message)
+	if err != nil {
+		logger.Errorf("Error receiving file name and length: %v", err)
+		if err = p.fail(ErrorInvalidMessage, err.Error()); err != nil {
 			logger.Error("Error sending status:", err)
-			return
 		}
-		logger.Errorf("Error receiving file name and length: %v", err)
 		return
 	}
 
-	if len(header.Filename) == 0 {
-		err = e.sendStatus(ws, 400, "Filename cannot be empty")
-		if err != nil {
+	e.uploadFile(ctx, ws, p, header)
+}
+
+// uploadFile загружает один файл по заголовку header: блоки читаются из in, сообщения отправляются через p.
+// Загрузка идет в своем спане, дочернем к спану websocket подключения ctx
+func (e *Endpoint) uploadFile(ctx context.Context, in frames, p protocol, header *structs.UploadHeader) {
+	ctx = logging.WithFields(ctx, logrus.Fields{logging.FieldFile: header.Filename, logging.FieldSession: logging.NewSessionID()})
+	logger := logging.FromContext(ctx)
+
+	// во время остановки сервиса новые загрузки не начинаем
+	end, ok := e.drain.Begin()
+	if !ok {
+		if err := p.fail(ErrorShutdown, "Service is shutting down, retry the upload later"); err != nil {
 			logger.Error("Error sending status:", err)
-			return
 		}
 		return
 	}
+	defer end()
 
-	if header.Size == 0 {
-		err = e.sendStatus(ws, 400, "Upload file is empty")
+	// загрузка не прерывается вместе с контекстом запроса, из него нужен только спан
+	ctx, span := tracing.Tracer().Start(context.WithoutCancel(ctx), "websocket.upload",
+		trace.WithAttributes(
+			attribute.String("file.name", header.Filename),
+			attribute.Int("file.size", header.Size),
+			attribute.Bool("file.extract", header.Extract),
+		))
+	var err error
+	defer func() {
 		if err != nil {
-			logger.Error("Error sending status:", err)
-			return
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	maxSize := config.GetConfig().Upload.MaxSize
+	err = upload.ValidateHeader(header, maxSize)
+	if err != nil {
+		code := ErrorInvalidHeader
+		if maxSize > 0 && header.Size > maxSize {
+			code = ErrorFileTooLarge
+		}
+		if sendErr := p.fail(code, err.Error()); sendErr != nil {
+			logger.Error("Error sending status:", sendErr)
 		}
 		return
 	}
+	defer metrics.StartUploadSession(metrics.ProtocolWebSocket)()
 
 	// MAIN DECISION POINT
 	// multipart upload requires at least 5MB
 	// EACH PART SHOULD BE AT LEAST 5MB !!!
-	var bytesRead int
+	var res *UploadResult
 	if header.Size < 5<<20 {
-		bytesRead, err = e.singlePartUpload(ws, mu, header)
+		res, err = e.singlePartUpload(ctx, in, p, header)
 		if err != nil {
 			logger.Errorf(">> singlePartUpload error : %v", err)
 			return
 		}
 	} else {
-		bytesRead, err = e.multipartUpload(ws, mu, header)
+		res, err = e.multipartUpload(ctx, in, p, header)
 		if err != nil {
 			logger.Errorf(">> multipartUpload error : %v", err)
 			return
 		}
 	}
 
-	err = e.sendStatus(ws, 200, fmt.Sprintf("File upload successful: %s (%d bytes)", header.Filename, bytesRead))
+	err = p.uploaded(res)
 	if err != nil {
 		logger.Error("Error sending status:", err)
 		return
 	}
-	err = e.sendCompleted(ws)
-	if err != nil {
-		logger.Error("Error sending status:", err)
-		return
-	}
-}
 
-func (e *Endpoint) requestNextBlock(ws *websocket.Conn) error {
-	return ws.WriteMessage(websocket.TextMessage, []byte("NEXT"))
-}
+	span.SetAttributes(attribute.String("upload.etag", res.ETag))
 
-func (e *Endpoint) sendUploadCompleted(ws *websocket.Conn) error {
-	return ws.WriteMessage(websocket.TextMessage, []byte("UPLOAD_COMPLETED"))
-}
-
-func (e *Endpoint) sendCompleted(ws *websocket.Conn) error {
-	return ws.WriteMessage(websocket.TextMessage, []byte("COMPLETED"))
-}
-
-func (e *Endpoint) sendStatus(ws *websocket.Conn, code int, status string) error {
-	msg, err := json.Marshal(UploadStatus{Code: code, Status: status})
-	if err == nil {
-		return ws.WriteMessage(websocket.TextMessage, msg)
-	}
-	return nil
-}
-
-func (e *Endpoint) sendPct(ws *websocket.Conn, mu sync.Locker, pct int64) error {
-	// Писать в websocket может только 1 горутина в один момент времени
-	stat := UploadStatus{pct: pct, Status: "part upload completed"}
-	stat.Pct = &stat.pct
-	msg, err := json.Marshal(stat)
-	if err == nil {
-		mu.Lock()
-		err := ws.WriteMessage(websocket.TextMessage, msg)
-		mu.Unlock()
-		if err != nil {
-			return nil
+	if header.Extract {
+		extracted, extractErr := e.s.ExtractArchive(ctx, header.Filename, header.Prefix)
+		res.Extraction = &Extraction{Files: len(extracted)}
+		if extractErr != nil {
+			res.Extraction.Error = extractErr.Error()
+			span.AddEvent("archive extraction failed", trace.WithAttributes(attribute.String("error", extractErr.Error())))
+		}
+		if err = p.extracted(res.Extraction); err != nil {
+			logger.Error("Error sending status:", err)
+			return
 		}
 	}
-	return nil
+	err = p.completed(res)
+	if err != nil {
+		logger.Error("Error sending status:", err)
+		return
+	}
 }
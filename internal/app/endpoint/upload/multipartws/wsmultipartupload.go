@@ -1,31 +1,45 @@
 package multipartws
 
 import (
+	"context"
+	"demo-storage/internal/app/endpoint/tus"
 	"demo-storage/internal/app/interfaces"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/budget"
+	"demo-storage/internal/pkg/drain"
+	"fmt"
 	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
 	"github.com/gorilla/websocket"
-	"github.com/gurkankaymak/hocon"
 	"github.com/labstack/echo/v4"
 	"net/http"
-	"sync"
+	"strconv"
 	"time"
 )
 
 type Endpoint struct {
-	config *hocon.Config
-	s      interfaces.MinioService
+	s           interfaces.MinioService
+	parallelism int
+	partSize    int
+	// maxStreams сколько файлов одновременно загружается через одно мультиплексированное соединение
+	maxStreams int
+	// budget общий для всех загрузок бюджет памяти под части, ожидающие отправки в S3
+	budget *budget.Memory
+	drain  *drain.Drainer
+	// expiration сколько живет tus загрузка, в которую превращается загрузка, прерванная остановкой сервиса
+	expiration time.Duration
 }
 
-func New(s interfaces.MinioService, config *hocon.Config) *Endpoint {
+func New(s interfaces.MinioService, conf *config.Config, mem *budget.Memory, d *drain.Drainer) *Endpoint {
 	// Создаем endpoint и возвращаем
-	return &Endpoint{s: s, config: config}
-}
-
-type UploadStatus struct {
-	Code   int    `json:"code,omitempty"`
-	Status string `json:"status,omitempty"`
-	Pct    *int64 `json:"part,omitempty"` // File processing AFTER uploading is done.
-	pct    int64
+	return &Endpoint{
+		s:           s,
+		parallelism: conf.Upload.Parallelism,
+		partSize:    conf.Upload.PartSize,
+		maxStreams:  conf.Upload.MaxStreams,
+		budget:      mem,
+		drain:       d,
+		expiration:  conf.Tus.Expiration,
+	}
 }
 
 const (
@@ -38,13 +52,16 @@ func (e *Endpoint) WebSocketUploadHandler(ctx echo.Context) error { // Source
 
 	logger := logdoc.GetLogger()
 
-	// we can read or write into websocket only in one goroutine
-	mu := sync.Mutex{}
-
 	logger.Debug("WebSocketUploadHandler > Starting...")
 
+	if e.drain.Draining() {
+		ctx.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(drain.RetryAfterSecs))
+		return echo.NewHTTPError(http.StatusServiceUnavailable, drain.ErrDraining.Error())
+	}
+
 	// Open websocket connection.
-	upgrader := websocket.Upgrader{HandshakeTimeout: time.Second * HandshakeTimeoutSecs}
+	// Клиенты, запросившие ProtocolV1 или ProtocolMux в Sec-WebSocket-Protocol, получают его, остальные - прежний протокол
+	upgrader := websocket.Upgrader{HandshakeTimeout: time.Second * HandshakeTimeoutSecs, Subprotocols: []string{ProtocolMux, ProtocolV1}}
 
 	// A CheckOrigin function should carefully validate the request origin to
 	// prevent cross-site request forgery.
@@ -55,14 +72,39 @@ func (e *Endpoint) WebSocketUploadHandler(ctx echo.Context) error { // Source
 		return true
 	}
 
-	ws, err = upgrader.Upgrade(ctx.Response(), ctx.Request(), nil)
+	// заголовки ответа echo в ответ на upgrade не попадают, идентификатор запроса передаем явно
+	header := http.Header{echo.HeaderXRequestID: []string{ctx.Response().Header().Get(echo.HeaderXRequestID)}}
+	ws, err = upgrader.Upgrade(ctx.Response(), ctx.Request(), header)
 	if err != nil {
 		logger.Debug("Error on open of websocket connection:", err)
 		return echo.NewHTTPError(http.StatusBadRequest, "Error on open of websocket connection")
 	}
 	defer ws.Close()
+	// ReadMessage буферизует кадр целиком еще до проверок бюджета памяти, поэтому блок больше
+	// upload.part-size не принимаем: он ложится в часть, память под которую занята в бюджете.
+	// В мультиплексированном протоколе к блоку добавляется номер потока
+	ws.SetReadLimit(int64(e.partSize) + streamHeaderSize)
+
+	// прерванную остановкой сервиса загрузку клиент продолжает по tus на этом же сервере
+	req := ctx.Request()
+	c := &conn{ws: ws, tusURL: fmt.Sprintf("%s://%s%s", ctx.Scheme(), req.Host, tus.Path)}
 
-	e.processingLoop(ws, &mu)
+	// о начале остановки предупреждаем клиентов с JSON протоколами, прежний протокол такого сообщения не знает.
+	// Не успевшие к дедлайну загрузки прерываются: чтение из websocket обрывается
+	if ws.Subprotocol() != "" {
+		defer e.drain.OnDrain(func(deadline time.Time) {
+			_ = c.writeJSON(ShutdownMessage{Type: MessageShutdown, Deadline: deadline})
+		})()
+	}
+	defer context.AfterFunc(e.drain.Interrupted(), func() {
+		_ = ws.SetReadDeadline(time.Now())
+	})()
+
+	if ws.Subprotocol() == ProtocolMux {
+		e.muxLoop(req.Context(), c)
+		return nil
+	}
+	e.processingLoop(req.Context(), ws, newProtocol(c, config.GetConfig().Upload.MaxSize))
 
 	return nil
 }
@@ -1,43 +1,107 @@
 package upload
 
 import (
-	"demo-storage/internal/app/interfaces"
-	"demo-storage/internal/app/structs"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/gurkankaymak/hocon"
-	"github.com/labstack/echo/v4"
+	"io"
 	"net/http"
 	"net/url"
+
+	"demo-storage/internal/app/interfaces"
+	"demo-storage/internal/app/metrics"
+	minio "demo-storage/internal/app/service"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/logging"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
 )
 
 type Endpoint struct {
-	config *hocon.Config
-	s      interfaces.MinioService
+	s interfaces.MinioService
 }
 
-func New(s interfaces.MinioService, config *hocon.Config) *Endpoint {
+func New(s interfaces.MinioService) *Endpoint {
 	// Создаем endpoint и возвращаем
-	return &Endpoint{s: s, config: config}
+	return &Endpoint{s: s}
 }
 
+// UploadHandler принимает один или несколько файлов в multipart/form-data и потоково,
+// без временных файлов, загружает их в хранилище. Возвращает результат по каждому файлу.
+// Если не загрузился ни один файл, статус 400 означает ошибки клиента, 500 - ошибку хранилища
 func (e *Endpoint) UploadHandler(ctx echo.Context) error { // Source
-	fileHeader, err := ctx.FormFile("file")
+	logger := logging.FromContext(ctx.Request().Context())
+
+	reader, err := ctx.Request().MultipartReader()
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, err)
+		return echo.NewHTTPError(http.StatusBadRequest, "Expecting multipart/form-data request: "+err.Error())
 	}
 
-	//hostname, err := os.Hostname()
-	//if err != nil {
-	//	return ctx.JSON(http.StatusBadRequest, err)
-	//}
-	proto := e.config.GetString("server.proto")
-	host := e.config.GetString("server.address")
-	filePath := fmt.Sprintf("%s://%s/download?file=%s", proto, host, url.QueryEscape(fileHeader.Filename))
-	go e.s.UploadFile(fileHeader, filePath)
-
-	u := &structs.Response{
-		FilePath: filePath,
-		Result:   fmt.Sprintf("Запущена загрузка файла: %s. Для проверки статуса: GET /status?file=<file name>", fileHeader.Filename),
+	// все настройки из одного снимка конфигурации, перечитывание посреди запроса на него не влияет
+	conf := config.GetConfig()
+
+	var results []structs.Response
+	failed, storageFailed := 0, 0
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Error reading multipart body: "+err.Error())
+		}
+		// обычные поля формы пропускаем, грузим только файлы
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		header := &structs.UploadHeader{
+			Filename:    part.FileName(),
+			Size:        structs.UnknownSize,
+			ContentType: part.Header.Get(echo.HeaderContentType),
+		}
+		filePath := fmt.Sprintf("%s://%s/download?file=%s", conf.Server.Proto, conf.Server.Address, url.QueryEscape(header.Filename))
+		res := structs.Response{FileName: header.Filename, FilePath: filePath, Result: "READY"}
+
+		if err = ValidateHeader(header, conf.Upload.MaxSize); err == nil {
+			finished := metrics.StartUploadSession(metrics.ProtocolHTTP)
+			_, err = e.s.UploadFileStream(logging.WithFields(ctx.Request().Context(), logrus.Fields{logging.FieldFile: header.Filename}), header, filePath, part)
+			finished()
+			if err != nil && !clientError(err) {
+				storageFailed++
+			}
+		}
+		if err != nil {
+			logger.Error(fmt.Sprintf(">> UploadHandler > file %s upload failed: %v", header.Filename, err))
+			res.Result = "FAILED: " + err.Error()
+			failed++
+		}
+		part.Close()
+		results = append(results, res)
+	}
+
+	if len(results) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Please provide at least one file")
 	}
-	return ctx.JSON(http.StatusOK, u)
+
+	status := http.StatusOK
+	switch {
+	case storageFailed > 0 && failed == len(results):
+		status = http.StatusInternalServerError
+	case failed == len(results):
+		status = http.StatusBadRequest
+	case failed > 0:
+		status = http.StatusMultiStatus
+	}
+	return ctx.JSON(status, results)
+}
+
+// clientError ошибки загрузки по вине клиента: тело длиннее заявленного или лимита, оборвалось,
+// загрузка отменена или клиент отключился
+func clientError(err error) bool {
+	return errors.Is(err, minio.ErrSizeExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, minio.ErrUploadCanceled) || errors.Is(err, context.Canceled)
 }
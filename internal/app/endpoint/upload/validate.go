@@ -0,0 +1,43 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	minio "demo-storage/internal/app/service"
+	"demo-storage/internal/app/structs"
+)
+
+var (
+	ErrEmptyFilename   = errors.New("filename cannot be empty")
+	ErrInvalidFilename = errors.New("filename must be a relative path without '..' segments")
+	ErrEmptyFile       = errors.New("upload file is empty")
+	ErrNotArchive      = errors.New("only zip, tar and tar.gz archives can be extracted")
+)
+
+// ValidateHeader общие для websocket и HTTP загрузки проверки заголовка файла.
+// Size == structs.UnknownSize допускается, если размер станет известен только по ходу загрузки
+func ValidateHeader(header *structs.UploadHeader, maxSize int) error {
+	if len(header.Filename) == 0 {
+		return ErrEmptyFilename
+	}
+	if strings.HasPrefix(header.Filename, "/") || strings.Contains(header.Filename, "\\") ||
+		path.Clean(header.Filename) != header.Filename || strings.HasPrefix(header.Filename, "../") || header.Filename == ".." ||
+		strings.HasPrefix(header.Filename, minio.TusTailPrefix) {
+		return ErrInvalidFilename
+	}
+
+	if header.Size == 0 {
+		return ErrEmptyFile
+	}
+	if header.Size < 0 && header.Size != structs.UnknownSize || maxSize > 0 && header.Size > maxSize {
+		return fmt.Errorf("invalid upload size %d, maximum is %d bytes", header.Size, maxSize)
+	}
+
+	if header.Extract && !minio.IsArchive(header.Filename) {
+		return ErrNotArchive
+	}
+	return nil
+}
@@ -1,20 +1,44 @@
 package interfaces
 
 import (
+	"context"
+	"io"
 	"mime/multipart"
+	"time"
 
 	"demo-storage/internal/app/structs"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 type MinioService interface {
-	CreateMultipartSession(name string) (*s3.S3, *s3.CreateMultipartUploadOutput, error)
-	UploadPartToS3(s3connection *s3.S3, multipartSession *s3.CreateMultipartUploadOutput, fileBytes []byte, partNum int) structs.PartUploadResult
-	CompleteMultipartUpload(s3connection *s3.S3, uploadSession *s3.CreateMultipartUploadOutput, completedParts []*s3.CompletedPart) error
-	AbortMultipartUpload(s3connection *s3.S3, uploadSession *s3.CreateMultipartUploadOutput) error
-	UploadFileAsBytes(fileHeader *structs.UploadHeader, data []byte) *s3.PutObjectOutput
-	UploadFile(fileHeader *multipart.FileHeader, filePath string) *s3.PutObjectOutput
-	DownloadFile(fileName string) *s3.GetObjectOutput
-	ListBuckets() []*s3.Bucket
-	ListObjects(bucket string) *s3.ListObjectsV2Output
+	CreateMultipartSession(ctx context.Context, name string, size int, codec string) (*s3.S3, *s3.CreateMultipartUploadOutput, error)
+	UploadPartToS3(ctx context.Context, s3connection *s3.S3, multipartSession *s3.CreateMultipartUploadOutput, fileBytes []byte, partNum int) structs.PartUploadResult
+	CompleteMultipartUpload(ctx context.Context, s3connection *s3.S3, uploadSession *s3.CreateMultipartUploadOutput, completedParts []*s3.CompletedPart) error
+	AbortMultipartUpload(ctx context.Context, s3connection *s3.S3, uploadSession *s3.CreateMultipartUploadOutput, status structs.FileStatus, reason string) error
+	MultipartCodec(fileHeader *structs.UploadHeader) string
+	UploadFileStream(ctx context.Context, fileHeader *structs.UploadHeader, filePath string, body io.Reader) (*s3manager.UploadOutput, error)
+	UploadFile(ctx context.Context, fileHeader *multipart.FileHeader, filePath string) (*s3manager.UploadOutput, error)
+	DownloadFile(ctx context.Context, fileName string) (*s3.GetObjectOutput, error)
+	ListBuckets(ctx context.Context) []*s3.Bucket
+	PingBucket(ctx context.Context) error
+	ListObjects(ctx context.Context, bucket string) *s3.ListObjectsV2Output
+	ListObjectKeys(ctx context.Context, prefix string) ([]string, error)
+	ExtractArchive(ctx context.Context, key string, prefix string) ([]string, error)
+	Reconcile(ctx context.Context, fix bool) (*structs.ReconcileReport, error)
+	CreateTusUpload(ctx context.Context, name string, length int64, partSize int64, metadata string, expiresAt time.Time) (*structs.TusUpload, error)
+	SuspendMultipartUpload(ctx context.Context, uploadSession *s3.CreateMultipartUploadOutput, length int64, partSize int64, parts int, metadata string, expiresAt time.Time) (*structs.TusUpload, error)
+	FindTusUpload(ctx context.Context, id string) (*structs.TusUpload, error)
+	LockTusUpload(ctx context.Context, id string) (unlock func(), err error)
+	WriteTusUpload(ctx context.Context, u *structs.TusUpload, body io.Reader, checksum *structs.TusChecksum) (int64, error)
+	TerminateTusUpload(ctx context.Context, u *structs.TusUpload, status structs.FileStatus, reason string) error
+	Bucket() string
+	HeadObject(ctx context.Context, key string) (*s3.HeadObjectOutput, error)
+	GetObject(ctx context.Context, key string, byteRange string) (*s3.GetObjectOutput, error)
+	DeleteObject(ctx context.Context, key string, reason string) error
+	ListObjectsPage(ctx context.Context, input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error)
+	MultipartSession(key string, uploadId string) (*s3.S3, *s3.CreateMultipartUploadOutput)
+	UploadedPartSize(ctx context.Context, key string, uploadId string, partNum int) (int64, error)
+	CreateDirectory(ctx context.Context, key string) error
+	CopyObject(ctx context.Context, src string, dst string) error
 }
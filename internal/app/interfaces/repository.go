@@ -1,13 +1,16 @@
 package interfaces
 
 import (
+	"context"
 	"database/sql"
 
 	"demo-storage/internal/app/structs"
 )
 
 type UserRepository interface {
-	FindFileByName(name string) *structs.File
-	CreateFile(name string, filePath string) sql.Result
-	UpdateFileStatus(name string, status string) sql.Result
+	FindFileByName(ctx context.Context, name string) *structs.File
+	CreateFile(ctx context.Context, name string, filePath string) sql.Result
+	FindTransitions(ctx context.Context, fileId int) []structs.FileTransition
+	StartUpload(ctx context.Context, name string, filePath string, size int64) error
+	Transition(ctx context.Context, name string, to structs.FileStatus, reason string) error
 }
@@ -0,0 +1,131 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	minio "demo-storage/internal/app/service"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	runsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_janitor_runs_total",
+		Help: "Number of stale upload cleanup runs",
+	}, []string{"result"})
+	abortedUploadsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "storage_janitor_aborted_uploads_total",
+		Help: "Number of aborted stale multipart uploads",
+	})
+	failedFilesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "storage_janitor_failed_files_total",
+		Help: "Number of files rows moved to FAILED by the janitor",
+	})
+	expiredTusUploadsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "storage_janitor_expired_tus_uploads_total",
+		Help: "Number of expired tus uploads removed by the janitor",
+	})
+)
+
+const staleReason = "upload abandoned, cleaned up by janitor"
+
+// Janitor периодически прерывает брошенные multipart загрузки (например, после обрыва websocket)
+// и переводит зависшие записи files в FAILED
+type Janitor struct {
+	s        *minio.MinioService
+	enabled  bool
+	interval time.Duration
+	maxAge   time.Duration
+	// ctx отменяется в Stop и прерывает идущий проход очистки
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New(conf config.Janitor, s *minio.MinioService) *Janitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Janitor{
+		s:        s,
+		enabled:  conf.Enabled,
+		interval: conf.Interval,
+		maxAge:   conf.MaxAge,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (j *Janitor) Start() {
+	if !j.enabled {
+		return
+	}
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.Clean(j.ctx)
+			case <-j.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (j *Janitor) Stop() {
+	if !j.enabled {
+		return
+	}
+	j.cancel()
+	j.wg.Wait()
+}
+
+// Clean выполняет один проход очистки
+func (j *Janitor) Clean(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	olderThan := time.Now().Add(-j.maxAge)
+
+	// tus загрузки живут до своего Upload-Expires, а не до janitor.max-age
+	expired, err := j.s.ExpireTusUploads(ctx, time.Now())
+	if err != nil {
+		logger.Error(fmt.Sprintf(">> Janitor > unable to expire tus uploads: %v", err))
+	}
+	expiredTusUploadsTotal.Add(float64(expired))
+
+	uploads, err := j.s.ListStaleMultipartUploads(ctx, olderThan)
+	if err != nil {
+		logger.Error(fmt.Sprintf(">> Janitor > unable to list multipart uploads: %v", err))
+		runsTotal.WithLabelValues("error").Inc()
+		return
+	}
+
+	var aborted int
+	for _, upload := range uploads {
+		err = j.s.AbortStaleMultipartUpload(ctx, upload, olderThan, staleReason)
+		if err != nil {
+			logger.Error(fmt.Sprintf(">> Janitor > unable to abort upload %s of %s: %v",
+				aws.StringValue(upload.UploadId), aws.StringValue(upload.Key), err))
+			continue
+		}
+		logger.Info(fmt.Sprintf(">> Janitor > aborted stale multipart upload %s of %s, initiated %s",
+			aws.StringValue(upload.UploadId), aws.StringValue(upload.Key), aws.TimeValue(upload.Initiated).Format(time.RFC3339)))
+		aborted++
+	}
+	abortedUploadsTotal.Add(float64(aborted))
+
+	failed := j.s.FailStaleFiles(ctx, olderThan, staleReason)
+	failedFilesTotal.Add(float64(failed))
+
+	logger.Info(fmt.Sprintf(">> Janitor > cleanup done: %d multipart uploads aborted, %d tus uploads expired, %d files marked FAILED",
+		aborted, expired, failed))
+	runsTotal.WithLabelValues("ok").Inc()
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Бизнес метрики хранилища. HTTP метрики собирает echoprometheus, но websocket загрузка
+// для него - один долгий запрос, поэтому объем, сессии и задержки хранилища считаются здесь
+
+// Протоколы загрузки для метки protocol
+const (
+	ProtocolWebSocket = "websocket"
+	ProtocolHTTP      = "http"
+	ProtocolTus       = "tus"
+	ProtocolS3        = "s3"
+	ProtocolWebDAV    = "webdav"
+)
+
+var (
+	uploadedBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_uploaded_bytes_total",
+		Help: "Number of file bytes stored in the bucket",
+	}, []string{"bucket"})
+	downloadedBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_downloaded_bytes_total",
+		Help: "Number of object bytes read from the bucket for clients",
+	}, []string{"bucket"})
+	activeUploadSessions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "storage_active_upload_sessions",
+		Help: "Number of uploads in progress",
+	}, []string{"protocol"})
+	partUploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_part_upload_duration_seconds",
+		Help:    "Duration of a single multipart part upload attempt",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"result"})
+	partUploadRetriesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "storage_part_upload_retries_total",
+		Help: "Number of retried multipart part uploads",
+	})
+	multipartAbortsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_multipart_aborts_total",
+		Help: "Number of aborted multipart uploads",
+	}, []string{"reason"})
+	s3RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_s3_request_duration_seconds",
+		Help:    "Duration of S3 API calls including retries",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_db_query_duration_seconds",
+		Help:    "Duration of repository queries and transactions",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"query"})
+)
+
+// Причины прерывания multipart загрузки для метки reason
+const (
+	AbortCancelled = "cancelled"
+	AbortFailed    = "failed"
+	AbortStale     = "stale"
+	AbortExpired   = "expired"
+)
+
+func AddUploadedBytes(bucket string, n int) {
+	uploadedBytesTotal.WithLabelValues(bucket).Add(float64(n))
+}
+
+// CountDownload считает байты, которые клиент прочитает из body
+func CountDownload(bucket string, body io.ReadCloser) io.ReadCloser {
+	return &countingReader{ReadCloser: body, counter: downloadedBytesTotal.WithLabelValues(bucket)}
+}
+
+type countingReader struct {
+	io.ReadCloser
+	counter prometheus.Counter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.Add(float64(n))
+	return n, err
+}
+
+// StartUploadSession учитывает загрузку в активных до вызова возвращенной функции
+func StartUploadSession(protocol string) func() {
+	gauge := activeUploadSessions.WithLabelValues(protocol)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+func ObservePartUpload(started time.Time, err error) {
+	partUploadDuration.WithLabelValues(result(err)).Observe(time.Since(started).Seconds())
+}
+
+func PartUploadRetried() {
+	partUploadRetriesTotal.Inc()
+}
+
+func MultipartAborted(reason string) {
+	multipartAbortsTotal.WithLabelValues(reason).Inc()
+}
+
+func ObserveS3Request(operation string, started time.Time, err error) {
+	s3RequestDuration.WithLabelValues(operation, result(err)).Observe(time.Since(started).Seconds())
+}
+
+// ObserveQuery замеряет запрос к базе: defer metrics.ObserveQuery("find_file")()
+func ObserveQuery(query string) func() {
+	started := time.Now()
+	return func() {
+		dbQueryDuration.WithLabelValues(query).Observe(time.Since(started).Seconds())
+	}
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
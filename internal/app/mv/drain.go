@@ -0,0 +1,45 @@
+package mv
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"demo-storage/internal/pkg/drain"
+	"github.com/labstack/echo/v4"
+)
+
+// Drain учитывает загрузки (POST, PUT, PATCH) в d: во время остановки новые отклоняются с 503,
+// активные прерываются, если не успели до дедлайна. Прерывание отменяет контекст запроса
+// с причиной drain.ErrInterrupted и обрывает чтение тела
+func Drain(d *drain.Drainer) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			req := ctx.Request()
+			switch req.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch:
+			default:
+				return next(ctx)
+			}
+
+			end, ok := d.Begin()
+			if !ok {
+				ctx.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(drain.RetryAfterSecs))
+				return echo.NewHTTPError(http.StatusServiceUnavailable, drain.ErrDraining.Error())
+			}
+			defer end()
+
+			reqCtx, cancel := context.WithCancelCause(req.Context())
+			defer cancel(nil)
+			stop := context.AfterFunc(d.Interrupted(), func() {
+				cancel(drain.ErrInterrupted)
+				_ = http.NewResponseController(ctx.Response()).SetReadDeadline(time.Now())
+			})
+			defer stop()
+
+			ctx.SetRequest(req.WithContext(reqCtx))
+			return next(ctx)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package mv
+
+import (
+	"errors"
+	"net/http"
+
+	"demo-storage/internal/pkg/logging"
+	"github.com/labstack/echo/v4"
+)
+
+// ErrorResponse тело ответа с ошибкой. По requestId поддержка находит запрос в логах
+type ErrorResponse struct {
+	Message   any    `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// ErrorHandler отвечает на ошибки обработчиков как echo по умолчанию, добавляя идентификатор запроса
+func ErrorHandler(err error, ctx echo.Context) {
+	if ctx.Response().Committed {
+		return
+	}
+
+	he := &echo.HTTPError{Code: http.StatusInternalServerError, Message: http.StatusText(http.StatusInternalServerError)}
+	errors.As(err, &he)
+	if inner, ok := he.Internal.(*echo.HTTPError); ok {
+		he = inner
+	}
+
+	if ctx.Request().Method == http.MethodHead {
+		err = ctx.NoContent(he.Code)
+	} else {
+		err = ctx.JSON(he.Code, ErrorResponse{Message: he.Message, RequestID: logging.RequestID(ctx.Request().Context())})
+	}
+	if err != nil {
+		ctx.Logger().Error(err)
+	}
+}
@@ -5,20 +5,19 @@ import (
 
 	jwtservice "demo-storage/internal/app/security"
 	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
-	"github.com/gurkankaymak/hocon"
 	"github.com/labstack/echo/v4"
 )
 
 const AUTHORIZATION = "Authorization"
 
-func HeaderCheck(config *hocon.Config) echo.MiddlewareFunc {
+func HeaderCheck() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(ctx echo.Context) error {
 			logger := logdoc.GetLogger()
 			logger.Debug("Header Check Middleware executed")
 
 			token := ctx.Request().Header.Get(AUTHORIZATION)
-			isValid, err := jwtservice.ValidateToken(token, config)
+			isValid, err := jwtservice.ValidateToken(token)
 			if err != nil {
 				return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 			}
@@ -0,0 +1,46 @@
+package mv
+
+import (
+	"slices"
+	"sync"
+
+	"demo-storage/internal/config"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+)
+
+// RateLimit ограничивает число запросов с одного IP по действующей конфигурации: ratelimit.rate в секунду,
+// ratelimit.burst одновременно. После перечитывания конфигурации с другими лимитами счетчики начинаются заново
+func RateLimit() echo.MiddlewareFunc {
+	return middleware.RateLimiter(&rateLimitStore{})
+}
+
+// rateLimitStore пересоздает хранилище echo при смене лимитов: у него нельзя поменять лимиты на ходу
+type rateLimitStore struct {
+	mu    sync.Mutex
+	rate  float64
+	burst int
+	store *middleware.RateLimiterMemoryStore
+}
+
+func (s *rateLimitStore) Allow(identifier string) (bool, error) {
+	cur := config.GetConfig().RateLimit
+	s.mu.Lock()
+	if s.store == nil || s.rate != cur.Rate || s.burst != cur.Burst {
+		s.store = middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:  rate.Limit(cur.Rate),
+			Burst: cur.Burst,
+		})
+		s.rate, s.burst = cur.Rate, cur.Burst
+	}
+	store := s.store
+	s.mu.Unlock()
+	return store.Allow(identifier)
+}
+
+// AllowOrigin проверяет Origin запроса CORS по cors.allow-origins действующей конфигурации
+func AllowOrigin(origin string) (bool, error) {
+	origins := config.GetConfig().CORS.AllowOrigins
+	return slices.Contains(origins, "*") || slices.Contains(origins, origin), nil
+}
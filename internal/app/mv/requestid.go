@@ -0,0 +1,51 @@
+package mv
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"demo-storage/internal/pkg/logging"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const maxRequestIDLength = 128
+
+// RequestID присваивает запросу идентификатор: X-Request-ID клиента или прокси, если он похож на идентификатор,
+// иначе новый. Идентификатор возвращается в ответе, попадает в поля логов контекста запроса и в спан
+func RequestID() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			req := ctx.Request()
+			id := req.Header.Get(echo.HeaderXRequestID)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			ctx.Response().Header().Set(echo.HeaderXRequestID, id)
+			trace.SpanFromContext(req.Context()).SetAttributes(attribute.String("request.id", id))
+			ctx.SetRequest(req.WithContext(logging.WithFields(req.Context(), logrus.Fields{logging.FieldRequestID: id})))
+			return next(ctx)
+		}
+	}
+}
+
+// validRequestID чужой идентификатор пишется в логи и заголовки как есть, поэтому только печатный ASCII без пробелов
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
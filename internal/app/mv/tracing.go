@@ -0,0 +1,59 @@
+package mv
+
+import (
+	"errors"
+	"net/http"
+
+	"demo-storage/internal/pkg/tracing"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing открывает серверный спан на каждый HTTP запрос, продолжая трассировку из заголовков клиента.
+// Контекст запроса обработчика содержит спан, websocket загрузка целиком идет внутри спана подключения
+func Tracing() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			req := ctx.Request()
+			route := ctx.Path()
+			if route == "" {
+				route = req.URL.Path
+			}
+
+			parent := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+			spanCtx, span := tracing.Tracer().Start(parent, req.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(req.Method),
+					semconv.HTTPRoute(route),
+					semconv.URLPath(req.URL.Path),
+					semconv.ClientAddress(ctx.RealIP()),
+					semconv.UserAgentOriginal(req.UserAgent()),
+				))
+			defer span.End()
+			ctx.SetRequest(req.WithContext(spanCtx))
+
+			err := next(ctx)
+
+			status := ctx.Response().Status
+			if err != nil {
+				var he *echo.HTTPError
+				if errors.As(err, &he) {
+					status = he.Code
+				} else {
+					status = http.StatusInternalServerError
+				}
+				span.RecordError(err)
+			}
+			span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			return err
+		}
+	}
+}
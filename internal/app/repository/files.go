@@ -1,30 +1,46 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"time"
 
 	"demo-storage/internal/app/structs"
-	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
+	"demo-storage/internal/pkg/logging"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrFileNotFound      = errors.New("file not found")
+	ErrFileExists        = errors.New("file already exists")
+	ErrInvalidTransition = errors.New("invalid file status transition")
 )
 
 type FileRepository struct {
-	DB *sqlx.DB
+	DB      *sqlx.DB
+	timeout time.Duration
 }
 
-func New(db *sqlx.DB) *FileRepository {
-	return &FileRepository{DB: db}
+// New создает репозиторий файлов. timeout предел одного запроса или транзакции, 0 - без ограничения
+func New(db *sqlx.DB, timeout time.Duration) *FileRepository {
+	return &FileRepository{DB: db, timeout: timeout}
 }
 
-func (r *FileRepository) FindFileByName(name string) *structs.File {
-	logger := logdoc.GetLogger()
+func (r *FileRepository) FindFileByName(ctx context.Context, name string) *structs.File {
+	ctx, done := observe(ctx, r.timeout, "find_file_by_name")
+	defer done()
+	logger := logging.FromContext(ctx)
 
 	params := map[string]interface{}{"name": name}
-	rows, err := r.DB.NamedQuery(`SELECT * FROM files where file_name = :name`, params)
+	rows, err := r.DB.NamedQueryContext(ctx, `SELECT * FROM files where file_name = :name`, params)
 	if err != nil {
 		logger.Error("FindFileByName prepare query error")
 		return nil
 	}
+	defer rows.Close()
 	var file structs.File
 	for rows.Next() {
 		err = rows.StructScan(&file)
@@ -36,55 +52,340 @@ func (r *FileRepository) FindFileByName(name string) *structs.File {
 	return &file
 }
 
-func (r *FileRepository) CreateFile(name string, filePath string) sql.Result {
-	logger := logdoc.GetLogger()
+func (r *FileRepository) FindTransitions(ctx context.Context, fileId int) []structs.FileTransition {
+	ctx, done := observe(ctx, r.timeout, "find_transitions")
+	defer done()
+	logger := logging.FromContext(ctx)
+
+	var res []structs.FileTransition
+	err := r.DB.SelectContext(ctx, &res, `SELECT * FROM file_transitions where file_id = $1 order by id`, fileId)
+	if err != nil {
+		logger.Error("FindTransitions query error")
+		return nil
+	}
+	return res
+}
+
+// FindStaleFiles возвращает файлы в незавершенных состояниях, не обновлявшиеся с olderThan
+func (r *FileRepository) FindStaleFiles(ctx context.Context, olderThan time.Time) []structs.File {
+	ctx, done := observe(ctx, r.timeout, "find_stale_files")
+	defer done()
+	logger := logging.FromContext(ctx)
 
-	params := map[string]interface{}{"name": name, "status": "UPLOADING", "link": filePath}
-	nstmt, err := r.DB.PrepareNamed(`INSERT INTO files(file_name, upload_status, storage_link) values (:name,:status,:link)`)
+	var res []structs.File
+	err := r.DB.SelectContext(ctx, &res, `SELECT * FROM files where upload_status = any($1) and updated_at < $2`,
+		pq.StringArray{
+			string(structs.StatusPending),
+			string(structs.StatusUploading),
+			string(structs.StatusAssembling),
+			string(structs.StatusProcessing),
+		}, olderThan)
 	if err != nil {
-		logger.Error("CreateFile prepare error")
+		logger.Error("FindStaleFiles query error")
 		return nil
 	}
+	return res
+}
+
+// FindFilesPage возвращает до limit файлов после (afterName, afterId) в порядке байтов имени,
+// в котором S3 отдает ключи листинга. Так сверка идет по обеим сторонам страницами
+func (r *FileRepository) FindFilesPage(ctx context.Context, afterName string, afterId int, limit int) ([]structs.File, error) {
+	ctx, done := observe(ctx, r.timeout, "find_files_page")
+	defer done()
 
-	res, err := nstmt.Exec(params)
+	var res []structs.File
+	err := r.DB.SelectContext(ctx, &res, `SELECT * FROM files where (file_name collate "C", id) > ($1, $2)
+		order by file_name collate "C", id limit $3`, afterName, afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (r *FileRepository) CreateFile(ctx context.Context, name string, filePath string) sql.Result {
+	ctx, done := observe(ctx, r.timeout, "create_file")
+	defer done()
+	logger := logging.FromContext(ctx)
+
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		logger.Error("CreateFile begin error")
+		return nil
+	}
+	defer tx.Rollback()
+
+	var id int
+	res, err := r.insertFile(ctx, tx, name, filePath, &id)
+	if errors.Is(err, sql.ErrNoRows) {
+		logger.Warn(fmt.Sprintf("CreateFile %s: %v", name, ErrFileExists))
+		return nil
+	}
 	if err != nil {
 		logger.Error("CreateFile exec error")
 		return nil
 	}
 
+	if err = tx.Commit(); err != nil {
+		logger.Error("CreateFile commit error")
+		return nil
+	}
+
 	return res
 }
 
-func (r *FileRepository) UpdateFileStatus(name string, status string) sql.Result {
-	logger := logdoc.GetLogger()
+// insertFile создает запись в PENDING. Если файл с таким именем уже есть, возвращает sql.ErrNoRows
+func (r *FileRepository) insertFile(ctx context.Context, tx *sqlx.Tx, name string, filePath string, id *int) (sql.Result, error) {
+	err := tx.GetContext(ctx, id, `INSERT INTO files(file_name, upload_status, storage_link) values ($1, $2, $3)
+		on conflict (file_name) do nothing returning id`, name, structs.StatusPending, filePath)
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO file_transitions(file_id, to_status) values ($1, $2)`, *id, structs.StatusPending)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
 
-	params := map[string]interface{}{"name": name, "status": status}
-	nstmt, err := r.DB.PrepareNamed(`update files set upload_status=:status where file_name = :name`)
+// StartUpload создает запись о файле при необходимости и переводит ее в UPLOADING,
+// сбрасывая прогресс и причину прошлой ошибки. Все в одной транзакции
+func (r *FileRepository) StartUpload(ctx context.Context, name string, filePath string, size int64) error {
+	ctx, done := observe(ctx, r.timeout, "start_upload")
+	defer done()
+	logger := logging.FromContext(ctx)
+
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	file, err := r.lockOrCreateFile(ctx, tx, name, filePath)
+	if err != nil {
+		logger.Error("StartUpload query error")
+		return err
+	}
+
+	if err = r.transition(ctx, tx, file, structs.StatusUploading, ""); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update files set bytes_total=$1, bytes_uploaded=0, storage_link=$2 where id = $3`, size, filePath, file.Id)
+	if err != nil {
+		logger.Error("StartUpload exec error")
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ImportFile регистрирует уже существующий в бакете объект сразу в состоянии READY
+func (r *FileRepository) ImportFile(ctx context.Context, name string, size int64, etag string, reason string) error {
+	ctx, done := observe(ctx, r.timeout, "import_file")
+	defer done()
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.GetContext(ctx, &id, `INSERT INTO files(file_name, upload_status, storage_link, bytes_total, bytes_uploaded, etag)
+		values ($1, $2, '', $3, $3, $4) on conflict (file_name) do nothing returning id`, name, structs.StatusReady, size, etag)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrFileExists
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO file_transitions(file_id, to_status, reason) values ($1, $2, $3)`, id, structs.StatusReady, reason)
 	if err != nil {
-		logger.Error("UpdateFileStatus prepare error")
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Transition переводит файл в состояние to, если такой переход разрешен.
+// reason сохраняется в истории переходов и, для FAILED, в error_reason файла
+func (r *FileRepository) Transition(ctx context.Context, name string, to structs.FileStatus, reason string) error {
+	ctx, done := observe(ctx, r.timeout, "transition")
+	defer done()
+	logger := logging.FromContext(ctx)
+
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	file, err := r.lockFile(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+
+	if err = r.transition(ctx, tx, file, to, reason); err != nil {
+		logger.Warn(fmt.Sprintf("Transition %s: %v", name, err))
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockOrCreateFile блокирует запись файла, создавая ее при необходимости. Если запись одновременно
+// создал другой запрос, вставка ждет его транзакцию и ничего не делает, тогда блокируем его запись
+func (r *FileRepository) lockOrCreateFile(ctx context.Context, tx *sqlx.Tx, name string, filePath string) (*structs.File, error) {
+	file, err := r.lockFile(ctx, tx, name)
+	if !errors.Is(err, ErrFileNotFound) {
+		return file, err
+	}
+	file = &structs.File{Name: name, UploadStatus: structs.StatusPending}
+	_, err = r.insertFile(ctx, tx, name, filePath, &file.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r.lockFile(ctx, tx, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (r *FileRepository) lockFile(ctx context.Context, tx *sqlx.Tx, name string) (*structs.File, error) {
+	var file structs.File
+	err := tx.GetContext(ctx, &file, `SELECT * FROM files where file_name = $1 for update`, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (r *FileRepository) transition(ctx context.Context, tx *sqlx.Tx, file *structs.File, to structs.FileStatus, reason string) error {
+	from := file.UploadStatus
+	if !from.CanTransition(to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+
+	errorReason := sql.NullString{String: reason, Valid: to == structs.StatusFailed && reason != ""}
+	_, err := tx.ExecContext(ctx, `update files set upload_status=$1, error_reason=$2, updated_at=now() where id = $3`, to, errorReason, file.Id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO file_transitions(file_id, from_status, to_status, reason) values ($1, $2, $3, $4)`,
+		file.Id, from, to, sql.NullString{String: reason, Valid: reason != ""})
+	if err != nil {
+		return err
+	}
+
+	file.UploadStatus = to
+	return nil
+}
+
+// AddUploadedBytes увеличивает счетчик загруженных байт файла
+func (r *FileRepository) AddUploadedBytes(ctx context.Context, name string, n int) sql.Result {
+	ctx, done := observe(ctx, r.timeout, "add_uploaded_bytes")
+	defer done()
+	logger := logging.FromContext(ctx)
+
+	res, err := r.DB.ExecContext(ctx, `update files set bytes_uploaded = bytes_uploaded + $1, updated_at=now() where file_name = $2`, n, name)
+	if err != nil {
+		logger.Error("AddUploadedBytes exec error")
 		return nil
 	}
 
-	res, err := nstmt.Exec(params)
+	return res
+}
+
+// SetFileOwner закрепляет файл за владельцем ключа S3 шлюза
+func (r *FileRepository) SetFileOwner(ctx context.Context, name string, owner string) error {
+	ctx, done := observe(ctx, r.timeout, "set_file_owner")
+	defer done()
+	_, err := r.DB.ExecContext(ctx, `update files set owner = $1 where file_name = $2`, owner, name)
+	return err
+}
+
+// FindOwners возвращает владельцев файлов из names. Файлы без владельца в результат не попадают
+func (r *FileRepository) FindOwners(ctx context.Context, names []string) (map[string]string, error) {
+	ctx, done := observe(ctx, r.timeout, "find_owners")
+	defer done()
+	var rows []struct {
+		Name  string `db:"file_name"`
+		Owner string `db:"owner"`
+	}
+	err := r.DB.SelectContext(ctx, &rows, `SELECT file_name, owner FROM files where file_name = any($1) and owner is not null`, pq.StringArray(names))
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string, len(rows))
+	for _, row := range rows {
+		res[row.Name] = row.Owner
+	}
+	return res, nil
+}
+
+// FindFiles возвращает записи о файлах из names по имени. Имена без записи в результат не попадают
+func (r *FileRepository) FindFiles(ctx context.Context, names []string) (map[string]structs.File, error) {
+	ctx, done := observe(ctx, r.timeout, "find_files")
+	defer done()
+	var rows []structs.File
+	err := r.DB.SelectContext(ctx, &rows, `SELECT * FROM files where file_name = any($1)`, pq.StringArray(names))
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]structs.File, len(rows))
+	for _, row := range rows {
+		res[row.Name] = row
+	}
+	return res, nil
+}
+
+// OwnerUsage суммарный объем загруженных и загружаемых файлов владельца.
+// У multipart загрузки шлюза размер заранее неизвестен, для нее учитываются уже загруженные байты
+func (r *FileRepository) OwnerUsage(ctx context.Context, owner string) (int64, error) {
+	ctx, done := observe(ctx, r.timeout, "owner_usage")
+	defer done()
+	var usage int64
+	err := r.DB.GetContext(ctx, &usage, `SELECT coalesce(sum(greatest(bytes_total, bytes_uploaded)), 0) FROM files where owner = $1 and upload_status = any($2)`,
+		owner, pq.StringArray{
+			string(structs.StatusUploading),
+			string(structs.StatusAssembling),
+			string(structs.StatusProcessing),
+			string(structs.StatusReady),
+		})
+	return usage, err
+}
+
+// UpdateFileSize фиксирует размер файла, если он стал известен только после загрузки
+func (r *FileRepository) UpdateFileSize(ctx context.Context, name string, size int64) sql.Result {
+	ctx, done := observe(ctx, r.timeout, "update_file_size")
+	defer done()
+	logger := logging.FromContext(ctx)
+
+	res, err := r.DB.ExecContext(ctx, `update files set bytes_total = $1 where file_name = $2`, size, name)
 	if err != nil {
-		logger.Error("UpdateFileStatus exec error")
+		logger.Error("UpdateFileSize exec error")
 		return nil
 	}
 
 	return res
 }
 
-func (r *FileRepository) UpdateFileParams(name string, status string, link string) sql.Result {
-	logger := logdoc.GetLogger()
+func (r *FileRepository) UpdateFileLink(ctx context.Context, name string, link string) sql.Result {
+	ctx, done := observe(ctx, r.timeout, "update_file_link")
+	defer done()
+	logger := logging.FromContext(ctx)
 
-	params := map[string]interface{}{"name": name, "status": status, "link": link}
-	nstmt, err := r.DB.PrepareNamed(`update files set storage_link=:link, upload_status=:status where file_name = :name`)
+	params := map[string]interface{}{"name": name, "link": link}
+	nstmt, err := r.DB.PrepareNamedContext(ctx, `update files set storage_link=:link where file_name = :name`)
 	if err != nil {
 		logger.Error("UpdateFileLink prepare error")
 		return nil
 	}
 
-	res, err := nstmt.Exec(params)
+	res, err := nstmt.ExecContext(ctx, params)
 	if err != nil {
 		logger.Error("UpdateFileLink exec error")
 		return nil
@@ -92,3 +393,24 @@ func (r *FileRepository) UpdateFileParams(name string, status string, link strin
 
 	return res
 }
+
+func (r *FileRepository) UpdateFileCompression(ctx context.Context, name string, codec string, originalSize int) sql.Result {
+	ctx, done := observe(ctx, r.timeout, "update_file_compression")
+	defer done()
+	logger := logging.FromContext(ctx)
+
+	params := map[string]interface{}{"name": name, "codec": codec, "size": originalSize}
+	nstmt, err := r.DB.PrepareNamedContext(ctx, `update files set codec=:codec, original_size=:size where file_name = :name`)
+	if err != nil {
+		logger.Error("UpdateFileCompression prepare error")
+		return nil
+	}
+
+	res, err := nstmt.ExecContext(ctx, params)
+	if err != nil {
+		logger.Error("UpdateFileCompression exec error")
+		return nil
+	}
+
+	return res
+}
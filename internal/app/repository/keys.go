@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"demo-storage/internal/app/structs"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrKeyNotFound = errors.New("api key not found")
+
+// KeyRepository ключи доступа S3 шлюза
+type KeyRepository struct {
+	DB      *sqlx.DB
+	timeout time.Duration
+}
+
+// timeout предел одного запроса, 0 - без ограничения
+func NewKeyRepository(db *sqlx.DB, timeout time.Duration) *KeyRepository {
+	return &KeyRepository{DB: db, timeout: timeout}
+}
+
+func (r *KeyRepository) FindKey(ctx context.Context, accessKey string) (*structs.APIKey, error) {
+	ctx, done := observe(ctx, r.timeout, "find_key")
+	defer done()
+	var key structs.APIKey
+	err := r.DB.GetContext(ctx, &key, `SELECT * FROM api_keys where access_key = $1`, accessKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *KeyRepository) CreateKey(ctx context.Context, key *structs.APIKey) error {
+	ctx, done := observe(ctx, r.timeout, "create_key")
+	defer done()
+	_, err := r.DB.ExecContext(ctx, `INSERT INTO api_keys(access_key, secret_key, owner, quota_bytes) values ($1, $2, $3, $4)`,
+		key.AccessKey, key.SecretKey, key.Owner, key.QuotaBytes)
+	return err
+}
+
+func (r *KeyRepository) DisableKey(ctx context.Context, accessKey string) error {
+	ctx, done := observe(ctx, r.timeout, "disable_key")
+	defer done()
+	res, err := r.DB.ExecContext(ctx, `update api_keys set disabled = true where access_key = $1`, accessKey)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
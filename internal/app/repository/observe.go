@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"demo-storage/internal/app/metrics"
+	"demo-storage/internal/pkg/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// observe ограничивает запрос timeout (0 - без ограничения) и замеряет его метрикой и дочерним спаном ctx, если в ctx есть спан:
+//
+//	ctx, done := observe(ctx, r.timeout, "find_file")
+//	defer done()
+func observe(ctx context.Context, timeout time.Duration, query string) (context.Context, func()) {
+	finished := metrics.ObserveQuery(query)
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	// Спан только дочерний: запрос без родителя (фоновые задачи, утилиты) дал бы отдельную трассу из одного спана
+	var span trace.Span
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		ctx, span = tracing.Tracer().Start(ctx, "db."+query,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationName(query)))
+	}
+	return ctx, func() {
+		if span != nil {
+			span.End()
+		}
+		cancel()
+		finished()
+	}
+}
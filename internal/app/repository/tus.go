@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"demo-storage/internal/app/structs"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrUploadNotFound = errors.New("upload not found")
+	ErrOffsetConflict = errors.New("upload offset has changed")
+	ErrUploadLocked   = errors.New("upload is locked by another request")
+)
+
+// tusLockClass первый ключ pg_advisory_lock блокировок tus загрузок, второй - хэш id загрузки
+const tusLockClass = 7_463_002
+
+// TusRepository хранит смещения resumable загрузок tus
+type TusRepository struct {
+	DB      *sqlx.DB
+	timeout time.Duration
+}
+
+// timeout предел одного запроса, 0 - без ограничения
+func NewTusRepository(db *sqlx.DB, timeout time.Duration) *TusRepository {
+	return &TusRepository{DB: db, timeout: timeout}
+}
+
+func (r *TusRepository) CreateUpload(ctx context.Context, u *structs.TusUpload) error {
+	ctx, done := observe(ctx, r.timeout, "create_upload")
+	defer done()
+	_, err := r.DB.ExecContext(ctx, `INSERT INTO tus_uploads(id, file_name, s3_upload_id, upload_length, part_size, metadata, expires_at)
+		values ($1, $2, $3, $4, $5, $6, $7)`, u.Id, u.Name, u.S3UploadId, u.Length, u.PartSize, u.Metadata, u.ExpiresAt)
+	return err
+}
+
+func (r *TusRepository) FindUpload(ctx context.Context, id string) (*structs.TusUpload, error) {
+	ctx, done := observe(ctx, r.timeout, "find_upload")
+	defer done()
+	var u structs.TusUpload
+	err := r.DB.GetContext(ctx, &u, `SELECT * FROM tus_uploads where id = $1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// LockUpload занимает загрузку id на время PATCH, не дожидаясь ее освобождения: писать части
+// одной загрузки может только один запрос на всех экземплярах сервиса. Блокировка живет в сессии
+// Postgres, поэтому держит отдельное соединение до вызова unlock. Если экземпляр упадет,
+// блокировка снимется вместе с его соединением
+func (r *TusRepository) LockUpload(ctx context.Context, id string) (unlock func(), err error) {
+	lockCtx, done := observe(ctx, r.timeout, "lock_upload")
+	defer done()
+	conn, err := r.DB.Connx(lockCtx)
+	if err != nil {
+		return nil, err
+	}
+	var locked bool
+	if err = conn.GetContext(lockCtx, &locked, `SELECT pg_try_advisory_lock($1, hashtext($2))`, tusLockClass, id); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if !locked {
+		_ = conn.Close()
+		return nil, ErrUploadLocked
+	}
+	return func() {
+		ctx, done := observe(context.WithoutCancel(ctx), r.timeout, "unlock_upload")
+		defer done()
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1, hashtext($2))`, tusLockClass, id); err != nil {
+			// соединение с неснятой блокировкой в пул не возвращаем
+			_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		_ = conn.Close()
+	}, nil
+}
+
+// AdvanceUpload сдвигает смещение загрузки, только если оно не изменилось с момента чтения.
+// Так параллельный PATCH с тем же смещением не перезапишет уже принятые данные
+func (r *TusRepository) AdvanceUpload(ctx context.Context, u *structs.TusUpload, offset int64, parts int) error {
+	ctx, done := observe(ctx, r.timeout, "advance_upload")
+	defer done()
+	res, err := r.DB.ExecContext(ctx, `update tus_uploads set upload_offset=$1, parts=$2, updated_at=now() where id = $3 and upload_offset = $4`,
+		offset, parts, u.Id, u.Offset)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrOffsetConflict
+	}
+	u.Offset, u.Parts = offset, parts
+	return nil
+}
+
+func (r *TusRepository) DeleteUpload(ctx context.Context, id string) error {
+	ctx, done := observe(ctx, r.timeout, "delete_upload")
+	defer done()
+	_, err := r.DB.ExecContext(ctx, `delete from tus_uploads where id = $1`, id)
+	return err
+}
+
+// FindExpiredUploads возвращает загрузки, срок жизни которых истек к моменту now
+func (r *TusRepository) FindExpiredUploads(ctx context.Context, now time.Time) ([]structs.TusUpload, error) {
+	ctx, done := observe(ctx, r.timeout, "find_expired_uploads")
+	defer done()
+	var res []structs.TusUpload
+	err := r.DB.SelectContext(ctx, &res, `SELECT * FROM tus_uploads where expires_at < $1`, now)
+	return res, err
+}
+
+// ActiveS3UploadIds возвращает идентификаторы S3 multipart сессий, принадлежащих tus загрузкам
+func (r *TusRepository) ActiveS3UploadIds(ctx context.Context) (map[string]bool, error) {
+	ctx, done := observe(ctx, r.timeout, "active_s3_upload_ids")
+	defer done()
+	var ids []string
+	if err := r.DB.SelectContext(ctx, &ids, `SELECT s3_upload_id FROM tus_uploads`); err != nil {
+		return nil, err
+	}
+	res := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		res[id] = true
+	}
+	return res, nil
+}
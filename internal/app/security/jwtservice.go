@@ -9,11 +9,13 @@ import (
 	"strings"
 	"time"
 
+	"demo-storage/internal/config"
 	"github.com/golang-jwt/jwt"
-	"github.com/gurkankaymak/hocon"
 )
 
-func ValidateToken(tokenStr string, config *hocon.Config) (bool, error) {
+// ValidateToken проверяет подпись, срок, издателя и аудиторию токена. Издатель и аудитория
+// берутся из действующей конфигурации, поэтому меняются перечитыванием конфигурации
+func ValidateToken(tokenStr string) (bool, error) {
 	publicKey := ReadPublicPEMKey()
 
 	// проверка токена
@@ -36,11 +38,11 @@ func ValidateToken(tokenStr string, config *hocon.Config) (bool, error) {
 		return false, fmt.Errorf("token expired")
 	}
 
-	if !claims.VerifyIssuer(config.GetString("jwt.issuer"), true) {
+	if !claims.VerifyIssuer(config.GetConfig().JWT.Issuer, true) {
 		return false, fmt.Errorf("token issuer error")
 	}
 
-	if !claims.VerifyAudience(config.GetString("jwt.audience"), true) {
+	if !claims.VerifyAudience(config.GetConfig().JWT.Audience, true) {
 		return false, fmt.Errorf("token audience error")
 	}
 
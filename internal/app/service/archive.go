@@ -0,0 +1,272 @@
+package minio
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/pkg/compress"
+	"demo-storage/internal/pkg/logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+var (
+	ErrUnsupportedArchive = errors.New("unsupported archive format")
+	ErrUnsafeEntryName    = errors.New("unsafe archive entry name")
+	ErrTooManyEntries     = errors.New("archive entry count limit exceeded")
+	ErrEntryTooLarge      = errors.New("archive entry size limit exceeded")
+	ErrArchiveTooLarge    = errors.New("archive total size limit exceeded")
+)
+
+// IsArchive проверяет по имени файла, умеем ли мы распаковывать такой архив
+func IsArchive(name string) bool {
+	return archiveFormat(name) != ""
+}
+
+func archiveFormat(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tgz"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	default:
+		return ""
+	}
+}
+
+// ArchivePrefix возвращает префикс по умолчанию для распаковки архива: имя архива без расширения
+func ArchivePrefix(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			return name[:len(name)-len(ext)] + "/"
+		}
+	}
+	return name + "/"
+}
+
+// safeEntryName нормализует имя записи архива и защищает от zip-slip:
+// абсолютные пути и выход за пределы префикса через ".." запрещены
+func safeEntryName(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") {
+		return "", ErrUnsafeEntryName
+	}
+	cleaned := path.Clean(name)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrUnsafeEntryName
+	}
+	return cleaned, nil
+}
+
+type archiveLimits struct {
+	maxEntries   int
+	maxEntrySize int64
+	maxTotalSize int64
+	entries      int
+	total        int64
+}
+
+func (l *archiveLimits) next(size int64) error {
+	l.entries++
+	if l.maxEntries > 0 && l.entries > l.maxEntries {
+		return ErrTooManyEntries
+	}
+	if l.maxEntrySize > 0 && size > l.maxEntrySize {
+		return ErrEntryTooLarge
+	}
+	l.total += size
+	if l.maxTotalSize > 0 && l.total > l.maxTotalSize {
+		return ErrArchiveTooLarge
+	}
+	return nil
+}
+
+// ExtractArchive распаковывает загруженный архив key в отдельные объекты под префиксом prefix
+// и возвращает ключи созданных объектов
+func (s *MinioService) ExtractArchive(ctx context.Context, key string, prefix string) ([]string, error) {
+	logger := logging.FromContext(ctx)
+
+	format := archiveFormat(key)
+	if format == "" {
+		return nil, ErrUnsupportedArchive
+	}
+	if prefix == "" {
+		prefix = ArchivePrefix(key)
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	s.transition(ctx, key, structs.StatusProcessing, "")
+
+	extracted, err := s.extractArchive(ctx, key, prefix, format)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Ошибка распаковки архива %s: %v", key, err))
+		s.transition(ctx, key, structs.StatusFailed, "archive extraction failed: "+err.Error())
+		return extracted, err
+	}
+
+	s.transition(ctx, key, structs.StatusReady, "")
+	logger.Info(fmt.Sprintf("Archive %s extracted to %s, %d entries", key, prefix, len(extracted)))
+	return extracted, nil
+}
+
+func (s *MinioService) extractArchive(ctx context.Context, key string, prefix string, format string) ([]string, error) {
+	logger := logging.FromContext(ctx)
+
+	object, err := s.downloadObject(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("archive %s: %w", key, err)
+	}
+	defer object.Body.Close()
+
+	body, err := compress.NewReader(ObjectCodec(object), object.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	limits := &archiveLimits{
+		maxEntries:   s.conf.Archive.MaxEntries,
+		maxEntrySize: int64(s.conf.Archive.MaxEntrySize),
+		maxTotalSize: int64(s.conf.Archive.MaxTotalSize),
+	}
+	uploader := s3manager.NewUploaderWithClient(s.s3)
+
+	var extracted []string
+	put := func(name string, size int64, r io.Reader) error {
+		entry, err := safeEntryName(name)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, name)
+		}
+		if err = limits.next(size); err != nil {
+			return fmt.Errorf("%w: %s", err, name)
+		}
+
+		objectKey := prefix + entry
+		if err = s.fileRepository.StartUpload(ctx, objectKey, "", size); err != nil {
+			return fmt.Errorf("%s: %w", objectKey, err)
+		}
+		_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(objectKey),
+			// zip и tar ридеры сами не отдают больше заявленного в заголовке размера
+			Body: r,
+		})
+		if err != nil {
+			s.transition(ctx, objectKey, structs.StatusFailed, err.Error())
+			return err
+		}
+
+		s.addUploadedBytes(context.WithoutCancel(ctx), objectKey, int(size))
+		s.transition(ctx, objectKey, structs.StatusReady, "")
+		extracted = append(extracted, objectKey)
+		logger.Debug(fmt.Sprintf(">> ExtractArchive > %s: extracted %s (%d bytes)", key, objectKey, size))
+		return nil
+	}
+
+	switch format {
+	case "zip":
+		err = extractZip(body, put)
+	case "tgz":
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		err = extractTar(gz, put)
+	case "tar":
+		err = extractTar(body, put)
+	}
+	return extracted, err
+}
+
+func extractTar(r io.Reader, put func(name string, size int64, r io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// каталоги, симлинки и прочие спецфайлы пропускаем
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = put(hdr.Name, hdr.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(r io.Reader, put func(name string, size int64, r io.Reader) error) error {
+	// zip требует произвольного доступа, поэтому сохраняем архив во временный файл
+	tmp, err := os.CreateTemp("", "storage-archive-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = put(f.Name, int64(f.UncompressedSize64), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListObjectKeys возвращает ключи всех объектов бакета с указанным префиксом
+func (s *MinioService) ListObjectKeys(ctx context.Context, prefix string) ([]string, error) {
+
+	var keys []string
+	err := s.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,90 @@
+package minio
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"demo-storage/internal/app/metrics"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/tracing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// NewS3 создает клиент S3 по секции minio. Клиент безопасен для параллельного использования,
+// поэтому создается один раз при старте и его пул соединений общий для всех запросов сервиса
+func NewS3(conf config.Minio) (*s3.S3, error) {
+	transport, err := newTransport(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials(conf.AccessKey, conf.SecretKey, ""),
+		DisableSSL:       aws.Bool(!conf.TLS),
+		S3ForcePathStyle: aws.Bool(conf.PathStyle),
+		Endpoint:         aws.String(net.JoinHostPort(conf.Address, conf.Port)),
+		Region:           aws.String(conf.Region),
+		HTTPClient:       &http.Client{Transport: transport},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create S3 session: %w", err)
+	}
+
+	// Спан и длительность каждого вызова S3 с учетом повторов SDK
+	tracing.InstrumentS3(&sess.Handlers)
+	sess.Handlers.Build.PushBack(correlationHeaders)
+	sess.Handlers.Complete.PushBack(func(r *request.Request) {
+		metrics.ObserveS3Request(r.Operation.Name, r.Time, r.Error)
+	})
+
+	return s3.New(sess), nil
+}
+
+// newTransport пул соединений с MinIO по minio.http. По умолчанию http.Transport держит
+// только 2 простаивающих соединения на хост, при параллельной загрузке частей остальные каждый раз открываются заново
+func newTransport(conf config.Minio) (*http.Transport, error) {
+	dialer := &net.Dialer{Timeout: conf.HTTP.DialTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          conf.HTTP.MaxIdleConns,
+		MaxIdleConnsPerHost:   conf.HTTP.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       conf.HTTP.MaxConnsPerHost,
+		IdleConnTimeout:       conf.HTTP.IdleConnTimeout,
+		TLSHandshakeTimeout:   conf.HTTP.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: conf.HTTP.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	if !conf.TLS {
+		return transport, nil
+	}
+
+	tlsConf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if conf.CAFile != "" {
+		pem, err := os.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read minio.ca-file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("minio.ca-file %s: no PEM certificates found", conf.CAFile)
+		}
+		tlsConf.RootCAs = pool
+	}
+	transport.TLSClientConfig = tlsConf
+	return transport, nil
+}
@@ -0,0 +1,245 @@
+package minio
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"demo-storage/internal/app/metrics"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/pkg/compress"
+	"demo-storage/internal/pkg/logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Методы для S3 шлюза и WebDAV: проксируют запросы в бакет хранилища
+
+// Bucket имя бакета хранилища
+func (s *MinioService) Bucket() string {
+	return s.bucket
+}
+
+func (s *MinioService) HeadObject(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.request)
+	defer cancel()
+	return s.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+}
+
+// GetObject читает объект, byteRange - значение заголовка Range или пустая строка.
+// Тело читается в рамках ctx, таймаута у него нет
+func (s *MinioService) GetObject(ctx context.Context, key string, byteRange string) (*s3.GetObjectOutput, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if byteRange != "" {
+		input.Range = aws.String(byteRange)
+	}
+	object, err := s.s3.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	object.Body = metrics.CountDownload(s.bucket, object.Body)
+	return object, nil
+}
+
+// DeleteObject удаляет объект из бакета и переводит запись о файле в DELETED
+func (s *MinioService) DeleteObject(ctx context.Context, key string, reason string) error {
+	reqCtx, cancel := withTimeout(ctx, s.timeouts.request)
+	defer cancel()
+	_, err := s.s3.DeleteObjectWithContext(reqCtx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+
+	if f := s.fileRepository.FindFileByName(ctx, key); f != nil && f.Id != 0 {
+		s.transition(ctx, key, structs.StatusDeleted, reason)
+	}
+	return nil
+}
+
+// ListObjectsPage возвращает одну страницу листинга бакета. Служебные объекты (хвосты tus загрузок) скрыты
+func (s *MinioService) ListObjectsPage(ctx context.Context, input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	input.Bucket = aws.String(s.bucket)
+	ctx, cancel := withTimeout(ctx, s.timeouts.request)
+	defer cancel()
+	page, err := s.s3.ListObjectsV2WithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := page.Contents[:0]
+	for _, object := range page.Contents {
+		if !strings.HasPrefix(aws.StringValue(object.Key), TusTailPrefix) {
+			contents = append(contents, object)
+		}
+	}
+	page.Contents = contents
+	prefixes := page.CommonPrefixes[:0]
+	for _, prefix := range page.CommonPrefixes {
+		if aws.StringValue(prefix.Prefix) != TusTailPrefix {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	page.CommonPrefixes = prefixes
+	return page, nil
+}
+
+// MultipartSession восстанавливает multipart сессию по ключу и идентификатору загрузки,
+// которые клиент S3 шлюза передает в каждом запросе
+func (s *MinioService) MultipartSession(key string, uploadId string) (*s3.S3, *s3.CreateMultipartUploadOutput) {
+	return s.s3, &s3.CreateMultipartUploadOutput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	}
+}
+
+// UploadedPartSize размер уже загруженной части partNum multipart загрузки, 0 если ее еще нет.
+// Клиент S3 может повторить часть с тем же номером, хранилище тогда заменяет прежнюю
+func (s *MinioService) UploadedPartSize(ctx context.Context, key string, uploadId string, partNum int) (int64, error) {
+	reqCtx, cancel := withTimeout(ctx, s.timeouts.request)
+	defer cancel()
+	res, err := s.s3.ListPartsWithContext(reqCtx, &s3.ListPartsInput{
+		Bucket:           aws.String(s.bucket),
+		Key:              aws.String(key),
+		UploadId:         aws.String(uploadId),
+		PartNumberMarker: aws.Int64(int64(partNum - 1)),
+		MaxParts:         aws.Int64(1),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(res.Parts) == 1 && aws.Int64Value(res.Parts[0].PartNumber) == int64(partNum) {
+		return aws.Int64Value(res.Parts[0].Size), nil
+	}
+	return 0, nil
+}
+
+const (
+	// maxCopySize больше 5 GiB S3 одним CopyObject не копирует
+	maxCopySize = 5 << 30
+	// copyPartSize размер части копирования по частям, 5 TiB объекта укладываются в 5120 частей
+	copyPartSize = 1 << 30
+)
+
+// CreateDirectory создает пустой объект-маркер каталога, key должен заканчиваться на "/".
+// Записи в files у маркеров нет
+func (s *MinioService) CreateDirectory(ctx context.Context, key string) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.request)
+	defer cancel()
+	_, err := s.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(""),
+	})
+	return err
+}
+
+// CopyObject копирует объект внутри бакета без передачи данных через сервис и заводит запись о копии в files.
+// Метаданные сжатия копируются вместе с объектом
+func (s *MinioService) CopyObject(ctx context.Context, src string, dst string) error {
+	logger := logging.FromContext(ctx)
+
+	head, err := s.HeadObject(ctx, src)
+	if err != nil {
+		return err
+	}
+	size := aws.Int64Value(head.ContentLength)
+
+	if err = s.fileRepository.StartUpload(ctx, dst, "", size); err != nil {
+		logger.Error(fmt.Sprintf("Unable to start copy of %s to %s: %v", src, dst, err))
+		return err
+	}
+
+	copyCtx, cancel := withTimeout(ctx, s.timeouts.copy)
+	defer cancel()
+	source := (&url.URL{Path: s.bucket + "/" + src}).EscapedPath()
+	if size <= maxCopySize {
+		_, err = s.s3.CopyObjectWithContext(copyCtx, &s3.CopyObjectInput{
+			Bucket:     aws.String(s.bucket),
+			Key:        aws.String(dst),
+			CopySource: aws.String(source),
+		})
+	} else {
+		err = s.copyObjectParts(copyCtx, head, source, dst)
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Unable to copy %s to %s: %v", src, dst, err))
+		s.transition(ctx, dst, structs.StatusFailed, err.Error())
+		return err
+	}
+
+	codec, originalSize := compress.NONE, size
+	for k, v := range head.Metadata {
+		switch http.CanonicalHeaderKey(k) {
+		case MetaCodec:
+			codec = aws.StringValue(v)
+		case MetaOriginalSize:
+			if n, err := strconv.ParseInt(aws.StringValue(v), 10, 64); err == nil {
+				originalSize = n
+			}
+		}
+	}
+	// копия уже в бакете: отмена запроса не должна оставить запись о ней неполной
+	bg := context.WithoutCancel(ctx)
+	s.fileRepository.AddUploadedBytes(bg, dst, int(size))
+	s.fileRepository.UpdateFileCompression(bg, dst, codec, int(originalSize))
+	s.transition(bg, dst, structs.StatusReady, "")
+	return nil
+}
+
+// copyObjectParts копирует большой объект multipart загрузкой из частей UploadPartCopy
+func (s *MinioService) copyObjectParts(ctx context.Context, head *s3.HeadObjectOutput, source string, dst string) error {
+	session, err := s.s3.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(dst),
+		ContentType: head.ContentType,
+		Metadata:    head.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	size := aws.Int64Value(head.ContentLength)
+	var parts []*s3.CompletedPart
+	for offset, partNum := int64(0), int64(1); offset < size; offset, partNum = offset+copyPartSize, partNum+1 {
+		last := min(offset+copyPartSize, size) - 1
+		part, err := s.s3.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:          session.Bucket,
+			Key:             session.Key,
+			UploadId:        session.UploadId,
+			PartNumber:      aws.Int64(partNum),
+			CopySource:      aws.String(source),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, last)),
+		})
+		if err != nil {
+			_, _ = s.s3.AbortMultipartUploadWithContext(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+				Bucket:   session.Bucket,
+				Key:      session.Key,
+				UploadId: session.UploadId,
+			})
+			return err
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: part.CopyPartResult.ETag, PartNumber: aws.Int64(partNum)})
+	}
+
+	_, err = s.s3.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          session.Bucket,
+		Key:             session.Key,
+		UploadId:        session.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
@@ -1,72 +1,136 @@
 package minio
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"mime"
 	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
 	"time"
 
+	"demo-storage/internal/app/metrics"
 	"demo-storage/internal/app/repository"
 	"demo-storage/internal/app/structs"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/compress"
+	"demo-storage/internal/pkg/logging"
 
-	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/gurkankaymak/hocon"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/jmoiron/sqlx"
 	"github.com/labstack/gommon/log"
 )
 
 type MinioService struct {
-	config         *hocon.Config
-	access         string
-	secret         string
+	conf           *config.Config
+	s3             *s3.S3
 	bucket         string
 	fileRepository *repository.FileRepository
-	RETRIES        int
+	tusRepository  *repository.TusRepository
+	compression    *compress.Policy
+	timeouts       timeouts
 }
 
+// timeouts таймауты операций с хранилищем, minio.timeouts. Потоковые загрузки и чтение тела
+// объекта ограничены только контекстом вызывающего: их длительность зависит от размера файла
+type timeouts struct {
+	// request одиночные запросы: head, put пустого объекта, delete, list, complete и abort
+	request time.Duration
+	// part одна попытка загрузки части multipart сессии
+	part time.Duration
+	// copy копирование объекта внутри бакета
+	copy time.Duration
+}
+
+// Ключи пользовательских метаданных объекта (x-amz-meta-*)
+const (
+	MetaCodec        = "Codec"
+	MetaOriginalSize = "Original-Size"
+)
+
 type partUploadResult struct {
 	completedPart *s3.CompletedPart
 	err           error
 }
 
-func New(config *hocon.Config, access string, secret string, db *sqlx.DB) *MinioService {
-	repo := repository.New(db)
+// New создает сервис хранилища. client - общий клиент S3 из NewS3, создается один раз при старте
+func New(conf *config.Config, db *sqlx.DB, client *s3.S3) *MinioService {
+	repo := repository.New(db, conf.DB.QueryTimeout)
 	return &MinioService{
-		config:         config,
-		bucket:         config.GetString("minio.bucket"),
-		access:         access,
-		secret:         secret,
+		conf:           conf,
+		s3:             client,
+		bucket:         conf.Minio.Bucket,
 		fileRepository: repo,
-		RETRIES:        config.GetInt("minio.retries"),
+		tusRepository:  repository.NewTusRepository(db, conf.DB.QueryTimeout),
+		compression:    compress.NewPolicy(conf.Compression),
+		timeouts: timeouts{
+			request: conf.Minio.Timeouts.Request,
+			part:    conf.Minio.Timeouts.Part,
+			copy:    conf.Minio.Timeouts.Copy,
+		},
+	}
+}
+
+// withTimeout ограничивает операцию таймаутом d, 0 - без таймаута
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, d)
 }
 
-func (s *MinioService) CreateMultipartSession(name string) (*s3.S3, *s3.CreateMultipartUploadOutput, error) {
-	s3session := InitS3(s.secret, s.access, s.config)
+// CreateMultipartSession открывает multipart сессию S3. Если codec не NONE, части содержат сжатый поток
+// исходного файла размером size: кодек сразу записывается в метаданные объекта и в files
+func (s *MinioService) CreateMultipartSession(ctx context.Context, name string, size int, codec string) (*s3.S3, *s3.CreateMultipartUploadOutput, error) {
+	if err := s.fileRepository.StartUpload(ctx, name, "", int64(size)); err != nil {
+		return nil, nil, err
+	}
+	var metadata map[string]*string
+	if codec != compress.NONE {
+		metadata = map[string]*string{MetaCodec: aws.String(codec), MetaOriginalSize: aws.String(strconv.Itoa(size))}
+		s.fileRepository.UpdateFileCompression(ctx, name, codec, size)
+	}
+
 	expiryDate := time.Now().AddDate(0, 0, 1)
 
-	createdResp, err := s3session.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
-		Bucket:  aws.String(s.bucket),
-		Key:     aws.String(name),
-		Expires: &expiryDate,
+	reqCtx, cancel := withTimeout(ctx, s.timeouts.request)
+	defer cancel()
+	createdResp, err := s.s3.CreateMultipartUploadWithContext(reqCtx, &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(name),
+		Expires:  &expiryDate,
+		Metadata: metadata,
 	})
 	if err != nil {
+		s.transition(ctx, name, structs.StatusFailed, err.Error())
 		return nil, nil, err
 	}
 
-	return s3session, createdResp, nil
+	return s.s3, createdResp, nil
 }
 
-func (s *MinioService) UploadPartToS3(s3connection *s3.S3, multipartSession *s3.CreateMultipartUploadOutput, fileBytes []byte, partNum int) structs.PartUploadResult {
-	logger := logdoc.GetLogger()
+// UploadPartToS3 загружает часть multipart сессии, повторяя попытки с экспоненциальной задержкой.
+// Отмена ctx прерывает и текущий запрос, и ожидание следующей попытки
+func (s *MinioService) UploadPartToS3(ctx context.Context, s3connection *s3.S3, multipartSession *s3.CreateMultipartUploadOutput, fileBytes []byte, partNum int) structs.PartUploadResult {
+	logger := logging.FromContext(ctx)
 	var try int
+	retries := config.GetConfig().Minio.Retries
 	logger.Debug(fmt.Sprintf(">> UploadPartToS3 > Uploading chunk:%v, part number:%d to S3", len(fileBytes), partNum))
-	for try <= s.RETRIES {
-		uploadRes, err := s3connection.UploadPart(&s3.UploadPartInput{
+	for try <= retries {
+		started := time.Now()
+		partCtx, cancel := withTimeout(ctx, s.timeouts.part)
+		uploadRes, err := s3connection.UploadPartWithContext(partCtx, &s3.UploadPartInput{
 			Body:          bytes.NewReader(fileBytes),
 			Bucket:        multipartSession.Bucket,
 			Key:           multipartSession.Key,
@@ -74,30 +138,57 @@ func (s *MinioService) UploadPartToS3(s3connection *s3.S3, multipartSession *s3.
 			UploadId:      multipartSession.UploadId,
 			ContentLength: aws.Int64(int64(len(fileBytes))),
 		})
+		cancel()
+		metrics.ObservePartUpload(started, err)
 		if err != nil {
 			logger.Error(">> UploadPartToS3 > err: ", err)
-			if try == s.RETRIES {
+			if try == retries || ctx.Err() != nil {
 				return structs.PartUploadResult{Err: err}
 			}
+			delay := s.backoff(try)
 			try++
-			time.Sleep(time.Second * 15)
+			metrics.PartUploadRetried()
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return structs.PartUploadResult{Err: ctx.Err()}
+			}
 		} else {
 			logger.Debug(fmt.Sprintf(">> Successfully Uploaded part with size:%d, part number:%d to S3", len(fileBytes), partNum))
+			// часть уже в бакете, учитываем ее и при отмене загрузки
+			s.addUploadedBytes(context.WithoutCancel(ctx), *multipartSession.Key, len(fileBytes))
 			return structs.PartUploadResult{
-				&s3.CompletedPart{
+				CompletedPart: &s3.CompletedPart{
 					ETag:       uploadRes.ETag,
 					PartNumber: aws.Int64(int64(partNum)),
-				}, nil,
+				},
 			}
 		}
 	}
 	return structs.PartUploadResult{}
 }
 
-func (s *MinioService) CompleteMultipartUpload(s3connection *s3.S3, uploadSession *s3.CreateMultipartUploadOutput, completedParts []*s3.CompletedPart) error {
-	logger := logdoc.GetLogger()
+// backoff возвращает задержку перед повторной попыткой: экспонента от базовой задержки
+// с "full jitter", ограниченная максимальной задержкой
+func (s *MinioService) backoff(try int) time.Duration {
+	retry := config.GetConfig().Minio
+	base, limit := retry.RetryBaseDelay, retry.RetryMaxDelay
+
+	delay := limit
+	if try < 30 && base<<try < limit {
+		delay = base << try
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
 
-	completed, err := s3connection.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+func (s *MinioService) CompleteMultipartUpload(ctx context.Context, s3connection *s3.S3, uploadSession *s3.CreateMultipartUploadOutput, completedParts []*s3.CompletedPart) error {
+	logger := logging.FromContext(ctx)
+
+	s.transition(ctx, *uploadSession.Key, structs.StatusAssembling, "")
+	// сборка больших объектов на стороне S3 занимает заметное время, поэтому таймаут как у копирования
+	reqCtx, cancel := withTimeout(ctx, s.timeouts.copy)
+	defer cancel()
+	completed, err := s3connection.CompleteMultipartUploadWithContext(reqCtx, &s3.CompleteMultipartUploadInput{
 		Bucket:   uploadSession.Bucket,
 		Key:      uploadSession.Key,
 		UploadId: uploadSession.UploadId,
@@ -107,17 +198,25 @@ func (s *MinioService) CompleteMultipartUpload(s3connection *s3.S3, uploadSessio
 	})
 	if err != nil {
 		logger.Error("Complete multipart upload failed: " + err.Error())
+		s.transition(ctx, *uploadSession.Key, structs.StatusFailed, err.Error())
 		return err
 	}
 
 	logger.Debug("Multipart completed successfully: " + completed.String())
+	s.transition(ctx, *uploadSession.Key, structs.StatusReady, "")
 	return nil
 }
 
-func (s *MinioService) AbortMultipartUpload(s3connection *s3.S3, uploadSession *s3.CreateMultipartUploadOutput) error {
-	logger := logdoc.GetLogger()
+// AbortMultipartUpload прерывает multipart сессию и переводит файл в status (CANCELLED или FAILED).
+// Выполняется и после отмены ctx: прерывание обычно и вызвано отменой загрузки
+func (s *MinioService) AbortMultipartUpload(ctx context.Context, s3connection *s3.S3, uploadSession *s3.CreateMultipartUploadOutput, status structs.FileStatus, reason string) error {
+	logger := logging.FromContext(ctx)
+
+	s.transition(ctx, *uploadSession.Key, status, reason)
 
-	_, err := s3connection.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+	reqCtx, cancel := withTimeout(context.WithoutCancel(ctx), s.timeouts.request)
+	defer cancel()
+	_, err := s3connection.AbortMultipartUploadWithContext(reqCtx, &s3.AbortMultipartUploadInput{
 		Bucket:   uploadSession.Bucket,
 		Key:      uploadSession.Key,
 		UploadId: uploadSession.UploadId,
@@ -126,132 +225,254 @@ func (s *MinioService) AbortMultipartUpload(s3connection *s3.S3, uploadSession *
 		logger.Error("Abort multipart upload failed: " + err.Error())
 		return err
 	}
+
+	switch {
+	case reason == tusExpiredReason:
+		metrics.MultipartAborted(metrics.AbortExpired)
+	case status == structs.StatusCancelled:
+		metrics.MultipartAborted(metrics.AbortCancelled)
+	default:
+		metrics.MultipartAborted(metrics.AbortFailed)
+	}
 	return nil
 }
 
-func (s *MinioService) UploadFileAsBytes(fileHeader *structs.UploadHeader, data []byte) *s3.PutObjectOutput {
-	logger := logdoc.GetLogger()
+// ListStaleMultipartUploads возвращает незавершенные multipart загрузки бакета, начатые раньше olderThan.
+// Сессии tus загрузок пропускаются, у них свой срок жизни
+func (s *MinioService) ListStaleMultipartUploads(ctx context.Context, olderThan time.Time) ([]*s3.MultipartUpload, error) {
 
-	f := s.fileRepository.FindFileByName(fileHeader.Filename)
-	if f == nil || f.Id == 0 {
-		logger.Warn("Файл " + fileHeader.Filename + " не найден в БД, создаем новый")
-		s.fileRepository.CreateFile(fileHeader.Filename, "TODO")
-	} else {
-		s.fileRepository.UpdateFileStatus(fileHeader.Filename, "UPLOADING")
-		time.Sleep(5 * time.Second)
+	tusUploads, err := s.tusRepository.ActiveS3UploadIds(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	// Устанавливаем параметры загрузки
-	params := &s3.PutObjectInput{
+	var stale []*s3.MultipartUpload
+	err = s.s3.ListMultipartUploadsPagesWithContext(ctx, &s3.ListMultipartUploadsInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(fileHeader.Filename),
-		Body:   bytes.NewReader(data),
+	}, func(page *s3.ListMultipartUploadsOutput, _ bool) bool {
+		for _, upload := range page.Uploads {
+			if tusUploads[aws.StringValue(upload.UploadId)] {
+				continue
+			}
+			if upload.Initiated != nil && upload.Initiated.Before(olderThan) {
+				stale = append(stale, upload)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
+	return stale, nil
+}
 
-	s3Api := InitS3(s.secret, s.access, s.config)
-	// Загружаем файл на Amazon S3
-	uploaded, err := s3Api.PutObject(params)
+// AbortStaleMultipartUpload прерывает брошенную multipart загрузку. Запись о файле переводится в FAILED
+// только если она не обновлялась с olderThan, иначе по этому ключу уже идет новая загрузка
+func (s *MinioService) AbortStaleMultipartUpload(ctx context.Context, upload *s3.MultipartUpload, olderThan time.Time, reason string) error {
+
+	reqCtx, cancel := withTimeout(ctx, s.timeouts.request)
+	defer cancel()
+	_, err := s.s3.AbortMultipartUploadWithContext(reqCtx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      upload.Key,
+		UploadId: upload.UploadId,
+	})
 	if err != nil {
-		logger.Error("Unable to upload file,", err)
-		s.fileRepository.UpdateFileStatus(fileHeader.Filename, "ERROR")
-		return nil
+		return err
 	}
+	metrics.MultipartAborted(metrics.AbortStale)
 
-	logger.Debug("Successfully uploaded file to" + uploaded.String())
-	go func() {
-		_ = s.fileRepository.UpdateFileParams(fileHeader.Filename, "COMPLETED", "TODO")
-	}()
-	return uploaded
+	f := s.fileRepository.FindFileByName(ctx, aws.StringValue(upload.Key))
+	if f != nil && f.Id != 0 && !f.UploadStatus.Terminal() && f.UpdatedAt.Before(olderThan) {
+		s.transition(ctx, f.Name, structs.StatusFailed, reason)
+	}
+	return nil
 }
 
-func (s *MinioService) UploadFile(fileHeader *multipart.FileHeader, filePath string) *s3.PutObjectOutput {
-	logger := logdoc.GetLogger()
-	src, err := fileHeader.Open()
-	if err != nil {
-		logger.Error("Ошибка открытия файла ", fileHeader.Filename)
-		s.fileRepository.UpdateFileStatus(fileHeader.Filename, "ERROR")
-		return nil
+// FailStaleFiles переводит в FAILED записи о файлах, застрявшие в незавершенных состояниях,
+// и возвращает количество исправленных записей
+func (s *MinioService) FailStaleFiles(ctx context.Context, olderThan time.Time, reason string) int {
+	logger := logging.FromContext(ctx)
+
+	var failed int
+	for _, f := range s.fileRepository.FindStaleFiles(ctx, olderThan) {
+		if err := s.fileRepository.Transition(ctx, f.Name, structs.StatusFailed, reason); err != nil {
+			logger.Error(fmt.Sprintf("Unable to move file %s to %s: %v", f.Name, structs.StatusFailed, err))
+			continue
+		}
+		failed++
 	}
-	defer src.Close()
+	return failed
+}
 
-	f := s.fileRepository.FindFileByName(fileHeader.Filename)
-	if f == nil || f.Id == 0 {
-		logger.Warn("Файл " + fileHeader.Filename + " не найден в БД, создаем новый")
-		s.fileRepository.CreateFile(fileHeader.Filename, filePath)
-	} else {
-		s.fileRepository.UpdateFileStatus(fileHeader.Filename, "UPLOADING")
-		time.Sleep(5 * time.Second)
+// UploadFileStream потоково загружает body в хранилище через s3manager, не буферизуя файл целиком.
+// body должен содержать ровно fileHeader.Size байт, лишние данные прерывают загрузку.
+// При Size == structs.UnknownSize размер ограничен upload.max-size и фиксируется по факту
+func (s *MinioService) UploadFileStream(ctx context.Context, fileHeader *structs.UploadHeader, filePath string, body io.Reader) (*s3manager.UploadOutput, error) {
+	logger := logging.FromContext(ctx)
+
+	sizeKnown := fileHeader.Size != structs.UnknownSize
+	limit, policySize := int64(fileHeader.Size), fileHeader.Size
+	if !sizeKnown {
+		limit, policySize = math.MaxInt64, math.MaxInt
+		if maxSize := config.GetConfig().Upload.MaxSize; maxSize > 0 {
+			limit = int64(maxSize)
+		}
 	}
 
-	// Открываем файл, который хотим загрузить
-	file, err := fileHeader.Open()
-	if err != nil {
-		logger.Error("Unable to open file, ", err.Error())
-		s.fileRepository.UpdateFileStatus(fileHeader.Filename, "ERROR")
-		return nil
+	if err := s.fileRepository.StartUpload(ctx, fileHeader.Filename, filePath, max(int64(fileHeader.Size), 0)); err != nil {
+		logger.Error(fmt.Sprintf("Unable to start upload of %s: %v", fileHeader.Filename, err))
+		return nil, err
+	}
+	// владельца закрепляем сразу: у файла не должно быть момента, когда он ничей
+	if fileHeader.Owner != "" {
+		if err := s.fileRepository.SetFileOwner(ctx, fileHeader.Filename, fileHeader.Owner); err != nil {
+			logger.Error(fmt.Sprintf("Unable to set owner of %s: %v", fileHeader.Filename, err))
+			s.transition(ctx, fileHeader.Filename, structs.StatusFailed, err.Error())
+			return nil, err
+		}
 	}
-	defer file.Close()
+
+	// content type определяем по первым байтам, не вычитывая поток
+	br := bufio.NewReaderSize(body, 512)
+	head, _ := br.Peek(512)
+	contentType := detectContentType(fileHeader, head)
 
 	// Устанавливаем параметры загрузки
-	params := &s3.PutObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(fileHeader.Filename),
-		Body:   file,
+	limited := &sizeLimitedReader{r: br, n: limit}
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(fileHeader.Filename),
+		Body:        limited,
+		ContentType: aws.String(contentType),
 	}
 
-	s3Api := InitS3(s.secret, s.access, s.config)
-	// Загружаем файл на Amazon S3
-	fupl, err := s3Api.PutObject(params)
+	// Сжимаем содержимое на лету, если content type попадает под политику сжатия
+	codec := s.compression.CodecFor(contentType, policySize)
+	if codec != compress.NONE {
+		compressed := compressStream(codec, input.Body)
+		defer compressed.Close()
+		input.Body = compressed
+		input.Metadata = map[string]*string{MetaCodec: aws.String(codec)}
+		if sizeKnown {
+			input.Metadata[MetaOriginalSize] = aws.String(strconv.Itoa(fileHeader.Size))
+		}
+	}
+
+	// Загружаем файл на Amazon S3. Uploader сам выбирает PutObject или multipart
+	// и держит в памяти только буферы частей, а не весь файл
+	uploader := s3manager.NewUploaderWithClient(s.s3)
+	uploaded, err := uploader.UploadWithContext(ctx, input)
 	if err != nil {
+		// тело оборвалось раньше заявленной длины или оказалось длиннее: такой файл не сохраняем
+		if errors.Is(limited.err, io.ErrUnexpectedEOF) || errors.Is(limited.err, ErrSizeExceeded) {
+			s.transition(ctx, fileHeader.Filename, structs.StatusFailed, limited.err.Error())
+			return nil, limited.err
+		}
+		if errors.Is(limited.err, ErrUploadCanceled) || ctx.Err() != nil {
+			reason := context.Cause(ctx)
+			if limited.err != nil {
+				reason = limited.err
+			}
+			s.transition(ctx, fileHeader.Filename, structs.StatusCancelled, reason.Error())
+			return nil, reason
+		}
 		logger.Error("Unable to upload file,", err)
-		s.fileRepository.UpdateFileStatus(fileHeader.Filename, "ERROR")
-		return nil
+		s.transition(ctx, fileHeader.Filename, structs.StatusFailed, err.Error())
+		return nil, err
 	}
 
-	logger.Debug("Successfully uploaded file to" + fupl.String())
-	go func() {
-		_ = s.fileRepository.UpdateFileParams(fileHeader.Filename, "COMPLETED", filePath)
-	}()
-	return fupl
+	logger.Debug("Successfully uploaded file to " + uploaded.Location)
+	// Объект уже в бакете: отмена запроса после этого не должна оставить запись о нем неполной
+	bg := context.WithoutCancel(ctx)
+	size := int(limited.read)
+	if !sizeKnown {
+		s.fileRepository.UpdateFileSize(bg, fileHeader.Filename, limited.read)
+	}
+	s.addUploadedBytes(bg, fileHeader.Filename, size)
+	s.fileRepository.UpdateFileCompression(bg, fileHeader.Filename, codec, size)
+	s.transition(bg, fileHeader.Filename, structs.StatusReady, "")
+	return uploaded, nil
 }
 
-func (s *MinioService) DownloadFile(fileName string) *s3.GetObjectOutput {
-	logger := logdoc.GetLogger()
+func (s *MinioService) UploadFile(ctx context.Context, fileHeader *multipart.FileHeader, filePath string) (*s3manager.UploadOutput, error) {
+	logger := logging.FromContext(ctx)
 
-	s3Api := InitS3(s.secret, s.access, s.config)
-	bucketName := aws.String(s.bucket)
-	result, err := s3Api.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(*bucketName),
+	// Открываем файл, который хотим загрузить
+	file, err := fileHeader.Open()
+	if err != nil {
+		logger.Error("Unable to open file, ", err.Error())
+		return nil, err
+	}
+	defer file.Close()
+
+	return s.UploadFileStream(ctx, &structs.UploadHeader{
+		Filename:    fileHeader.Filename,
+		Size:        int(fileHeader.Size),
+		ContentType: fileHeader.Header.Get("Content-Type"),
+	}, filePath, file)
+}
+
+// DownloadFile открывает объект на чтение. Тело читается в рамках ctx, таймаута у него нет
+// DownloadFile открывает объект на чтение. Отсутствующий объект - ErrObjectNotFound,
+// остальные ошибки хранилища возвращаются как есть
+func (s *MinioService) DownloadFile(ctx context.Context, fileName string) (*s3.GetObjectOutput, error) {
+	logger := logging.FromContext(ctx)
+
+	result, err := s.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
 		Key:    aws.String(fileName),
 	})
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && (reqErr.Code() == s3.ErrCodeNoSuchKey || reqErr.Code() == "NotFound") {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, fileName)
+	}
 	if err != nil {
 		logger.Error(err.Error())
-		return nil
+		return nil, err
 	}
 
-	return result
+	result.Body = metrics.CountDownload(s.bucket, result.Body)
+	return result, nil
 }
 
-func (s *MinioService) ListBuckets() []*s3.Bucket {
-	logger := logdoc.GetLogger()
+// downloadObject читает объект для внутренней обработки, в метрики скачивания не попадает
+func (s *MinioService) downloadObject(ctx context.Context, key string) (*s3.GetObjectOutput, error) {
+	return s.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+}
+
+func (s *MinioService) ListBuckets(ctx context.Context) []*s3.Bucket {
+	logger := logging.FromContext(ctx)
 
 	// Показываем список бакетов
-	s3Api := InitS3(s.secret, s.access, s.config)
-	resp, err := s3Api.ListBuckets(nil)
+	reqCtx, cancel := withTimeout(ctx, s.timeouts.request)
+	defer cancel()
+	resp, err := s.s3.ListBucketsWithContext(reqCtx, &s3.ListBucketsInput{})
 	if err != nil {
 		logger.Error("Unable to list buckets\n" + err.Error())
+		return nil
 	}
 
 	return resp.Buckets
 }
 
-func (s *MinioService) ListObjects(bucket string) *s3.ListObjectsV2Output {
-	logger := logdoc.GetLogger()
+// PingBucket проверяет, что бакет сервиса доступен с текущими ключами
+func (s *MinioService) PingBucket(ctx context.Context) error {
+	_, err := s.s3.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucket)})
+	return err
+}
+
+func (s *MinioService) ListObjects(ctx context.Context, bucket string) *s3.ListObjectsV2Output {
+	logger := logging.FromContext(ctx)
 
-	s3Api := InitS3(s.secret, s.access, s.config)
 	// Запрашиваем список файлов в бакете
 	bucketName := aws.String(bucket)
-	result, err := s3Api.ListObjectsV2(&s3.ListObjectsV2Input{
+	reqCtx, cancel := withTimeout(ctx, s.timeouts.request)
+	defer cancel()
+	result, err := s.s3.ListObjectsV2WithContext(reqCtx, &s3.ListObjectsV2Input{
 		Bucket: aws.String(*bucketName),
 	})
 	if err != nil {
@@ -266,22 +487,128 @@ func (s *MinioService) ListObjects(bucket string) *s3.ListObjectsV2Output {
 	return result
 }
 
-func InitS3(secret string, access string, config *hocon.Config) *s3.S3 {
-	// Создаем новую сессию AWS
-	accessKey := access
-	secretKey := secret
-	creds := credentials.NewStaticCredentials(accessKey, secretKey, "")
-	sess, err := session.NewSession(&aws.Config{
-		Credentials:      creds,
-		DisableSSL:       aws.Bool(true),
-		S3ForcePathStyle: aws.Bool(true),
-		Endpoint:         aws.String(config.GetString("minio.address") + ":" + config.GetString("minio.port")),
-		Region:           aws.String("us-west-2"),
-	})
-	if err != nil {
-		panic(err)
+// addUploadedBytes учитывает сохраненные в бакете байты файла в files и в метриках
+func (s *MinioService) addUploadedBytes(ctx context.Context, name string, n int) {
+	s.fileRepository.AddUploadedBytes(ctx, name, n)
+	metrics.AddUploadedBytes(s.bucket, n)
+}
+
+// transition меняет состояние файла, ошибки перехода только логируются:
+// запрещенный переход не должен ломать уже выполненную операцию с хранилищем.
+// Отмена ctx переход не прерывает, иначе отмененная загрузка осталась бы в UPLOADING
+func (s *MinioService) transition(ctx context.Context, name string, to structs.FileStatus, reason string) {
+	logger := logging.FromContext(ctx)
+	if err := s.fileRepository.Transition(context.WithoutCancel(ctx), name, to, reason); err != nil {
+		logger.Error(fmt.Sprintf("Unable to move file %s to %s: %v", name, to, err))
 	}
+}
 
-	// Создаем новый клиент Amazon S3
-	return s3.New(sess)
+// ObjectCodec возвращает кодек, которым было сжато содержимое объекта при загрузке
+func ObjectCodec(object *s3.GetObjectOutput) string {
+	for k, v := range object.Metadata {
+		if http.CanonicalHeaderKey(k) == MetaCodec {
+			return aws.StringValue(v)
+		}
+	}
+	return compress.NONE
+}
+
+var (
+	// ErrObjectNotFound объекта с таким ключом нет в бакете
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrSizeExceeded тело загрузки оказалось больше заявленного размера
+	ErrSizeExceeded = errors.New("upload body exceeds declared size")
+	// ErrUploadCanceled источник потока прервал загрузку по запросу клиента
+	ErrUploadCanceled = errors.New("upload canceled by client")
+)
+
+// sizeLimitedReader не дает прочитать больше заявленного размера и запоминает
+// ошибку источника: s3manager заворачивает ее в awserr без возможности errors.Is
+type sizeLimitedReader struct {
+	r    io.Reader
+	n    int64
+	read int64
+	err  error
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		var one [1]byte
+		n, err := l.r.Read(one[:])
+		if n > 0 {
+			l.err = ErrSizeExceeded
+			return 0, l.err
+		}
+		// источник может сообщить об ошибке только в конце потока (например, не сошлась контрольная сумма)
+		if err != nil && err != io.EOF {
+			l.err = err
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	l.read += int64(n)
+	if err != nil && err != io.EOF {
+		l.err = err
+	}
+	return n, err
+}
+
+// compressStream сжимает src в отдельной горутине. Закрытие возвращенного ридера
+// останавливает горутину, если загрузка прервалась раньше конца потока
+func compressStream(codec string, src io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := compress.NewWriter(codec, pw)
+		if err == nil {
+			_, err = io.Copy(w, src)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// MultipartCodec кодек сжатия для multipart загрузки по websocket. Сессия открывается до первого блока,
+// поэтому тип содержимого берется только из заголовка или по расширению, неизвестный тип не сжимается
+func (s *MinioService) MultipartCodec(fileHeader *structs.UploadHeader) string {
+	contentType := fileHeader.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(fileHeader.Filename))
+	}
+	if contentType == "" {
+		return compress.NONE
+	}
+	return s.compression.CodecFor(contentType, fileHeader.Size)
+}
+
+func detectContentType(fileHeader *structs.UploadHeader, data []byte) string {
+	if fileHeader.ContentType != "" {
+		return fileHeader.ContentType
+	}
+	if ct := mime.TypeByExtension(filepath.Ext(fileHeader.Filename)); ct != "" {
+		return ct
+	}
+	return http.DetectContentType(data)
+}
+
+// Заголовки, по которым запрос к S3 находится в логах MinIO по идентификатору запроса и сессии загрузки клиента
+const (
+	HeaderRequestID     = "X-Request-ID"
+	HeaderUploadSession = "X-Upload-Session"
+)
+
+func correlationHeaders(r *request.Request) {
+	if id := logging.RequestID(r.Context()); id != "" {
+		r.HTTPRequest.Header.Set(HeaderRequestID, id)
+	}
+	if id := logging.SessionID(r.Context()); id != "" {
+		r.HTTPRequest.Header.Set(HeaderUploadSession, id)
+	}
 }
@@ -0,0 +1,168 @@
+package minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"demo-storage/internal/app/repository"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/pkg/logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	reconcileImportReason  = "imported by bucket reconciliation"
+	reconcileMissingReason = "object not found in bucket by reconciliation"
+)
+
+// reconcilePageSize сколько ключей бакета и записей files читается за раз. Листинг S3 больше 1000 не отдает
+const reconcilePageSize = 1000
+
+// Reconcile сверяет объекты бакета с таблицей files. Находит объекты без записей
+// и READY записи без объектов. При fix=true импортирует первые и помечает вторые MISSING.
+// Обе стороны читаются страницами в порядке ключей и сливаются, в памяти только расхождения
+func (s *MinioService) Reconcile(ctx context.Context, fix bool) (*structs.ReconcileReport, error) {
+	logger := logging.FromContext(ctx)
+
+	report := &structs.ReconcileReport{
+		OrphanObjects: []structs.ReconcileObject{},
+		DanglingRows:  []string{},
+		Fixed:         fix,
+	}
+
+	objects := &objectCursor{s: s}
+	rows := &rowCursor{s: s}
+	object, err := objects.next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row, err := rows.next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// matched у текущего объекта есть запись
+	matched := false
+	for object != nil || row != nil {
+		switch {
+		case row == nil || object != nil && object.Key < row.Name:
+			report.Objects++
+			// пустые маркеры каталогов WebDAV создаются без записей в files
+			dir := strings.HasSuffix(object.Key, "/") && object.Size == 0
+			if !matched && !dir {
+				report.OrphanObjects = append(report.OrphanObjects, *object)
+			}
+			matched = false
+			if object, err = objects.next(ctx); err != nil {
+				return nil, err
+			}
+		case object == nil || row.Name < object.Key:
+			report.Rows++
+			// незавершенные загрузки объекта еще не имеют, удаленные и пропавшие уже не должны
+			if row.UploadStatus == structs.StatusReady {
+				report.DanglingRows = append(report.DanglingRows, row.Name)
+			}
+			if row, err = rows.next(ctx); err != nil {
+				return nil, err
+			}
+		default:
+			report.Rows++
+			matched = true
+			if row, err = rows.next(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if fix {
+		for _, object := range report.OrphanObjects {
+			err = s.fileRepository.ImportFile(ctx, object.Key, object.Size, object.Etag, reconcileImportReason)
+			// запись появилась после сверки: объект загрузили заново, импортировать нечего
+			if errors.Is(err, repository.ErrFileExists) {
+				continue
+			}
+			if err != nil {
+				logger.Error(fmt.Sprintf(">> Reconcile > unable to import %s: %v", object.Key, err))
+				continue
+			}
+			report.Imported++
+		}
+		for _, name := range report.DanglingRows {
+			if err = s.fileRepository.Transition(ctx, name, structs.StatusMissing, reconcileMissingReason); err != nil {
+				logger.Error(fmt.Sprintf(">> Reconcile > unable to mark %s missing: %v", name, err))
+				continue
+			}
+			report.MarkedMissing++
+		}
+	}
+
+	logger.Info(fmt.Sprintf(">> Reconcile > %d objects, %d rows, %d orphan objects, %d dangling rows, %d imported, %d marked missing",
+		report.Objects, report.Rows, len(report.OrphanObjects), len(report.DanglingRows), report.Imported, report.MarkedMissing))
+	return report, nil
+}
+
+// objectCursor читает ключи бакета по странице за раз
+type objectCursor struct {
+	s     *MinioService
+	page  []*s3.Object
+	token *string
+	done  bool
+}
+
+// next следующий объект бакета или nil, когда объекты кончились
+func (c *objectCursor) next(ctx context.Context) (*structs.ReconcileObject, error) {
+	for len(c.page) == 0 {
+		if c.done {
+			return nil, nil
+		}
+		page, err := c.s.ListObjectsPage(ctx, &s3.ListObjectsV2Input{
+			ContinuationToken: c.token,
+			MaxKeys:           aws.Int64(reconcilePageSize),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list bucket %s: %w", c.s.bucket, err)
+		}
+		c.page, c.token = page.Contents, page.NextContinuationToken
+		c.done = !aws.BoolValue(page.IsTruncated)
+	}
+	object := c.page[0]
+	c.page = c.page[1:]
+	return &structs.ReconcileObject{
+		Key:  aws.StringValue(object.Key),
+		Size: aws.Int64Value(object.Size),
+		Etag: strings.Trim(aws.StringValue(object.ETag), `"`),
+	}, nil
+}
+
+// rowCursor читает записи files по странице за раз в порядке ключей бакета
+type rowCursor struct {
+	s    *MinioService
+	page []structs.File
+	last *structs.File
+	done bool
+}
+
+// next следующая запись или nil, когда записи кончились
+func (c *rowCursor) next(ctx context.Context) (*structs.File, error) {
+	for len(c.page) == 0 {
+		if c.done {
+			return nil, nil
+		}
+		afterName, afterId := "", 0
+		if c.last != nil {
+			afterName, afterId = c.last.Name, c.last.Id
+		}
+		page, err := c.s.fileRepository.FindFilesPage(ctx, afterName, afterId, reconcilePageSize)
+		if err != nil {
+			return nil, fmt.Errorf("read files: %w", err)
+		}
+		c.page = page
+		c.done = len(page) < reconcilePageSize
+	}
+	c.last = &c.page[0]
+	c.page = c.page[1:]
+	return c.last, nil
+}
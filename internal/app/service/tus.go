@@ -0,0 +1,330 @@
+package minio
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"demo-storage/internal/app/repository"
+	"demo-storage/internal/app/structs"
+	"demo-storage/internal/pkg/compress"
+	"demo-storage/internal/pkg/logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// TusTailPrefix префикс объектов бакета с хвостами незавершенных tus загрузок.
+// Хвост - принятые байты, которых пока не хватает на целую часть S3
+const TusTailPrefix = ".tus/"
+
+var (
+	ErrUploadNotFound   = repository.ErrUploadNotFound
+	ErrOffsetConflict   = repository.ErrOffsetConflict
+	ErrUploadLocked     = repository.ErrUploadLocked
+	ErrUploadExpired    = errors.New("upload expired")
+	ErrLengthExceeded   = errors.New("upload exceeds declared length")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+const tusExpiredReason = "tus upload expired"
+
+func tusTailKey(id string, offset int64) string {
+	return fmt.Sprintf("%s%s.%d", TusTailPrefix, id, offset)
+}
+
+func tusSession(bucket string, u *structs.TusUpload) *s3.CreateMultipartUploadOutput {
+	return &s3.CreateMultipartUploadOutput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(u.Name),
+		UploadId: aws.String(u.S3UploadId),
+	}
+}
+
+func newTusId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// CreateTusUpload открывает S3 multipart сессию для tus загрузки и сохраняет ее состояние
+func (s *MinioService) CreateTusUpload(ctx context.Context, name string, length int64, partSize int64, metadata string, expiresAt time.Time) (*structs.TusUpload, error) {
+	logger := logging.FromContext(ctx)
+
+	id, err := newTusId()
+	if err != nil {
+		return nil, err
+	}
+
+	s3connection, session, err := s.CreateMultipartSession(ctx, name, int(length), compress.NONE)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &structs.TusUpload{
+		Id:         id,
+		Name:       name,
+		S3UploadId: aws.StringValue(session.UploadId),
+		Length:     length,
+		PartSize:   partSize,
+		Metadata:   sql.NullString{String: metadata, Valid: metadata != ""},
+		ExpiresAt:  expiresAt,
+	}
+	if err = s.tusRepository.CreateUpload(ctx, u); err != nil {
+		logger.Error(fmt.Sprintf("Unable to save tus upload of %s: %v", name, err))
+		_ = s.AbortMultipartUpload(ctx, s3connection, session, structs.StatusFailed, err.Error())
+		return nil, err
+	}
+	return u, nil
+}
+
+// SuspendMultipartUpload превращает прерванную multipart загрузку в tus загрузку, чтобы клиент
+// продолжил ее по протоколу tus со смещения parts*partSize. Все parts частей уже в S3 и имеют размер partSize
+func (s *MinioService) SuspendMultipartUpload(ctx context.Context, uploadSession *s3.CreateMultipartUploadOutput, length int64, partSize int64, parts int, metadata string, expiresAt time.Time) (*structs.TusUpload, error) {
+	id, err := newTusId()
+	if err != nil {
+		return nil, err
+	}
+	u := &structs.TusUpload{
+		Id:         id,
+		Name:       aws.StringValue(uploadSession.Key),
+		S3UploadId: aws.StringValue(uploadSession.UploadId),
+		Length:     length,
+		PartSize:   partSize,
+		Metadata:   sql.NullString{String: metadata, Valid: metadata != ""},
+		ExpiresAt:  expiresAt,
+	}
+	// загрузка прерывается из-за остановки сервиса, ее состояние сохраняем и после отмены запроса
+	ctx = context.WithoutCancel(ctx)
+	if err = s.tusRepository.CreateUpload(ctx, u); err != nil {
+		return nil, err
+	}
+	if parts > 0 {
+		if err = s.tusRepository.AdvanceUpload(ctx, u, int64(parts)*partSize, parts); err != nil {
+			_ = s.tusRepository.DeleteUpload(ctx, u.Id)
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// FindTusUpload возвращает состояние tus загрузки. Для истекших загрузок возвращает ErrUploadExpired
+func (s *MinioService) FindTusUpload(ctx context.Context, id string) (*structs.TusUpload, error) {
+	u, err := s.tusRepository.FindUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !u.Completed() && u.ExpiresAt.Before(time.Now()) {
+		return u, ErrUploadExpired
+	}
+	return u, nil
+}
+
+// LockTusUpload занимает загрузку id для записи. Занятая другим запросом загрузка - ErrUploadLocked
+func (s *MinioService) LockTusUpload(ctx context.Context, id string) (unlock func(), err error) {
+	return s.tusRepository.LockUpload(ctx, id)
+}
+
+// WriteTusUpload дописывает body в загрузку u с ее текущего смещения и возвращает новое смещение.
+// Полные части сразу уходят в S3, остаток сохраняется хвостом до следующего PATCH.
+// Смещение в базе сдвигается только после успешной записи и проверки checksum, поэтому
+// при ошибке клиент повторяет запрос с прежнего смещения. Обрыв тела без checksum
+// не ошибка протокола: принятые байты сохраняются, и клиент продолжит с нового смещения.
+// Держит в памяти одну часть u.PartSize, вызывающий занимает ее в бюджете памяти загрузок
+// и пишет в загрузку только под LockTusUpload
+func (s *MinioService) WriteTusUpload(ctx context.Context, u *structs.TusUpload, body io.Reader, checksum *structs.TusChecksum) (int64, error) {
+	logger := logging.FromContext(ctx)
+
+	session := tusSession(s.bucket, u)
+
+	// Ограничиваем тело остатком длины, лишний байт означает превышение Upload-Length
+	remaining := u.Length - u.Offset
+	limited := io.LimitReader(body, remaining+1)
+	if checksum != nil {
+		limited = io.TeeReader(limited, checksum.Hash)
+	}
+
+	var src io.Reader = limited
+	oldTail := u.TailSize()
+	if oldTail > 0 {
+		tail, err := s.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(tusTailKey(u.Id, u.Offset)),
+		})
+		if err != nil {
+			return u.Offset, fmt.Errorf("read upload tail: %w", err)
+		}
+		defer tail.Body.Close()
+		src = io.MultiReader(io.LimitReader(tail.Body, oldTail), limited)
+	}
+
+	// Читаем поток частями: целые части загружаем сразу, последний неполный кусок остается в buf
+	parts := u.Parts
+	var received int64
+	var bodyErr error
+	buf := make([]byte, u.PartSize)
+	var n int
+	for {
+		var err error
+		n, err = io.ReadFull(src, buf)
+		received += int64(n)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				bodyErr = err
+			}
+			break
+		}
+		if received-oldTail > remaining {
+			return u.Offset, ErrLengthExceeded
+		}
+		res := s.UploadPartToS3(ctx, s.s3, session, buf, parts+1)
+		if res.Err != nil {
+			return u.Offset, res.Err
+		}
+		parts++
+		n = 0
+	}
+
+	written := received - oldTail
+	if written > remaining {
+		return u.Offset, ErrLengthExceeded
+	}
+	if checksum != nil {
+		if bodyErr != nil {
+			return u.Offset, bodyErr
+		}
+		if !bytes.Equal(checksum.Hash.Sum(nil), checksum.Sum) {
+			return u.Offset, ErrChecksumMismatch
+		}
+	}
+	if bodyErr != nil {
+		logger.Warn(fmt.Sprintf("tus upload %s: request body interrupted after %d bytes: %v", u.Id, written, bodyErr))
+	}
+
+	offset := u.Offset + written
+	if n > 0 {
+		if offset == u.Length {
+			// последняя часть multipart загрузки может быть меньше минимального размера
+			res := s.UploadPartToS3(ctx, s.s3, session, buf[:n], parts+1)
+			if res.Err != nil {
+				return u.Offset, res.Err
+			}
+			parts++
+		} else {
+			putCtx, cancel := withTimeout(ctx, s.timeouts.part)
+			_, err := s.s3.PutObjectWithContext(putCtx, &s3.PutObjectInput{
+				Bucket: aws.String(s.bucket),
+				Key:    aws.String(tusTailKey(u.Id, offset)),
+				Body:   bytes.NewReader(buf[:n]),
+			})
+			cancel()
+			if err != nil {
+				return u.Offset, fmt.Errorf("save upload tail: %w", err)
+			}
+		}
+	}
+
+	if offset == u.Offset {
+		return u.Offset, nil
+	}
+	oldOffset := u.Offset
+	if err := s.tusRepository.AdvanceUpload(ctx, u, offset, parts); err != nil {
+		return oldOffset, err
+	}
+	if oldTail > 0 {
+		s.deleteTusTail(ctx, u.Id, oldOffset)
+	}
+
+	if u.Completed() {
+		if err := s.completeTusUpload(ctx, s.s3, u); err != nil {
+			return u.Offset, err
+		}
+	}
+	return u.Offset, nil
+}
+
+// completeTusUpload собирает объект из загруженных частей. Части с номерами больше
+// сохраненного могли остаться от PATCH запросов, которые не прошли проверку, их пропускаем
+func (s *MinioService) completeTusUpload(ctx context.Context, s3connection *s3.S3, u *structs.TusUpload) error {
+	session := tusSession(s.bucket, u)
+
+	var completed []*s3.CompletedPart
+	err := s3connection.ListPartsPagesWithContext(ctx, &s3.ListPartsInput{
+		Bucket:   session.Bucket,
+		Key:      session.Key,
+		UploadId: session.UploadId,
+	}, func(page *s3.ListPartsOutput, _ bool) bool {
+		for _, part := range page.Parts {
+			if aws.Int64Value(part.PartNumber) <= int64(u.Parts) {
+				completed = append(completed, &s3.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		s.transition(ctx, u.Name, structs.StatusFailed, err.Error())
+		return err
+	}
+	if len(completed) != u.Parts {
+		err = fmt.Errorf("upload %s has %d parts in storage, expected %d", u.Id, len(completed), u.Parts)
+		s.transition(ctx, u.Name, structs.StatusFailed, err.Error())
+		return err
+	}
+
+	return s.CompleteMultipartUpload(ctx, s3connection, session, completed)
+}
+
+// TerminateTusUpload удаляет tus загрузку. Незавершенная загрузка прерывается со статусом status
+func (s *MinioService) TerminateTusUpload(ctx context.Context, u *structs.TusUpload, status structs.FileStatus, reason string) error {
+	if !u.Completed() {
+		if err := s.AbortMultipartUpload(ctx, s.s3, tusSession(s.bucket, u), status, reason); err != nil {
+			return err
+		}
+		if u.TailSize() > 0 {
+			s.deleteTusTail(ctx, u.Id, u.Offset)
+		}
+	}
+	return s.tusRepository.DeleteUpload(ctx, u.Id)
+}
+
+// ExpireTusUploads удаляет истекшие tus загрузки и возвращает их количество
+func (s *MinioService) ExpireTusUploads(ctx context.Context, now time.Time) (int, error) {
+	logger := logging.FromContext(ctx)
+
+	uploads, err := s.tusRepository.FindExpiredUploads(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	var expired int
+	for i := range uploads {
+		if err = s.TerminateTusUpload(ctx, &uploads[i], structs.StatusFailed, tusExpiredReason); err != nil {
+			logger.Error(fmt.Sprintf("Unable to expire tus upload %s of %s: %v", uploads[i].Id, uploads[i].Name, err))
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+func (s *MinioService) deleteTusTail(ctx context.Context, id string, offset int64) {
+	logger := logging.FromContext(ctx)
+
+	ctx, cancel := withTimeout(context.WithoutCancel(ctx), s.timeouts.request)
+	defer cancel()
+	_, err := s.s3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(tusTailKey(id, offset)),
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("Unable to delete tail of tus upload %s: %v", id, err))
+	}
+}
@@ -0,0 +1,64 @@
+package structs
+
+import (
+	"database/sql"
+	"time"
+)
+
+// FileStatus состояние жизненного цикла файла в таблице files
+type FileStatus string
+
+const (
+	StatusPending    FileStatus = "PENDING"
+	StatusUploading  FileStatus = "UPLOADING"
+	StatusAssembling FileStatus = "ASSEMBLING"
+	StatusProcessing FileStatus = "PROCESSING"
+	StatusReady      FileStatus = "READY"
+	StatusFailed     FileStatus = "FAILED"
+	StatusCancelled  FileStatus = "CANCELLED"
+	StatusDeleted    FileStatus = "DELETED"
+	// StatusMissing запись есть, а объекта в бакете нет (выставляется сверкой бакета и БД)
+	StatusMissing FileStatus = "MISSING"
+)
+
+// transitions допустимые переходы между состояниями,
+// все остальные переходы репозиторий отклоняет
+var transitions = map[FileStatus][]FileStatus{
+	StatusPending:    {StatusUploading, StatusFailed, StatusCancelled, StatusDeleted},
+	StatusUploading:  {StatusAssembling, StatusProcessing, StatusReady, StatusFailed, StatusCancelled},
+	StatusAssembling: {StatusProcessing, StatusReady, StatusFailed},
+	StatusProcessing: {StatusReady, StatusFailed},
+	StatusReady:      {StatusUploading, StatusProcessing, StatusDeleted, StatusMissing},
+	StatusFailed:     {StatusUploading, StatusDeleted},
+	StatusCancelled:  {StatusUploading, StatusDeleted},
+	StatusDeleted:    {StatusUploading},
+	StatusMissing:    {StatusUploading, StatusReady, StatusDeleted},
+}
+
+func (s FileStatus) CanTransition(to FileStatus) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Terminal возвращает true для состояний, в которых файл не участвует в загрузке
+func (s FileStatus) Terminal() bool {
+	switch s {
+	case StatusReady, StatusFailed, StatusCancelled, StatusDeleted, StatusMissing:
+		return true
+	default:
+		return false
+	}
+}
+
+type FileTransition struct {
+	Id        int            `db:"id"`
+	FileId    int            `db:"file_id"`
+	From      sql.NullString `db:"from_status"`
+	To        FileStatus     `db:"to_status"`
+	Reason    sql.NullString `db:"reason"`
+	CreatedAt time.Time      `db:"created_at"`
+}
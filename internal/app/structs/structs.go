@@ -2,6 +2,8 @@ package structs
 
 import (
 	"database/sql"
+	"hash"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/s3"
 )
@@ -19,10 +21,46 @@ type User struct {
 }
 
 type File struct {
-	Id           int            `db:"id" validate:"required"`
-	Name         string         `db:"file_name" validate:"required"`
-	UploadStatus string         `db:"upload_status" validate:"required"`
-	StorageLink  sql.NullString `db:"storage_link"`
+	Id            int            `db:"id" validate:"required"`
+	Name          string         `db:"file_name" validate:"required"`
+	UploadStatus  FileStatus     `db:"upload_status" validate:"required"`
+	StorageLink   sql.NullString `db:"storage_link"`
+	OriginalSize  sql.NullInt64  `db:"original_size"`
+	Codec         sql.NullString `db:"codec"`
+	Etag          sql.NullString `db:"etag"`
+	ErrorReason   sql.NullString `db:"error_reason"`
+	BytesTotal    int64          `db:"bytes_total"`
+	BytesUploaded int64          `db:"bytes_uploaded"`
+	Owner         sql.NullString `db:"owner"`
+	CreatedAt     time.Time      `db:"created_at"`
+	UpdatedAt     time.Time      `db:"updated_at"`
+}
+
+// WritableBy владелец owner может перезаписать или удалить файл: записи нет, файл его
+// или файл без владельца, загрузка которого не удалась или удалена
+func (f *File) WritableBy(owner string) bool {
+	if f.Id == 0 {
+		return true
+	}
+	if f.Owner.Valid {
+		return f.Owner.String == owner
+	}
+	switch f.UploadStatus {
+	case StatusFailed, StatusCancelled, StatusDeleted:
+		return true
+	default:
+		return false
+	}
+}
+
+// APIKey ключ доступа к S3 шлюзу. QuotaBytes не задана - объем файлов владельца не ограничен
+type APIKey struct {
+	AccessKey  string        `db:"access_key"`
+	SecretKey  string        `db:"secret_key"`
+	Owner      string        `db:"owner"`
+	QuotaBytes sql.NullInt64 `db:"quota_bytes"`
+	Disabled   bool          `db:"disabled"`
+	CreatedAt  time.Time     `db:"created_at"`
 }
 
 type Token struct {
@@ -30,16 +68,76 @@ type Token struct {
 }
 
 type Response struct {
+	FileName string `json:"fileName" xml:"fileName"`
 	FilePath string `json:"filePath" xml:"filePath"`
 	Result   string `json:"result" xml:"result"`
 }
 
+// UnknownSize размер файла заранее неизвестен (HTTP multipart без размера части)
+const UnknownSize = -1
+
 type UploadHeader struct {
-	Filename string
-	Size     int
+	Filename    string
+	Size        int
+	ContentType string
+	Extract     bool   // распаковать архив после загрузки
+	Prefix      string // префикс для распакованных файлов, по умолчанию имя архива
+	Owner       string // владелец ключа S3 шлюза, закрепляется за файлом до начала загрузки
 }
 
 type PartUploadResult struct {
 	CompletedPart *s3.CompletedPart
 	Err           error
 }
+
+type ReconcileObject struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	Etag string `json:"etag"`
+}
+
+// ReconcileReport результат сверки бакета и таблицы files
+type ReconcileReport struct {
+	Objects       int               `json:"objects"`
+	Rows          int               `json:"rows"`
+	OrphanObjects []ReconcileObject `json:"orphanObjects"`
+	DanglingRows  []string          `json:"danglingRows"`
+	Fixed         bool              `json:"fixed"`
+	Imported      int               `json:"imported"`
+	MarkedMissing int               `json:"markedMissing"`
+}
+
+// TusUpload состояние resumable загрузки по протоколу tus поверх S3 multipart сессии.
+// Полные части уже загружены в S3, хвост меньше PartSize хранится отдельным объектом
+type TusUpload struct {
+	Id         string         `db:"id"`
+	Name       string         `db:"file_name"`
+	S3UploadId string         `db:"s3_upload_id"`
+	Length     int64          `db:"upload_length"`
+	Offset     int64          `db:"upload_offset"`
+	PartSize   int64          `db:"part_size"`
+	Parts      int            `db:"parts"`
+	Metadata   sql.NullString `db:"metadata"`
+	ExpiresAt  time.Time      `db:"expires_at"`
+	CreatedAt  time.Time      `db:"created_at"`
+	UpdatedAt  time.Time      `db:"updated_at"`
+}
+
+// Completed все байты загрузки получены
+func (u *TusUpload) Completed() bool {
+	return u.Offset == u.Length
+}
+
+// TailSize размер хвоста, еще не отправленного в S3 частью
+func (u *TusUpload) TailSize() int64 {
+	if u.Completed() {
+		return 0
+	}
+	return u.Offset - int64(u.Parts)*u.PartSize
+}
+
+// TusChecksum ожидаемая контрольная сумма тела PATCH запроса tus
+type TusChecksum struct {
+	Hash hash.Hash
+	Sum  []byte
+}
@@ -1,24 +1,260 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config конфигурация сервиса. Загружается из HOCON через Load: значения по умолчанию из тегов default,
+// любой ключ переопределяется переменной окружения STORAGE_<КЛЮЧ> (minio.retry-base-delay -
+// STORAGE_MINIO_RETRY_BASE_DELAY), секреты можно читать из файлов. Теги полей описаны в load.go
+type Config struct {
+	Server      Server      `hocon:"server"`
+	JWT         JWT         `hocon:"jwt"`
+	DB          DB          `hocon:"db"`
+	Minio       Minio       `hocon:"minio"`
+	RateLimit   RateLimit   `hocon:"ratelimit"`
+	CORS        CORS        `hocon:"cors"`
+	Upload      Upload      `hocon:"upload"`
+	Tus         Tus         `hocon:"tus"`
+	S3Gateway   S3Gateway   `hocon:"s3gateway"`
+	WebDAV      WebDAV      `hocon:"webdav"`
+	Compression Compression `hocon:"compression"`
+	Archive     Archive     `hocon:"archive"`
+	Janitor     Janitor     `hocon:"janitor"`
+	Health      Health      `hocon:"health"`
+	Shutdown    Shutdown    `hocon:"shutdown"`
+	Tracing     Tracing     `hocon:"tracing"`
+	Logging     Logging     `hocon:"logging"`
+	LD          LD          `hocon:"ld"`
+}
+
+type Server struct {
+	// Proto и Address публичный адрес сервиса для ссылок на скачивание
+	Proto   string `hocon:"proto" default:"http"`
+	Address string `hocon:"address" default:"localhost"`
+	// Port порт HTTP сервера, флаг -port имеет приоритет
+	Port string `hocon:"port"`
+}
+
+type JWT struct {
+	Issuer   string `hocon:"issuer" required:"true"`
+	Audience string `hocon:"audience" required:"true"`
+}
+
+type DB struct {
+	Driver      string `hocon:"driver" default:"postgres"`
+	Host        string `hocon:"host" default:"localhost"`
+	Port        string `hocon:"port" default:"5432"`
+	User        string `hocon:"user" default:"postgres"`
+	Name        string `hocon:"name" required:"true"`
+	SSL         string `hocon:"ssl" default:"disable"`
+	Password    string `hocon:"password" env:"PGPASS" secret:"true" required:"true"`
+	AutoMigrate bool   `hocon:"auto-migrate"`
+	// QueryTimeout предел одного запроса или транзакции, 0 - без ограничения
+	QueryTimeout time.Duration `hocon:"query-timeout" default:"5s"`
+}
+
+type Minio struct {
+	Address string `hocon:"address" required:"true"`
+	Port    string `hocon:"port" required:"true"`
+	Bucket  string `hocon:"bucket" required:"true"`
+	// AccessKey и SecretKey обязательны только командам, которые работают с хранилищем
+	AccessKey string `hocon:"access-key" env:"MINIO_ACCESS" secret:"true"`
+	SecretKey string `hocon:"secret-key" env:"MINIO_SECRET" secret:"true"`
+	// TLS подключение по https, CAFile - PEM сертификаты своего центра сертификации в дополнение к системным
+	TLS            bool          `hocon:"tls"`
+	CAFile         string        `hocon:"ca-file"`
+	Region         string        `hocon:"region" default:"us-west-2"`
+	PathStyle      bool          `hocon:"path-style" default:"true"`
+	Retries        int           `hocon:"retries" default:"2"`
+	RetryBaseDelay time.Duration `hocon:"retry-base-delay" default:"500ms"`
+	RetryMaxDelay  time.Duration `hocon:"retry-max-delay" default:"15s"`
+	Timeouts       MinioTimeouts `hocon:"timeouts"`
+	HTTP           MinioHTTP     `hocon:"http"`
+}
+
+// MinioTimeouts таймауты запросов к S3, 0 - без ограничения
+type MinioTimeouts struct {
+	Request time.Duration `hocon:"request" default:"30s"`
+	Part    time.Duration `hocon:"part" default:"2m"`
+	Copy    time.Duration `hocon:"copy" default:"10m"`
+}
+
+// MinioHTTP пул соединений общего клиента S3. Таймауты соединения, 0 - без ограничения
+type MinioHTTP struct {
+	MaxIdleConns        int `hocon:"max-idle-conns" default:"100"`
+	MaxIdleConnsPerHost int `hocon:"max-idle-conns-per-host" default:"100"`
+	// MaxConnsPerHost предел соединений с MinIO, 0 - без ограничения
+	MaxConnsPerHost       int           `hocon:"max-conns-per-host"`
+	IdleConnTimeout       time.Duration `hocon:"idle-conn-timeout" default:"90s"`
+	DialTimeout           time.Duration `hocon:"dial-timeout" default:"5s"`
+	TLSHandshakeTimeout   time.Duration `hocon:"tls-handshake-timeout" default:"10s"`
+	ResponseHeaderTimeout time.Duration `hocon:"response-header-timeout"`
+}
+
+type RateLimit struct {
+	// Rate запросов в секунду с одного IP, Burst - сколько из них может прийти одновременно
+	Rate  float64 `hocon:"rate" default:"20"`
+	Burst int     `hocon:"burst" default:"20"`
+}
+
+type CORS struct {
+	AllowOrigins []string `hocon:"allow-origins" default:"*"`
+}
+
+type Upload struct {
+	// MaxSize максимальный размер загружаемого файла, 0 - без ограничения
+	MaxSize      int `hocon:"max-size"`
+	Parallelism  int `hocon:"parallelism" default:"4"`
+	PartSize     int `hocon:"part-size" default:"8388608"`
+	MemoryBudget int `hocon:"memory-budget" default:"268435456"`
+	MaxStreams   int `hocon:"max-streams" default:"8"`
+}
+
+type Tus struct {
+	Expiration time.Duration `hocon:"expiration" default:"24h"`
+}
+
+type S3Gateway struct {
+	Enabled     bool `hocon:"enabled"`
+	MaxPartSize int  `hocon:"max-part-size" default:"134217728"`
+}
+
+type WebDAV struct {
+	Enabled bool `hocon:"enabled"`
+}
 
-	"github.com/gurkankaymak/hocon"
+type Compression struct {
+	Enabled bool     `hocon:"enabled"`
+	Codec   string   `hocon:"codec" default:"gzip"`
+	MinSize int      `hocon:"min-size" default:"1024"`
+	Types   []string `hocon:"types" default:"text/*,application/json,application/x-ndjson,application/xml"`
+}
+
+// Archive пределы распаковки архива, 0 - без ограничения
+type Archive struct {
+	MaxEntries   int `hocon:"max-entries" default:"10000"`
+	MaxEntrySize int `hocon:"max-entry-size" default:"1073741824"`
+	MaxTotalSize int `hocon:"max-total-size" default:"10737418240"`
+}
+
+type Janitor struct {
+	Enabled  bool          `hocon:"enabled"`
+	Interval time.Duration `hocon:"interval" default:"10m"`
+	MaxAge   time.Duration `hocon:"max-age" default:"24h"`
+}
+
+type Health struct {
+	Timeout     time.Duration `hocon:"timeout" default:"2s"`
+	DiskPath    string        `hocon:"disk-path" default:"."`
+	MinFreeDisk int           `hocon:"min-free-disk"`
+	// MaxMemory предел памяти процесса, 0 - не проверять
+	MaxMemory int `hocon:"max-memory"`
+}
+
+type Shutdown struct {
+	DrainTimeout time.Duration `hocon:"drain-timeout" default:"60s"`
+	AbortTimeout time.Duration `hocon:"abort-timeout" default:"15s"`
+}
+
+type Tracing struct {
+	Exporter    string  `hocon:"exporter" default:"none"`
+	Endpoint    string  `hocon:"endpoint"`
+	Insecure    bool    `hocon:"insecure"`
+	File        string  `hocon:"file" default:"traces.json"`
+	ServiceName string  `hocon:"service-name" default:"demo-storage"`
+	SampleRatio float64 `hocon:"sample-ratio" default:"1"`
+}
+
+type Logging struct {
+	Level  string      `hocon:"level" default:"debug"`
+	Sinks  []string    `hocon:"sinks" default:"stdout"`
+	Format string      `hocon:"format" default:"json"`
+	File   LoggingFile `hocon:"file"`
+}
+
+type LoggingFile struct {
+	Path       string `hocon:"path" default:"logs/storage.log"`
+	MaxSizeMB  int    `hocon:"max-size-mb" default:"100"`
+	MaxBackups int    `hocon:"max-backups" default:"5"`
+	MaxAgeDays int    `hocon:"max-age-days" default:"30"`
+	Compress   bool   `hocon:"compress"`
+}
+
+type LD struct {
+	Proto string `hocon:"proto" default:"tcp"`
+	Host  string `hocon:"host" default:"127.0.0.1"`
+	Port  string `hocon:"port" default:"6676"`
+	App   string `hocon:"app" default:"demo-storage"`
+}
+
+// Reloadable ключи, которые применяются без перезапуска: их читают через GetConfig на каждый запрос.
+// Изменение остальных ключей при перечитывании вступает в силу только после перезапуска
+var Reloadable = []string{
+	"minio.retries",
+	"minio.retry-base-delay",
+	"minio.retry-max-delay",
+	"ratelimit",
+	"cors",
+	"jwt.issuer",
+	"jwt.audience",
+	"upload.max-size",
+	"logging.level",
+}
+
+var (
+	config   atomic.Pointer[Config]
+	confFile string
+	reloadMu sync.Mutex
 )
 
-var config *hocon.Config
+// Report итог перечитывания конфигурации: Applied - примененные ключи,
+// RestartRequired - измененные ключи, которые вступят в силу только после перезапуска
+type Report struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restartRequired"`
+}
 
-func GetConfig() *hocon.Config {
-	return config
+// GetConfig действующая конфигурация
+func GetConfig() *Config {
+	return config.Load()
 }
 
-func MustConfig(confFile string) {
-	if confFile == "" {
+// MustConfig загружает конфигурацию или завершает процесс со списком всех ошибок
+func MustConfig(file string) {
+	if file == "" {
 		log.Fatal("Empty configuration file. Exiting...")
 	}
-	c, e := hocon.ParseResource(confFile)
-	if e != nil {
-		log.Fatal("Error reading app configuration file. Exiting...")
+	c, err := Load(file)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("Invalid app configuration %s:\n%v\nExiting...", file, err))
+	}
+	confFile = file
+	config.Store(c)
+}
+
+// Reload перечитывает файл конфигурации и атомарно заменяет действующую.
+// Файл с ошибками не применяется, действующая конфигурация остается прежней
+func Reload() (*Report, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	c, err := Load(confFile)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	for _, key := range diff(config.Load(), c) {
+		if reloadable(key) {
+			report.Applied = append(report.Applied, key)
+		} else {
+			report.RestartRequired = append(report.RestartRequired, key)
+		}
 	}
-	config = c
+	config.Store(c)
+	return report, nil
 }
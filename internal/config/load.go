@@ -0,0 +1,290 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gurkankaymak/hocon"
+)
+
+// Теги полей Config:
+//
+//	hocon    ключ в секции, для вложенной структуры - имя секции
+//	default  значение, если ключа нет ни в файле, ни в окружении. Списки - через запятую
+//	required пустое значение - ошибка
+//	env      дополнительное имя переменной окружения, кроме STORAGE_<КЛЮЧ>
+//	secret   значение можно прочитать из файла (Docker и Kubernetes secrets): путь задается ключом <ключ>-file
+//	         или переменными STORAGE_<КЛЮЧ>_FILE, <env>_FILE. Из файла берется содержимое без концевых пробелов
+//
+// Приоритет: переменная окружения, файл секрета, значение в конфиге, default
+const envPrefix = "STORAGE_"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Load читает HOCON файл в Config. Возвращает сразу все ошибки: неизвестные ключи (опечатки),
+// значения неподходящего типа, пустые обязательные и не прошедшие проверку значения
+func Load(file string) (*Config, error) {
+	raw, err := hocon.ParseResource(file)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", file, err)
+	}
+
+	c := &Config{}
+	l := &loader{raw: raw, known: map[string]bool{}}
+	l.section("", reflect.ValueOf(c).Elem())
+	l.unknown()
+	l.errs = append(l.errs, c.validate()...)
+	if len(l.errs) > 0 {
+		return nil, errors.Join(l.errs...)
+	}
+	return c, nil
+}
+
+type loader struct {
+	raw   *hocon.Config
+	known map[string]bool
+	errs  []error
+}
+
+func (l *loader) fail(key string, format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+}
+
+func (l *loader) section(prefix string, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := f.Tag.Get("hocon")
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if f.Type.Kind() == reflect.Struct && f.Type != durationType {
+			l.section(key, v.Field(i))
+			continue
+		}
+		l.known[key] = true
+		l.field(key, f, v.Field(i))
+	}
+}
+
+func (l *loader) field(key string, f reflect.StructField, v reflect.Value) {
+	envNames := []string{envName(key)}
+	if alias := f.Tag.Get("env"); alias != "" {
+		envNames = append(envNames, alias)
+	}
+	secret := f.Tag.Get("secret") == "true"
+	if secret {
+		l.known[key+"-file"] = true
+	}
+
+	var err error
+	switch {
+	case lookupEnv(envNames) != "":
+		err = setString(v, lookupEnv(envNames))
+	case secret && l.secretFile(key, envNames) != "":
+		var b []byte
+		if b, err = os.ReadFile(l.secretFile(key, envNames)); err == nil {
+			err = setString(v, strings.TrimSpace(string(b)))
+		}
+	case l.raw.Get(key) != nil:
+		err = setValue(v, l.raw.Get(key))
+	case f.Tag.Get("default") != "":
+		err = setString(v, f.Tag.Get("default"))
+	}
+	if err != nil {
+		l.fail(key, "%v", err)
+		return
+	}
+	if f.Tag.Get("required") == "true" && v.IsZero() {
+		l.fail(key, "is required")
+	}
+}
+
+// secretFile путь к файлу секрета из окружения или конфига
+func (l *loader) secretFile(key string, envNames []string) string {
+	fileEnv := make([]string, len(envNames))
+	for i, name := range envNames {
+		fileEnv[i] = name + "_FILE"
+	}
+	if path := lookupEnv(fileEnv); path != "" {
+		return path
+	}
+	if v, ok := l.raw.Get(key + "-file").(hocon.String); ok {
+		return unquote(v)
+	}
+	return ""
+}
+
+// unknown ключи файла, которых нет в Config: скорее всего опечатки
+func (l *loader) unknown() {
+	keys := map[string]bool{}
+	flatten("", l.raw.GetRoot(), keys)
+	var unknown []string
+	for key := range keys {
+		if !l.known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		l.fail(key, "unknown key")
+	}
+}
+
+func flatten(prefix string, v hocon.Value, out map[string]bool) {
+	obj, ok := v.(hocon.Object)
+	if !ok {
+		if prefix != "" {
+			out[prefix] = true
+		}
+		return
+	}
+	for k, child := range obj {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		flatten(key, child, out)
+	}
+}
+
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+func lookupEnv(names []string) string {
+	for _, name := range names {
+		if s := os.Getenv(name); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func unquote(s hocon.String) string {
+	return strings.Trim(string(s), `"`)
+}
+
+// setValue присваивает значение из HOCON с проверкой типа
+func setValue(v reflect.Value, value hocon.Value) error {
+	switch val := value.(type) {
+	case hocon.String:
+		return setString(v, unquote(val))
+	case hocon.Duration:
+		if v.Type() != durationType {
+			return fmt.Errorf("unexpected duration %s", val)
+		}
+		v.SetInt(int64(val))
+		return nil
+	case hocon.Array:
+		if v.Kind() != reflect.Slice {
+			return fmt.Errorf("unexpected list %s", val)
+		}
+		items := make([]string, len(val))
+		for i, item := range val {
+			s, ok := item.(hocon.String)
+			if !ok {
+				return fmt.Errorf("list item %s is not a string", item)
+			}
+			items[i] = unquote(s)
+		}
+		v.Set(reflect.ValueOf(items))
+		return nil
+	case hocon.Object:
+		return errors.New("unexpected section, expecting a value")
+	default:
+		return setString(v, value.String())
+	}
+}
+
+// setString разбирает строку из окружения, файла секрета, default или скалярного значения HOCON
+func setString(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		if s == "0" {
+			v.SetInt(0)
+			return nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		switch s {
+		case "true", "yes", "on":
+			v.SetBool(true)
+		case "false", "no", "off":
+			v.SetBool(false)
+		default:
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+// diff ключи, значения которых различаются в a и b
+func diff(a *Config, b *Config) []string {
+	var keys []string
+	var walk func(prefix string, x reflect.Value, y reflect.Value)
+	walk = func(prefix string, x reflect.Value, y reflect.Value) {
+		t := x.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key := f.Tag.Get("hocon")
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if f.Type.Kind() == reflect.Struct && f.Type != durationType {
+				walk(key, x.Field(i), y.Field(i))
+				continue
+			}
+			if !reflect.DeepEqual(x.Field(i).Interface(), y.Field(i).Interface()) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	walk("", reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem())
+	return keys
+}
+
+func reloadable(key string) bool {
+	for _, prefix := range Reloadable {
+		if key == prefix || strings.HasPrefix(key, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// required обязательные ключи минимального конфига
+var required = map[string]string{
+	"jwt.issuer":    `"storage"`,
+	"jwt.audience":  `"clients"`,
+	"db.name":       `"storage"`,
+	"db.password":   `"secret"`,
+	"minio.address": `"minio"`,
+	"minio.port":    `"9000"`,
+	"minio.bucket":  `"files"`,
+}
+
+// writeConf пишет минимальный конфиг без ключей omit и с дополнительными строками extra
+func writeConf(t *testing.T, extra string, omit ...string) string {
+	t.Helper()
+	var lines []string
+	for key, value := range required {
+		if !slices.Contains(omit, key) {
+			lines = append(lines, key+" = "+value)
+		}
+	}
+	lines = append(lines, extra)
+	file := filepath.Join(t.TempDir(), "application.conf")
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// clearEnv убирает переменные окружения, которые Load читает в обход файла
+func clearEnv(t *testing.T) {
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, envPrefix) {
+			t.Setenv(name, "")
+		}
+	}
+	for _, name := range []string{"PGPASS", "PGPASS_FILE", "MINIO_ACCESS", "MINIO_ACCESS_FILE", "MINIO_SECRET", "MINIO_SECRET_FILE"} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	clearEnv(t)
+	c, err := Load(writeConf(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key  string
+		got  any
+		want any
+	}{
+		{"server.proto", c.Server.Proto, "http"},
+		{"server.port", c.Server.Port, ""},
+		{"db.port", c.DB.Port, "5432"},
+		{"db.query-timeout", c.DB.QueryTimeout, 5 * time.Second},
+		{"minio.path-style", c.Minio.PathStyle, true},
+		{"minio.tls", c.Minio.TLS, false},
+		{"minio.retry-base-delay", c.Minio.RetryBaseDelay, 500 * time.Millisecond},
+		{"minio.timeouts.part", c.Minio.Timeouts.Part, 2 * time.Minute},
+		{"ratelimit.rate", c.RateLimit.Rate, 20.0},
+		{"cors.allow-origins", c.CORS.AllowOrigins, []string{"*"}},
+		{"upload.part-size", c.Upload.PartSize, 8388608},
+		{"upload.max-size", c.Upload.MaxSize, 0},
+		{"compression.types", c.Compression.Types, []string{"text/*", "application/json", "application/x-ndjson", "application/xml"}},
+		{"archive.max-total-size", c.Archive.MaxTotalSize, 10737418240},
+		{"logging.sinks", c.Logging.Sinks, []string{"stdout"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("%s = %v, want %v", tt.key, tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		extra string
+		omit  []string
+		env   map[string]string
+		get   func(c *Config) any
+		want  any
+	}{
+		{
+			name:  "file value over default",
+			extra: `minio.region = "eu-central-1"`,
+			get:   func(c *Config) any { return c.Minio.Region },
+			want:  "eu-central-1",
+		},
+		{
+			name: "env over default",
+			env:  map[string]string{"STORAGE_MINIO_RETRY_BASE_DELAY": "1s"},
+			get:  func(c *Config) any { return c.Minio.RetryBaseDelay },
+			want: time.Second,
+		},
+		{
+			name:  "env over file",
+			extra: "upload.part-size = 8388608",
+			env:   map[string]string{"STORAGE_UPLOAD_PART_SIZE": "16777216"},
+			get:   func(c *Config) any { return c.Upload.PartSize },
+			want:  16777216,
+		},
+		{
+			name: "env list",
+			env:  map[string]string{"STORAGE_CORS_ALLOW_ORIGINS": "https://a.example, https://b.example"},
+			get:  func(c *Config) any { return c.CORS.AllowOrigins },
+			want: []string{"https://a.example", "https://b.example"},
+		},
+		{
+			name: "env boolean",
+			env:  map[string]string{"STORAGE_COMPRESSION_ENABLED": "yes"},
+			get:  func(c *Config) any { return c.Compression.Enabled },
+			want: true,
+		},
+		{
+			name: "env alias",
+			env:  map[string]string{"PGPASS": "from-alias"},
+			get:  func(c *Config) any { return c.DB.Password },
+			want: "from-alias",
+		},
+		{
+			name: "prefixed env over alias",
+			env:  map[string]string{"PGPASS": "from-alias", "STORAGE_DB_PASSWORD": "from-env"},
+			get:  func(c *Config) any { return c.DB.Password },
+			want: "from-env",
+		},
+		{
+			name:  "secret file from config",
+			extra: `db.password-file = "` + secretFile + `"`,
+			omit:  []string{"db.password"},
+			get:   func(c *Config) any { return c.DB.Password },
+			want:  "from-file",
+		},
+		{
+			name: "secret file from env over config value",
+			env:  map[string]string{"STORAGE_DB_PASSWORD_FILE": secretFile},
+			get:  func(c *Config) any { return c.DB.Password },
+			want: "from-file",
+		},
+		{
+			name: "env over secret file",
+			env:  map[string]string{"STORAGE_DB_PASSWORD_FILE": secretFile, "PGPASS": "from-alias"},
+			get:  func(c *Config) any { return c.DB.Password },
+			want: "from-alias",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			c, err := Load(writeConf(t, tt.extra, tt.omit...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.get(c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		extra string
+		omit  []string
+		env   map[string]string
+		want  []string
+	}{
+		{
+			name: "missing required",
+			omit: []string{"db.name", "jwt.issuer"},
+			want: []string{"db.name: is required", "jwt.issuer: is required"},
+		},
+		{
+			name: "missing secret",
+			omit: []string{"db.password"},
+			want: []string{"db.password: is required"},
+		},
+		{
+			name:  "missing secret file",
+			extra: `db.password-file = "/nonexistent/password"`,
+			omit:  []string{"db.password"},
+			want:  []string{"db.password: open /nonexistent/password"},
+		},
+		{
+			name:  "unknown key",
+			extra: "upload.part-sise = 8388608",
+			want:  []string{"upload.part-sise: unknown key"},
+		},
+		{
+			name:  "invalid type",
+			extra: `upload.part-size = "eight"`,
+			want:  []string{`upload.part-size: invalid integer "eight"`},
+		},
+		{
+			name: "invalid env value",
+			env:  map[string]string{"STORAGE_DB_QUERY_TIMEOUT": "soon"},
+			want: []string{`db.query-timeout: invalid duration "soon"`},
+		},
+		{
+			name:  "section instead of value",
+			extra: "server.proto { value = http }",
+			want:  []string{"server.proto: unexpected section"},
+		},
+		{
+			name:  "validation",
+			extra: "server.proto = ftp\nupload.part-size = 1024\nminio.retry-max-delay = 100ms",
+			want: []string{
+				`server.proto: "ftp" is not one of http, https`,
+				"upload.part-size: must be between",
+				"minio.retry-max-delay: 100ms is less than minio.retry-base-delay 500ms",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			_, err := Load(writeConf(t, tt.extra, tt.omit...))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			// Load возвращает все ошибки сразу
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
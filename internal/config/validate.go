@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"demo-storage/internal/pkg/s3part"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// hostname по RFC 1123, без схемы, порта и пути
+	hostnameRe = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+	// имя бакета S3
+	bucketRe = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+)
+
+// validate проверяет значения, которые прочитались без ошибок типа. Возвращает все найденные ошибки
+func (c *Config) validate() []error {
+	var errs []error
+	invalid := func(key string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+	oneOf := func(key string, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		invalid(key, "%q is not one of %s", value, strings.Join(allowed, ", "))
+	}
+	port := func(key string, value string) {
+		if value == "" {
+			return
+		}
+		if p, err := strconv.Atoi(value); err != nil || p < 1 || p > 65535 {
+			invalid(key, "%q is not a port number", value)
+		}
+	}
+	notNegative := func(key string, value int64) {
+		if value < 0 {
+			invalid(key, "must not be negative, got %d", value)
+		}
+	}
+	positive := func(key string, value int64) {
+		if value <= 0 {
+			invalid(key, "must be positive, got %d", value)
+		}
+	}
+
+	oneOf("server.proto", c.Server.Proto, "http", "https")
+	port("server.port", c.Server.Port)
+
+	if a := c.Minio.Address; a != "" && net.ParseIP(a) == nil && !hostnameRe.MatchString(a) {
+		invalid("minio.address", "%q is not a host name or IP address, scheme and port are set separately", a)
+	}
+	port("minio.port", c.Minio.Port)
+	if b := c.Minio.Bucket; b != "" && !bucketRe.MatchString(b) {
+		invalid("minio.bucket", "%q is not a valid bucket name", b)
+	}
+	if c.Minio.CAFile != "" && !c.Minio.TLS {
+		invalid("minio.ca-file", "requires minio.tls = true")
+	}
+	if c.Minio.Region == "" {
+		invalid("minio.region", "must not be empty")
+	}
+	notNegative("minio.retries", int64(c.Minio.Retries))
+	positive("minio.retry-base-delay", int64(c.Minio.RetryBaseDelay))
+	if c.Minio.RetryMaxDelay < c.Minio.RetryBaseDelay {
+		invalid("minio.retry-max-delay", "%s is less than minio.retry-base-delay %s", c.Minio.RetryMaxDelay, c.Minio.RetryBaseDelay)
+	}
+	notNegative("minio.timeouts.request", int64(c.Minio.Timeouts.Request))
+	notNegative("minio.timeouts.part", int64(c.Minio.Timeouts.Part))
+	notNegative("minio.timeouts.copy", int64(c.Minio.Timeouts.Copy))
+	notNegative("minio.http.max-idle-conns", int64(c.Minio.HTTP.MaxIdleConns))
+	notNegative("minio.http.max-idle-conns-per-host", int64(c.Minio.HTTP.MaxIdleConnsPerHost))
+	notNegative("minio.http.max-conns-per-host", int64(c.Minio.HTTP.MaxConnsPerHost))
+	notNegative("minio.http.idle-conn-timeout", int64(c.Minio.HTTP.IdleConnTimeout))
+	notNegative("minio.http.dial-timeout", int64(c.Minio.HTTP.DialTimeout))
+	notNegative("minio.http.tls-handshake-timeout", int64(c.Minio.HTTP.TLSHandshakeTimeout))
+	notNegative("minio.http.response-header-timeout", int64(c.Minio.HTTP.ResponseHeaderTimeout))
+
+	port("db.port", c.DB.Port)
+	notNegative("db.query-timeout", int64(c.DB.QueryTimeout))
+
+	if c.RateLimit.Rate <= 0 {
+		invalid("ratelimit.rate", "must be positive, got %v", c.RateLimit.Rate)
+	}
+	positive("ratelimit.burst", int64(c.RateLimit.Burst))
+	for _, origin := range c.CORS.AllowOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			invalid("cors.allow-origins", "%q is neither \"*\" nor an http(s) origin", origin)
+		}
+	}
+
+	notNegative("upload.max-size", int64(c.Upload.MaxSize))
+	positive("upload.parallelism", int64(c.Upload.Parallelism))
+	if c.Upload.PartSize < s3part.MinSize || c.Upload.PartSize > s3part.MaxSize {
+		invalid("upload.part-size", "must be between %d and %d, got %d", s3part.MinSize, s3part.MaxSize, c.Upload.PartSize)
+	}
+	positive("upload.memory-budget", int64(c.Upload.MemoryBudget))
+	positive("upload.max-streams", int64(c.Upload.MaxStreams))
+	positive("tus.expiration", int64(c.Tus.Expiration))
+	positive("s3gateway.max-part-size", int64(c.S3Gateway.MaxPartSize))
+
+	oneOf("compression.codec", c.Compression.Codec, "gzip", "zstd")
+	notNegative("compression.min-size", int64(c.Compression.MinSize))
+	notNegative("archive.max-entries", int64(c.Archive.MaxEntries))
+	notNegative("archive.max-entry-size", int64(c.Archive.MaxEntrySize))
+	notNegative("archive.max-total-size", int64(c.Archive.MaxTotalSize))
+
+	positive("janitor.interval", int64(c.Janitor.Interval))
+	positive("janitor.max-age", int64(c.Janitor.MaxAge))
+	positive("health.timeout", int64(c.Health.Timeout))
+	notNegative("health.min-free-disk", int64(c.Health.MinFreeDisk))
+	notNegative("health.max-memory", int64(c.Health.MaxMemory))
+	positive("shutdown.drain-timeout", int64(c.Shutdown.DrainTimeout))
+	positive("shutdown.abort-timeout", int64(c.Shutdown.AbortTimeout))
+
+	oneOf("tracing.exporter", c.Tracing.Exporter, "none", "otlp", "file")
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		invalid("tracing.sample-ratio", "must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	}
+
+	if _, err := logrus.ParseLevel(c.Logging.Level); err != nil {
+		invalid("logging.level", "%v", err)
+	}
+	for _, sink := range c.Logging.Sinks {
+		oneOf("logging.sinks", sink, "stdout", "file", "logdoc")
+	}
+	oneOf("logging.format", c.Logging.Format, "json", "text")
+	port("ld.port", c.LD.Port)
+
+	return errs
+}
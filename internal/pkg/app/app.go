@@ -1,57 +1,94 @@
 package app
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"demo-storage/internal/app/endpoint/buckets"
+	"demo-storage/internal/app/endpoint/dav"
 	"demo-storage/internal/app/endpoint/download"
+	"demo-storage/internal/app/endpoint/health"
 	"demo-storage/internal/app/endpoint/objects"
+	"demo-storage/internal/app/endpoint/reconcile"
+	"demo-storage/internal/app/endpoint/reload"
 	"demo-storage/internal/app/endpoint/root"
+	"demo-storage/internal/app/endpoint/s3gw"
 	"demo-storage/internal/app/endpoint/status"
+	"demo-storage/internal/app/endpoint/tus"
+	"demo-storage/internal/app/endpoint/upload"
 	wsupload "demo-storage/internal/app/endpoint/upload/multipartws"
+	"demo-storage/internal/app/janitor"
 	"demo-storage/internal/app/mv"
 	minio "demo-storage/internal/app/service"
+	"demo-storage/internal/config"
+	"demo-storage/internal/pkg/budget"
+	"demo-storage/internal/pkg/drain"
 
 	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
-	"github.com/gurkankaymak/hocon"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo-contrib/echoprometheus"
 	"github.com/labstack/echo-contrib/pprof"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/sirupsen/logrus"
 )
 
 type App struct {
-	port     string
-	access   string
-	secret   string
-	db       *sqlx.DB
-	config   *hocon.Config
-	Echo     *echo.Echo
-	root     *root.Endpoint
-	status   *status.Endpoint
-	wsupload *wsupload.Endpoint
-	download *download.Endpoint
-	buckets  *buckets.Endpoint
-	objects  *objects.Endpoint
-	s        *minio.MinioService
+	port      string
+	db        *sqlx.DB
+	conf      *config.Config
+	Echo      *echo.Echo
+	root      *root.Endpoint
+	status    *status.Endpoint
+	health    *health.Endpoint
+	wsupload  *wsupload.Endpoint
+	upload    *upload.Endpoint
+	tus       *tus.Endpoint
+	s3gw      *s3gw.Endpoint
+	dav       *dav.Endpoint
+	download  *download.Endpoint
+	buckets   *buckets.Endpoint
+	objects   *objects.Endpoint
+	reconcile *reconcile.Endpoint
+	reload    *reload.Endpoint
+	s         *minio.MinioService
+	janitor   *janitor.Janitor
+	drain     *drain.Drainer
 }
 
-func New(config *hocon.Config, port string, access string, secret string, db *sqlx.DB) (*App, error) {
-	a := App{port: port, config: config, access: access, secret: secret, db: db}
+func New(conf *config.Config, port string, db *sqlx.DB, client *s3.S3) (*App, error) {
+	a := App{port: port, conf: conf, db: db}
 
-	a.s = minio.New(config, access, secret, db)
+	a.s = minio.New(conf, db, client)
+	a.janitor = janitor.New(conf.Janitor, a.s)
+	// учет активных загрузок для остановки без их обрыва
+	a.drain = drain.New()
+	// бюджет памяти под части загрузок, общий для websocket и tus
+	mem := budget.New(int64(conf.Upload.MemoryBudget))
 
 	a.root = root.New()
-	a.status = status.New(db)
+	a.status = status.New(db, conf)
+	a.health = health.New(a.s, db, a.drain, conf)
 	a.download = download.New(a.s)
 	a.buckets = buckets.New(a.s)
 	a.objects = objects.New(a.s)
+	a.reconcile = reconcile.New(a.s)
+	a.reload = reload.New(a.Reload)
 
 	// multipart upload using websockets
-	a.wsupload = wsupload.New(a.s, config)
+	a.wsupload = wsupload.New(a.s, conf, mem, a.drain)
+	// multipart/form-data upload для клиентов без websocket
+	a.upload = upload.New(a.s)
+	// resumable upload по протоколу tus 1.0
+	a.tus = tus.New(a.s, conf, mem)
+	// S3 совместимый шлюз для aws cli, rclone, s3fs
+	a.s3gw = s3gw.New(a.s, db, conf)
+	// WebDAV для сетевых дисков Windows, macOS Finder, davfs2
+	a.dav = dav.New(a.s, db, conf)
 
 	// Echo instance
 	a.Echo = echo.New()
@@ -63,18 +100,28 @@ func New(config *hocon.Config, port string, access string, secret string, db *sq
 	// Вызов перед каждым обработчиком
 	// В них может быть логгирование,
 	// поверка токенов, ролей, прав и многое другое
+	// Спан OpenTelemetry и идентификатор на каждый запрос
+	a.Echo.Use(mv.Tracing())
+	a.Echo.Use(mv.RequestID())
+	a.Echo.HTTPErrorHandler = mv.ErrorHandler
 	a.Echo.Use(middleware.Logger())
 	a.Echo.Use(middleware.Recover())
 	a.Echo.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
-		AllowMethods: []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE},
+		// cors.allow-origins перечитывается без перезапуска
+		AllowOriginFunc: mv.AllowOrigin,
+		AllowMethods:    []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE},
+		// браузерным tus клиентам нужны заголовки протокола в ответах
+		ExposeHeaders: []string{echo.HeaderLocation, tus.HeaderResumable, tus.HeaderVersion, tus.HeaderExtension,
+			tus.HeaderMaxSize, tus.HeaderChecksumAlgorithm, tus.HeaderUploadOffset, tus.HeaderUploadLength,
+			tus.HeaderUploadMetadata, tus.HeaderUploadExpires},
 	}))
 
 	// Metrics middleware
 	a.Echo.Use(echoprometheus.NewMiddleware("storage_demo_storage"))
 	a.Echo.GET("/storage/metrics", echoprometheus.NewHandler())
 
-	a.Echo.Use(middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(20)))
+	// лимиты ratelimit перечитываются без перезапуска
+	a.Echo.Use(mv.RateLimit())
 
 	// Body dump mv captures the request and response payload and calls the registered handler.
 	// Generally used for debugging/logging purpose. Avoid using it if your request/response payload is huge e.g.
@@ -85,6 +132,9 @@ func New(config *hocon.Config, port string, access string, secret string, db *sq
 			Skipper: func(c echo.Context) bool {
 				return strings.Contains(c.Request().URL.Path, "/download") ||
 					strings.Contains(c.Request().URL.Path, "/storage/upload") ||
+					strings.Contains(c.Request().URL.Path, "/storage/tus") ||
+					strings.HasPrefix(c.Request().URL.Path, s3gw.Prefix+"/") ||
+					strings.HasPrefix(c.Request().URL.Path, dav.Prefix) ||
 					strings.Contains(c.Request().URL.Path, "/ws/upload")
 			},
 			Handler: func(c echo.Context, reqBody, resBody []byte) {
@@ -95,16 +145,50 @@ func New(config *hocon.Config, port string, access string, secret string, db *sq
 	// Routes
 	a.Echo.GET("/", a.root.RootHandler)
 	a.Echo.GET("/status", a.status.StatusHandler)
-	a.Echo.GET("/buckets", a.buckets.BucketsHandler, mv.HeaderCheck(config))
-	a.Echo.GET("/objects/list", a.objects.ObjectsHandler, mv.HeaderCheck(config))
+	a.Echo.GET("/healthz", a.health.LivenessHandler)
+	a.Echo.GET("/readyz", a.health.ReadinessHandler)
+	a.Echo.GET("/buckets", a.buckets.BucketsHandler, mv.HeaderCheck())
+	a.Echo.GET("/objects/list", a.objects.ObjectsHandler, mv.HeaderCheck())
 	a.Echo.GET("/download", a.download.DownloadHandler)
+	a.Echo.GET("/download/archive", a.download.ArchiveHandler)
 	a.Echo.GET("/ws/upload", a.wsupload.WebSocketUploadHandler)
+	a.Echo.POST("/storage/upload", a.upload.UploadHandler, mv.Drain(a.drain))
+
+	// tus
+	files := a.Echo.Group(tus.Path, a.tus.Resumable, mv.Drain(a.drain))
+	files.OPTIONS("", a.tus.OptionsHandler)
+	files.POST("", a.tus.CreateHandler)
+	files.OPTIONS("/:id", a.tus.OptionsHandler)
+	files.HEAD("/:id", a.tus.HeadHandler)
+	files.PATCH("/:id", a.tus.PatchHandler)
+	files.DELETE("/:id", a.tus.DeleteHandler)
+
+	// S3 gateway
+	if conf.S3Gateway.Enabled {
+		gw := a.Echo.Group(s3gw.Prefix, a.s3gw.Authenticate, mv.Drain(a.drain))
+		gw.Any("", a.s3gw.ServiceHandler)
+		gw.Any("/", a.s3gw.ServiceHandler)
+		gw.Any("/:bucket", a.s3gw.BucketHandler)
+		gw.Any("/:bucket/*", a.s3gw.ObjectHandler)
+	}
+
+	// WebDAV
+	if conf.WebDAV.Enabled {
+		a.Echo.Match(dav.Methods, dav.Prefix, a.dav.DavHandler, mv.Drain(a.drain))
+		a.Echo.Match(dav.Methods, dav.Prefix+"/*", a.dav.DavHandler, mv.Drain(a.drain))
+	}
+
+	// Admin
+	a.Echo.GET("/admin/reconcile", a.reconcile.ReportHandler, mv.HeaderCheck())
+	a.Echo.POST("/admin/reconcile", a.reconcile.FixHandler, mv.HeaderCheck())
+	a.Echo.POST("/admin/config/reload", a.reload.ReloadHandler, mv.HeaderCheck())
 
 	return &a, nil
 }
 
 func (a *App) Run() error {
 	logger := logdoc.GetLogger()
+	a.janitor.Start()
 	// Start server
 	err := a.Echo.Start(":" + a.port)
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -112,3 +196,50 @@ func (a *App) Run() error {
 	}
 	return nil
 }
+
+// Reload перечитывает конфигурацию: по SIGHUP и POST /admin/config/reload. Ключи из config.Reloadable
+// применяются сразу, об измененных остальных ключах сообщается в отчете и в логе
+func (a *App) Reload() (*config.Report, error) {
+	logger := logdoc.GetLogger()
+
+	report, err := config.Reload()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Configuration is not reloaded: %v", err))
+		return nil, err
+	}
+	if level, err := logrus.ParseLevel(config.GetConfig().Logging.Level); err == nil {
+		logger.SetLevel(level)
+	}
+
+	logger.Info(fmt.Sprintf("Configuration reloaded, applied: %v", report.Applied))
+	if len(report.RestartRequired) > 0 {
+		logger.Warn(fmt.Sprintf("Configuration changes that require restart: %v", report.RestartRequired))
+	}
+	return report, nil
+}
+
+// Shutdown останавливает сервис, не обрывая загрузки: /readyz отвечает 503, новые загрузки отклоняются,
+// websocket клиенты получают предупреждение, активные загрузки ждем shutdown.drain-timeout.
+// Не успевшие загрузки прерываются, multipart сессии websocket сохраняются для продолжения по tus,
+// на это и на закрытие остальных соединений дается shutdown.abort-timeout
+func (a *App) Shutdown() error {
+	logger := logdoc.GetLogger()
+
+	drainTimeout, abortTimeout := a.conf.Shutdown.DrainTimeout, a.conf.Shutdown.AbortTimeout
+
+	logger.Warn(fmt.Sprintf("Draining %d active uploads, waiting up to %s...", a.drain.Active(), drainTimeout))
+	if interrupted := a.drain.Drain(drainTimeout, abortTimeout); interrupted > 0 {
+		logger.Warn(fmt.Sprintf("%d uploads interrupted by shutdown", interrupted))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	err := a.Echo.Shutdown(ctx)
+	a.Close()
+	return err
+}
+
+// Close останавливает фоновые задачи приложения
+func (a *App) Close() {
+	a.janitor.Stop()
+}
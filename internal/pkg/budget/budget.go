@@ -0,0 +1,44 @@
+package budget
+
+import (
+	"context"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// Memory общий для всех загрузок бюджет памяти под части, ожидающие отправки в S3.
+// Один на процесс: websocket и tus загрузки занимают память из него же
+type Memory struct {
+	sem  *semaphore.Weighted
+	size int64
+}
+
+func New(size int64) *Memory {
+	return &Memory{sem: semaphore.NewWeighted(size), size: size}
+}
+
+// Acquire занимает память под буфер размера size, ждет, пока ее освободят другие загрузки.
+// Возвращает занятый вес, его передают в Release. Ожидание прерывается отменой ctx
+func (m *Memory) Acquire(ctx context.Context, size int) (int64, error) {
+	weight := m.weight(size)
+	if err := m.sem.Acquire(ctx, weight); err != nil {
+		return 0, err
+	}
+	return weight, nil
+}
+
+// Release возвращает в бюджет вес, занятый Acquire
+func (m *Memory) Release(weight int64) {
+	if weight > 0 {
+		m.sem.Release(weight)
+	}
+}
+
+// weight переводит размер буфера в вес для семафора.
+// Буфер больше всего бюджета занимает его целиком, иначе он не получит память никогда
+func (m *Memory) weight(size int) int64 {
+	if int64(size) > m.size {
+		return m.size
+	}
+	return int64(size)
+}
@@ -0,0 +1,87 @@
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"demo-storage/internal/config"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	NONE = ""
+	GZIP = "gzip"
+	ZSTD = "zstd"
+)
+
+// Policy описывает, какие файлы и каким кодеком сжимать перед отправкой в хранилище
+type Policy struct {
+	Enabled bool
+	Codec   string
+	MinSize int
+	Types   []string
+}
+
+func NewPolicy(conf config.Compression) *Policy {
+	return &Policy{
+		Enabled: conf.Enabled,
+		Codec:   conf.Codec,
+		MinSize: conf.MinSize,
+		Types:   conf.Types,
+	}
+}
+
+// CodecFor возвращает кодек для файла с указанным content type и размером,
+// либо NONE, если файл сжимать не нужно
+func (p *Policy) CodecFor(contentType string, size int) string {
+	if p == nil || !p.Enabled || size < p.MinSize {
+		return NONE
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return NONE
+	}
+	for _, t := range p.Types {
+		if strings.EqualFold(t, mediaType) {
+			return p.Codec
+		}
+		// поддерживаем шаблоны вида text/*
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+			return p.Codec
+		}
+	}
+	return NONE
+}
+
+// NewWriter оборачивает w сжимающим райтером для указанного кодека
+func NewWriter(codec string, w io.Writer) (io.WriteCloser, error) {
+	switch codec {
+	case GZIP:
+		return gzip.NewWriter(w), nil
+	case ZSTD:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported codec: %s", codec)
+	}
+}
+
+// NewReader оборачивает r распаковывающим ридером для указанного кодека
+func NewReader(codec string, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case NONE:
+		return io.NopCloser(r), nil
+	case GZIP:
+		return gzip.NewReader(r)
+	case ZSTD:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported codec: %s", codec)
+	}
+}
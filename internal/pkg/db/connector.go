@@ -3,29 +3,29 @@ package db
 import (
 	"fmt"
 
+	"demo-storage/internal/config"
 	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
-	"github.com/gurkankaymak/hocon"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
 
-func Connect(config *hocon.Config, dbPass string) *sqlx.DB {
+func Connect(conf config.DB) *sqlx.DB {
 	logger := logdoc.GetLogger()
 	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		config.GetString("db.host"),
-		config.GetString("db.port"),
-		config.GetString("db.user"),
-		dbPass,
-		config.GetString("db.name"),
-		config.GetString("db.ssl"),
+		conf.Host,
+		conf.Port,
+		conf.User,
+		conf.Password,
+		conf.Name,
+		conf.SSL,
 	)
-	db, err := sqlx.Connect(config.GetString("db.driver"), psqlInfo)
+	db, err := sqlx.Connect(conf.Driver, psqlInfo)
 	if err != nil {
-		logger.Fatal(fmt.Sprintf("Error connecting database: %s", config.GetString("db.name")))
+		logger.Fatal(fmt.Sprintf("Error connecting database: %s", conf.Name))
 	}
 	err = db.Ping()
 	if err != nil {
-		logger.Fatal(fmt.Sprintf("Error pinging database: %s", config.GetString("db.name")))
+		logger.Fatal(fmt.Sprintf("Error pinging database: %s", conf.Name))
 	}
 	return db
 }
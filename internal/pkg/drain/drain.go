@@ -0,0 +1,143 @@
+package drain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrDraining сервис останавливается и новые загрузки не принимает
+	ErrDraining = errors.New("service is shutting down")
+	// ErrInterrupted загрузка не успела завершиться до остановки сервиса
+	ErrInterrupted = errors.New("upload interrupted by service shutdown")
+)
+
+// RetryAfterSecs через сколько секунд клиенту повторить загрузку, отклоненную во время остановки
+const RetryAfterSecs = 30
+
+// Drainer учитывает активные сессии загрузки и останавливает сервис без обрыва загрузок:
+// Drain перестает принимать новые сессии, ждет активные до дедлайна и только потом прерывает оставшиеся
+type Drainer struct {
+	mu       sync.Mutex
+	draining bool
+	active   int
+	// idle закрывается, когда во время остановки не осталось активных сессий
+	idle   chan struct{}
+	hooks  map[int]func(deadline time.Time)
+	hookId int
+
+	interrupted context.Context
+	interrupt   context.CancelCauseFunc
+}
+
+func New() *Drainer {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	return &Drainer{
+		idle:        make(chan struct{}),
+		hooks:       map[int]func(time.Time){},
+		interrupted: ctx,
+		interrupt:   cancel,
+	}
+}
+
+// Begin регистрирует сессию загрузки, end вызывается по ее окончании.
+// ok == false, если сервис уже останавливается
+func (d *Drainer) Begin() (end func(), ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return nil, false
+	}
+	d.active++
+	var once sync.Once
+	return func() { once.Do(d.end) }, true
+}
+
+func (d *Drainer) end() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.active--
+	if d.draining && d.active == 0 {
+		close(d.idle)
+	}
+}
+
+// Draining сервис останавливается
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Active количество активных сессий загрузки
+func (d *Drainer) Active() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.active
+}
+
+// Interrupted отменяется с причиной ErrInterrupted, когда активные сессии не успели завершиться
+// до дедлайна. Сессия должна прерваться и сохранить состояние, по которому клиент продолжит загрузку
+func (d *Drainer) Interrupted() context.Context {
+	return d.interrupted
+}
+
+// OnDrain вызывает f в начале остановки с дедлайном, до которого ждут активные сессии,
+// например чтобы предупредить подключенных клиентов. remove отменяет подписку
+func (d *Drainer) OnDrain(f func(deadline time.Time)) (remove func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hookId++
+	id := d.hookId
+	d.hooks[id] = f
+	return func() {
+		d.mu.Lock()
+		delete(d.hooks, id)
+		d.mu.Unlock()
+	}
+}
+
+// Drain перестает принимать новые сессии и ждет активные не дольше timeout. Оставшиеся сессии
+// прерываются через Interrupted, на сохранение их состояния дается grace. Возвращает число прерванных сессий
+func (d *Drainer) Drain(timeout time.Duration, grace time.Duration) int {
+	deadline := time.Now().Add(timeout)
+
+	d.mu.Lock()
+	if d.draining {
+		d.mu.Unlock()
+		return 0
+	}
+	d.draining = true
+	if d.active == 0 {
+		close(d.idle)
+	}
+	hooks := make([]func(time.Time), 0, len(d.hooks))
+	for _, f := range d.hooks {
+		hooks = append(hooks, f)
+	}
+	d.mu.Unlock()
+
+	// подписчики пишут медленным клиентам, остановку они задерживать не должны
+	for _, f := range hooks {
+		go f(deadline)
+	}
+
+	wait := time.NewTimer(timeout)
+	defer wait.Stop()
+	select {
+	case <-d.idle:
+		return 0
+	case <-wait.C:
+	}
+
+	interrupted := d.Active()
+	d.interrupt(ErrInterrupted)
+	wait.Reset(grace)
+	select {
+	case <-d.idle:
+	case <-wait.C:
+	}
+	return interrupted
+}
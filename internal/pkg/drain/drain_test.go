@@ -0,0 +1,151 @@
+package drain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// session сессия загрузки для теста: завершается через after или, если stops, по прерыванию
+type session struct {
+	after time.Duration
+	stops bool
+}
+
+func TestDrain(t *testing.T) {
+	const long = time.Hour
+	tests := []struct {
+		name        string
+		sessions    []session
+		timeout     time.Duration
+		grace       time.Duration
+		interrupted int
+		// minElapsed и maxElapsed в каких пределах Drain ждет сессии
+		minElapsed time.Duration
+		maxElapsed time.Duration
+	}{
+		{
+			name:       "no sessions",
+			timeout:    long,
+			grace:      long,
+			maxElapsed: time.Second,
+		},
+		{
+			name:       "sessions finish before deadline",
+			sessions:   []session{{after: 10 * time.Millisecond}, {after: 30 * time.Millisecond}},
+			timeout:    long,
+			grace:      long,
+			minElapsed: 30 * time.Millisecond,
+			maxElapsed: time.Second,
+		},
+		{
+			name:        "session stops on interruption",
+			sessions:    []session{{after: long, stops: true}},
+			timeout:     50 * time.Millisecond,
+			grace:       long,
+			interrupted: 1,
+			minElapsed:  50 * time.Millisecond,
+			maxElapsed:  time.Second,
+		},
+		{
+			name:        "grace bounds sessions ignoring interruption",
+			sessions:    []session{{after: long}},
+			timeout:     50 * time.Millisecond,
+			grace:       50 * time.Millisecond,
+			interrupted: 1,
+			minElapsed:  100 * time.Millisecond,
+			maxElapsed:  time.Second,
+		},
+		{
+			name:        "only late sessions are interrupted",
+			sessions:    []session{{after: 10 * time.Millisecond}, {after: long, stops: true}, {after: long, stops: true}},
+			timeout:     50 * time.Millisecond,
+			grace:       long,
+			interrupted: 2,
+			minElapsed:  50 * time.Millisecond,
+			maxElapsed:  time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New()
+			for _, s := range tt.sessions {
+				end, ok := d.Begin()
+				if !ok {
+					t.Fatal("session rejected before drain")
+				}
+				go func(s session) {
+					defer end()
+					var stop <-chan struct{}
+					if s.stops {
+						stop = d.Interrupted().Done()
+					}
+					select {
+					case <-time.After(s.after):
+					case <-stop:
+					}
+				}(s)
+			}
+
+			started := time.Now()
+			got := d.Drain(tt.timeout, tt.grace)
+			elapsed := time.Since(started)
+
+			if got != tt.interrupted {
+				t.Errorf("interrupted %d sessions, want %d", got, tt.interrupted)
+			}
+			if elapsed < tt.minElapsed || elapsed > tt.maxElapsed {
+				t.Errorf("drain took %s, want between %s and %s", elapsed, tt.minElapsed, tt.maxElapsed)
+			}
+			cause := context.Cause(d.Interrupted())
+			if tt.interrupted > 0 && !errors.Is(cause, ErrInterrupted) {
+				t.Errorf("interruption cause %v, want %v", cause, ErrInterrupted)
+			}
+			if tt.interrupted == 0 && cause != nil {
+				t.Errorf("sessions interrupted with %v, want no interruption", cause)
+			}
+			if _, ok := d.Begin(); ok {
+				t.Error("session accepted after drain")
+			}
+		})
+	}
+}
+
+func TestDrainHooks(t *testing.T) {
+	d := New()
+	deadlines := make(chan time.Time, 2)
+	d.OnDrain(func(deadline time.Time) { deadlines <- deadline })
+	remove := d.OnDrain(func(time.Time) { t.Error("removed hook called") })
+	remove()
+
+	const timeout = 50 * time.Millisecond
+	end, _ := d.Begin()
+	// повторный end не уменьшает счетчик второй раз
+	end()
+	end()
+	if active := d.Active(); active != 0 {
+		t.Fatalf("%d active sessions, want 0", active)
+	}
+
+	started := time.Now()
+	d.Drain(timeout, timeout)
+	select {
+	case deadline := <-deadlines:
+		if want := started.Add(timeout); deadline.Before(want.Add(-10*time.Millisecond)) || deadline.After(want.Add(10*time.Millisecond)) {
+			t.Errorf("hook deadline %s, want about %s", deadline, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("hook not called")
+	}
+
+	// повторная остановка ничего не делает и подписчиков не зовет
+	if got := d.Drain(timeout, timeout); got != 0 {
+		t.Errorf("second drain interrupted %d sessions", got)
+	}
+	select {
+	case <-deadlines:
+		t.Error("hook called on second drain")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
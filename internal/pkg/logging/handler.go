@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handlerSink передает записи общего логгера в slog.Handler: JSON или текст в stdout, JSON в файл
+type handlerSink struct {
+	h slog.Handler
+}
+
+func newHandlerSink(w io.Writer, format string) (*handlerSink, error) {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	switch format {
+	case "", FormatJSON:
+		return &handlerSink{h: slog.NewJSONHandler(w, opts)}, nil
+	case FormatText:
+		return &handlerSink{h: slog.NewTextHandler(w, opts)}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+func (s *handlerSink) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (s *handlerSink) Fire(entry *logrus.Entry) error {
+	r := slog.NewRecord(entry.Time, slogLevel(entry.Level), entry.Message, 0)
+	if entry.Caller != nil {
+		r.AddAttrs(slog.String("source", fmt.Sprintf("%s:%d", path.Base(entry.Caller.File), entry.Caller.Line)))
+	}
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := entry.Data[k]
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		r.AddAttrs(slog.Any(k, v))
+	}
+
+	ctx := entry.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return s.h.Handle(ctx, r)
+}
+
+func slogLevel(level logrus.Level) slog.Level {
+	switch level {
+	case logrus.TraceLevel, logrus.DebugLevel:
+		return slog.LevelDebug
+	case logrus.InfoLevel:
+		return slog.LevelInfo
+	case logrus.WarnLevel:
+		return slog.LevelWarn
+	case logrus.ErrorLevel:
+		return slog.LevelError
+	default:
+		// fatal и panic
+		return slog.LevelError + 4
+	}
+}
@@ -1,19 +1,216 @@
 package logging
 
 import (
+	"errors"
+	"fmt"
 	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"demo-storage/internal/config"
-	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
+	"github.com/SandQuattro/logdoc-go-appender/common"
+	"github.com/sirupsen/logrus"
 )
 
-func LDSubsystemInit() (*net.Conn, error) {
-	conf := config.GetConfig()
-	conn, err := logdoc.Init(
-		conf.GetString("ld.proto"),
-		conf.GetString("ld.host")+":"+conf.GetString("ld.port"),
-		conf.GetString("ld.app"),
-		logdoc.TEXT,
-	)
-	return &conn, err
+const (
+	ldDialTimeout  = 2 * time.Second
+	ldWriteTimeout = 2 * time.Second
+	// ldRetryDelay пауза между попытками переподключиться к упавшему коллектору
+	ldRetryDelay = 10 * time.Second
+	// ldQueueSize сколько записей ждут отправки, пока коллектор отвечает медленно
+	ldQueueSize = 1024
+)
+
+var errLDNotConnected = errors.New("LogDoc collector is not connected")
+
+// ld приемник LogDoc, nil если он не настроен
+var ld *ldSink
+
+// ldSink отправляет записи в коллектор LogDoc в его текстовом формате. Fire только ставит запись в очередь,
+// соединяется и пишет одна горутина, поэтому медленный коллектор не задерживает логирующий код.
+// Если очередь полна или коллектор недоступен, записи уходят в fallback, а переподключение
+// пробуется не чаще раза в ldRetryDelay. Ошибки отправки в общий логгер не пишутся: это снова привело бы в этот же приемник
+type ldSink struct {
+	proto    string
+	address  string
+	app      string
+	fallback logrus.Hook
+
+	entries chan *logrus.Entry
+	quit    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+
+	// conn и retryAt трогает только горутина отправки, mu защищает их для LDStatus и Close
+	mu      sync.Mutex
+	conn    net.Conn
+	err     error
+	retryAt time.Time
+}
+
+func newLDSink(conf config.LD, fallback logrus.Hook) *ldSink {
+	s := &ldSink{
+		proto:    conf.Proto,
+		address:  conf.Host + ":" + conf.Port,
+		app:      conf.App,
+		fallback: fallback,
+		entries:  make(chan *logrus.Entry, ldQueueSize),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	// первое подключение синхронно, чтобы LDStatus сразу после старта показывал состояние коллектора
+	s.connect()
+	go s.run()
+	return s
+}
+
+func (s *ldSink) run() {
+	defer close(s.done)
+	for {
+		select {
+		case entry := <-s.entries:
+			s.send(entry)
+		case <-s.quit:
+			// дописываем то, что успели поставить в очередь до Close
+			for {
+				select {
+				case entry := <-s.entries:
+					s.send(entry)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// connect вызывается только из горутины отправки и при создании, mu на время соединения не держится
+func (s *ldSink) connect() net.Conn {
+	s.mu.Lock()
+	conn, retryAt := s.conn, s.retryAt
+	s.mu.Unlock()
+	if conn != nil || time.Now().Before(retryAt) {
+		return conn
+	}
+
+	conn, err := net.DialTimeout(s.proto, s.address, ldDialTimeout)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.err = err
+		s.retryAt = time.Now().Add(ldRetryDelay)
+		return nil
+	}
+	s.conn, s.err = conn, nil
+	return conn
+}
+
+func (s *ldSink) send(entry *logrus.Entry) {
+	if conn := s.connect(); conn != nil {
+		_ = conn.SetWriteDeadline(time.Now().Add(ldWriteTimeout))
+		_, err := conn.Write(s.message(conn, entry))
+		if err == nil {
+			return
+		}
+		conn.Close()
+		s.mu.Lock()
+		s.conn, s.err = nil, err
+		s.retryAt = time.Now().Add(ldRetryDelay)
+		s.mu.Unlock()
+	}
+	if s.fallback != nil {
+		_ = s.fallback.Fire(entry)
+	}
+}
+
+func (s *ldSink) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (s *ldSink) Fire(entry *logrus.Entry) error {
+	select {
+	case <-s.quit:
+	default:
+		select {
+		case s.entries <- entry:
+			return nil
+		default:
+		}
+	}
+	// очередь полна или приемник закрыт: запись не теряем, если есть куда ее отдать
+	if s.fallback != nil {
+		return s.fallback.Fire(entry)
+	}
+	return nil
+}
+
+// message запись в формате LogDoc: поля записи передаются парами, как и служебные
+func (s *ldSink) message(conn net.Conn, entry *logrus.Entry) []byte {
+	lvl := entry.Level.String()
+	if entry.Level == logrus.WarnLevel {
+		lvl = "warn"
+	}
+	src := ""
+	if entry.Caller != nil {
+		src = entry.Caller.Function + ":" + strconv.Itoa(entry.Caller.Line)
+	}
+
+	result := []byte{6, 3}
+	common.WritePair("msg", entry.Message, &result)
+	common.ProcessCustomFields(entry.Message, &result)
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		common.WritePair(k, fmt.Sprint(entry.Data[k]), &result)
+	}
+	common.WritePair("app", s.app, &result)
+	common.WritePair("tsrc", entry.Time.Format("060201150405.000")+"\n", &result)
+	common.WritePair("lvl", lvl, &result)
+	common.WritePair("ip", conn.RemoteAddr().String(), &result)
+	common.WritePair("pid", strconv.Itoa(os.Getpid()), &result)
+	common.WritePair("src", src, &result)
+	return append(result, '\n')
+}
+
+// Close дожидается отправки записей из очереди и закрывает соединение
+func (s *ldSink) Close() error {
+	s.once.Do(func() { close(s.quit) })
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// LDStatus состояние приемника LogDoc для проверки готовности: configured - LogDoc есть в logging.sinks,
+// err - последняя ошибка соединения с коллектором
+func LDStatus() (configured bool, err error) {
+	if ld == nil {
+		return false, nil
+	}
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	if ld.conn != nil {
+		return true, nil
+	}
+	if ld.err != nil {
+		return true, fmt.Errorf("%w: %v", errLDNotConnected, ld.err)
+	}
+	return true, errLDNotConnected
+}
+
+// String адрес коллектора для сообщений о переключении на fallback
+func (s *ldSink) String() string {
+	return s.proto + "://" + s.address
 }
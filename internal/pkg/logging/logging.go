@@ -0,0 +1,164 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"demo-storage/internal/config"
+	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Приемники логов, logging.sinks
+const (
+	SinkStdout = "stdout"
+	SinkFile   = "file"
+	SinkLogDoc = "logdoc"
+)
+
+// Форматы stdout, logging.format. Файл всегда пишется в JSON
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Поля структурных логов
+const (
+	FieldRequestID = "request_id"
+	FieldFile      = "file"
+	FieldUser      = "user"
+	FieldSession   = "upload_session"
+)
+
+// Init настраивает общий логгер logdoc.GetLogger по секции logging: уровень и приемники.
+// Сам логгер ничего не пишет, записи расходятся по приемникам. Недоступный LogDoc не мешает запуску:
+// пока коллектор лежит, его записи идут в stdout. Возвращает функцию, закрывающую приемники
+func Init(conf *config.Config) (func(), error) {
+	log := logdoc.GetLogger()
+
+	level, err := logrus.ParseLevel(conf.Logging.Level)
+	if err != nil {
+		return nil, err
+	}
+	sinks, format := conf.Logging.Sinks, conf.Logging.Format
+
+	hooks := logrus.LevelHooks{}
+	var closers []io.Closer
+	closeAll := func() {
+		for _, c := range closers {
+			_ = c.Close()
+		}
+	}
+	for _, name := range sinks {
+		switch name {
+		case SinkStdout:
+			sink, err := newHandlerSink(os.Stdout, format)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			hooks.Add(sink)
+		case SinkFile:
+			file := &lumberjack.Logger{
+				Filename:   conf.Logging.File.Path,
+				MaxSize:    conf.Logging.File.MaxSizeMB,
+				MaxBackups: conf.Logging.File.MaxBackups,
+				MaxAge:     conf.Logging.File.MaxAgeDays,
+				Compress:   conf.Logging.File.Compress,
+			}
+			sink, _ := newHandlerSink(file, FormatJSON)
+			hooks.Add(sink)
+			closers = append(closers, file)
+		case SinkLogDoc:
+			var fallback logrus.Hook
+			if !slices.Contains(sinks, SinkStdout) {
+				sink, err := newHandlerSink(os.Stdout, format)
+				if err != nil {
+					closeAll()
+					return nil, err
+				}
+				fallback = sink
+			}
+			ld = newLDSink(conf.LD, fallback)
+			hooks.Add(ld)
+			closers = append(closers, ld)
+		default:
+			closeAll()
+			return nil, fmt.Errorf("unknown logging sink %q", name)
+		}
+	}
+
+	log.SetLevel(level)
+	log.SetReportCaller(true)
+	log.SetFormatter(discardFormatter{})
+	log.SetOutput(io.Discard)
+	log.ReplaceHooks(hooks)
+
+	if ld != nil {
+		if _, err := LDStatus(); err != nil {
+			log.Warn(fmt.Sprintf("LogDoc collector %s is unreachable, logging to stdout until it is back: %v", ld, err))
+		}
+	}
+	return closeAll, nil
+}
+
+// discardFormatter записи форматируют приемники, вывод самого логгера отключен
+type discardFormatter struct{}
+
+func (discardFormatter) Format(*logrus.Entry) ([]byte, error) {
+	return nil, nil
+}
+
+type fieldsKey struct{}
+
+// WithFields добавляет к контексту поля, которые FromContext пишет в каждую запись
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	merged := logrus.Fields{}
+	if prev, ok := ctx.Value(fieldsKey{}).(logrus.Fields); ok {
+		for k, v := range prev {
+			merged[k] = v
+		}
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// FromContext общий логгер с полями контекста
+func FromContext(ctx context.Context) *logrus.Entry {
+	entry := logdoc.GetLogger().WithContext(ctx)
+	if fields, ok := ctx.Value(fieldsKey{}).(logrus.Fields); ok {
+		entry = entry.WithFields(fields)
+	}
+	return entry
+}
+
+// RequestID идентификатор запроса из полей контекста, пустой вне HTTP запроса
+func RequestID(ctx context.Context) string {
+	return field(ctx, FieldRequestID)
+}
+
+// SessionID идентификатор сессии загрузки из полей контекста
+func SessionID(ctx context.Context) string {
+	return field(ctx, FieldSession)
+}
+
+func field(ctx context.Context, key string) string {
+	fields, _ := ctx.Value(fieldsKey{}).(logrus.Fields)
+	s, _ := fields[key].(string)
+	return s
+}
+
+// NewSessionID идентификатор сессии загрузки для поля FieldSession
+func NewSessionID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,324 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+// lockKey ключ pg_advisory_lock, чтобы несколько экземпляров сервиса не мигрировали базу одновременно
+const lockKey = 7_463_001
+
+var fileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int        `db:"version"`
+	Name      string     `db:"name"`
+	AppliedAt *time.Time `db:"applied_at"`
+}
+
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+// New создает мигратор со встроенными в бинарник миграциями
+func New(db *sqlx.DB) (*Migrator, error) {
+	sub, err := fs.Sub(embedded, "sql")
+	if err != nil {
+		return nil, err
+	}
+	return NewFromFS(db, sub)
+}
+
+// NewFromFS создает мигратор с миграциями из произвольной файловой системы
+func NewFromFS(db *sqlx.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		m := fileRe.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || m == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Latest возвращает номер последней известной миграции
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up применяет все еще не примененные миграции
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Down откатывает steps последних примененных миграций. Какие миграции применены, берется из schema_migrations:
+// пропущенная миграция не откатывается, а если примененных меньше steps, база не меняется
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		plan, err := m.planDown(applied, steps)
+		if err != nil {
+			return err
+		}
+		return m.run(ctx, conn, plan)
+	})
+}
+
+// To мигрирует базу вверх или вниз до указанной версии, 0 откатывает все миграции
+func (m *Migrator) To(ctx context.Context, version int) error {
+	if version != 0 && !m.known(version) {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	return m.withLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		return m.run(ctx, conn, m.planTo(applied, version))
+	})
+}
+
+// step миграция и направление, в котором ее применить
+type step struct {
+	migration Migration
+	up        bool
+}
+
+// planTo шаги до версии version при примененных миграциях applied. Вверх применяются известные миграции
+// после последней примененной, вниз откатываются примененные миграции выше version, от новых к старым
+func (m *Migrator) planTo(applied map[int]bool, version int) []step {
+	current := 0
+	for v := range applied {
+		current = max(current, v)
+	}
+
+	var plan []step
+	if version >= current {
+		for _, migration := range m.migrations {
+			if migration.Version > current && migration.Version <= version {
+				plan = append(plan, step{migration: migration, up: true})
+			}
+		}
+		return plan
+	}
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if applied[migration.Version] && migration.Version > version {
+			plan = append(plan, step{migration: migration})
+		}
+	}
+	return plan
+}
+
+// planDown шаги отката steps последних примененных миграций
+func (m *Migrator) planDown(applied map[int]bool, steps int) ([]step, error) {
+	if len(applied) < steps {
+		return nil, fmt.Errorf("cannot roll back %d migrations, only %d applied", steps, len(applied))
+	}
+	var plan []step
+	for i := len(m.migrations) - 1; i >= 0 && len(plan) < steps; i-- {
+		if applied[m.migrations[i].Version] {
+			plan = append(plan, step{migration: m.migrations[i]})
+		}
+	}
+	return plan, nil
+}
+
+func (m *Migrator) run(ctx context.Context, conn *sqlx.Conn, plan []step) error {
+	for _, s := range plan {
+		if err := m.apply(ctx, conn, s.migration, s.up); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Status возвращает все известные миграции с отметкой о применении
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	if err := m.ensureTable(ctx, m.db); err != nil {
+		return nil, err
+	}
+
+	var applied []Status
+	err := m.db.SelectContext(ctx, &applied, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	appliedAt := map[int]*time.Time{}
+	for _, a := range applied {
+		appliedAt[a.Version] = a.AppliedAt
+	}
+
+	res := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		res = append(res, Status{Version: migration.Version, Name: migration.Name, AppliedAt: appliedAt[migration.Version]})
+	}
+	return res, nil
+}
+
+func (m *Migrator) known(version int) bool {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sqlx.Conn) error) error {
+	// advisory lock живет в рамках сессии, поэтому все делаем на одном соединении
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("acquire migrations lock: %w", err)
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+	}()
+
+	if err = m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, db sqlx.ExecerContext) error {
+	_, err := db.ExecContext(ctx, `create table if not exists schema_migrations
+(
+    version    bigint      not null constraint schema_migrations_pk primary key,
+    name       text        not null,
+    applied_at timestamptz not null default now()
+)`)
+	return err
+}
+
+// applied версии из schema_migrations. Примененная миграция, которой нет среди известных, - ошибка:
+// откатить ее нечем, а откат более ранних оставил бы базу в неизвестном состоянии
+func (m *Migrator) applied(ctx context.Context, conn *sqlx.Conn) (map[int]bool, error) {
+	var versions []int
+	if err := conn.SelectContext(ctx, &versions, `SELECT version FROM schema_migrations`); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool, len(versions))
+	for _, version := range versions {
+		if !m.known(version) {
+			return nil, fmt.Errorf("applied migration %d is unknown", version)
+		}
+		applied[version] = true
+	}
+	return applied, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sqlx.Conn, migration Migration, up bool) error {
+	logger := logdoc.GetLogger()
+
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	direction, body := "up", migration.Up
+	if !up {
+		direction, body = "down", migration.Down
+	}
+
+	if _, err = tx.ExecContext(ctx, body); err != nil {
+		return fmt.Errorf("migration %d_%s %s: %w", migration.Version, migration.Name, direction, err)
+	}
+	if up {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations(version, name) values ($1, $2)`, migration.Version, migration.Name)
+	} else {
+		var res sql.Result
+		res, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations where version = $1`, migration.Version)
+		if err == nil {
+			if n, _ := res.RowsAffected(); n != 1 {
+				err = fmt.Errorf("migration %d_%s is not applied", migration.Version, migration.Name)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	logger.Info(fmt.Sprintf(">> Migration %d_%s applied (%s)", migration.Version, migration.Name, direction))
+	return nil
+}
@@ -0,0 +1,152 @@
+package migrations
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// migrationsFS миграции с версиями versions, у каждой есть up и down
+func migrationsFS(versions ...string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, v := range versions {
+		fsys[v+"_m.up.sql"] = &fstest.MapFile{Data: []byte("select 1")}
+		fsys[v+"_m.down.sql"] = &fstest.MapFile{Data: []byte("select 1")}
+	}
+	return fsys
+}
+
+func newMigrator(t *testing.T) *Migrator {
+	t.Helper()
+	// версии намеренно не по порядку и с пропуском: порядок задает номер, а не имя файла
+	m, err := NewFromFS(nil, migrationsFS("0003", "0001", "0010", "0002"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func appliedSet(versions ...int) map[int]bool {
+	applied := map[int]bool{}
+	for _, v := range versions {
+		applied[v] = true
+	}
+	return applied
+}
+
+// planned шаги в виде +версия для up и -версия для down
+func planned(plan []step) []int {
+	var res []int
+	for _, s := range plan {
+		if s.up {
+			res = append(res, s.migration.Version)
+		} else {
+			res = append(res, -s.migration.Version)
+		}
+	}
+	return res
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{
+			name: "missing down",
+			fsys: fstest.MapFS{"0001_init.up.sql": &fstest.MapFile{Data: []byte("select 1")}},
+			want: "migration 1_init must have both up and down files",
+		},
+		{
+			name: "different names",
+			fsys: fstest.MapFS{
+				"0001_init.up.sql":    &fstest.MapFile{Data: []byte("select 1")},
+				"0001_start.down.sql": &fstest.MapFile{Data: []byte("select 1")},
+			},
+			want: "migration 1 has different names",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(tt.fsys)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	// файлы не по шаблону имени пропускаются
+	fsys := migrationsFS("0002", "0001")
+	fsys["README.md"] = &fstest.MapFile{Data: []byte("docs")}
+	migrations, err := load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	if !slices.Equal(versions, []int{1, 2}) {
+		t.Errorf("versions %v, want [1 2]", versions)
+	}
+}
+
+func TestPlanTo(t *testing.T) {
+	m := newMigrator(t)
+	tests := []struct {
+		name    string
+		applied []int
+		version int
+		want    []int
+	}{
+		{name: "empty database to latest", version: m.Latest(), want: []int{1, 2, 3, 10}},
+		{name: "empty database to middle", version: 2, want: []int{1, 2}},
+		{name: "up from current", applied: []int{1, 2}, version: 10, want: []int{3, 10}},
+		{name: "already at version", applied: []int{1, 2, 3}, version: 3},
+		{name: "down to version", applied: []int{1, 2, 3, 10}, version: 2, want: []int{-10, -3}},
+		{name: "down to zero", applied: []int{1, 2, 3}, version: 0, want: []int{-3, -2, -1}},
+		// пропущенная миграция не откатывается
+		{name: "down skips not applied", applied: []int{1, 3, 10}, version: 1, want: []int{-10, -3}},
+		// вверх применяются только миграции после последней примененной
+		{name: "up ignores gaps below current", applied: []int{1, 3}, version: 10, want: []int{10}},
+		{name: "zero on empty database", version: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := planned(m.planTo(appliedSet(tt.applied...), tt.version)); !slices.Equal(got, tt.want) {
+				t.Errorf("plan %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanDown(t *testing.T) {
+	m := newMigrator(t)
+	tests := []struct {
+		name    string
+		applied []int
+		steps   int
+		want    []int
+		wantErr bool
+	}{
+		{name: "one step", applied: []int{1, 2, 3}, steps: 1, want: []int{-3}},
+		{name: "newest first", applied: []int{1, 2, 3, 10}, steps: 3, want: []int{-10, -3, -2}},
+		{name: "all applied", applied: []int{1, 2}, steps: 2, want: []int{-2, -1}},
+		{name: "skips not applied", applied: []int{1, 10}, steps: 2, want: []int{-10, -1}},
+		{name: "zero steps", applied: []int{1, 2}, steps: 0},
+		{name: "more than applied", applied: []int{1}, steps: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := m.planDown(appliedSet(tt.applied...), tt.steps)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if got := planned(plan); !slices.Equal(got, tt.want) {
+				t.Errorf("plan %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
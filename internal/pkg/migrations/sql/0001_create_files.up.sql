@@ -1,11 +1,7 @@
--- Ups!
-create table public.files
+create table if not exists public.files
 (
     id            bigserial constraint files_pk primary key,
     file_name     text not null,
     upload_status text not null,
     storage_link  text not null
 );
-
--- Downs!
-drop table if exists public.files;
\ No newline at end of file
@@ -0,0 +1,3 @@
+alter table public.files
+    drop column if exists codec,
+    drop column if exists original_size;
@@ -0,0 +1,3 @@
+alter table public.files
+    add column if not exists original_size bigint,
+    add column if not exists codec         text;
@@ -0,0 +1,13 @@
+drop table if exists public.file_transitions;
+
+alter table public.files
+    drop constraint if exists files_upload_status_check,
+    alter column upload_status drop default,
+    drop column if exists error_reason,
+    drop column if exists bytes_total,
+    drop column if exists bytes_uploaded,
+    drop column if exists created_at,
+    drop column if exists updated_at;
+
+update public.files set upload_status = 'ERROR' where upload_status = 'FAILED';
+update public.files set upload_status = 'COMPLETED' where upload_status = 'READY';
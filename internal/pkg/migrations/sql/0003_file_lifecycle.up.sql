@@ -0,0 +1,25 @@
+-- Старые статусы переводим в состояния жизненного цикла
+update public.files set upload_status = 'FAILED' where upload_status = 'ERROR';
+update public.files set upload_status = 'READY' where upload_status = 'COMPLETED';
+
+alter table public.files
+    alter column upload_status set default 'PENDING',
+    add constraint files_upload_status_check check (upload_status in
+        ('PENDING', 'UPLOADING', 'ASSEMBLING', 'PROCESSING', 'READY', 'FAILED', 'CANCELLED', 'DELETED')),
+    add column error_reason   text,
+    add column bytes_total    bigint      not null default 0,
+    add column bytes_uploaded bigint      not null default 0,
+    add column created_at     timestamptz not null default now(),
+    add column updated_at     timestamptz not null default now();
+
+create table public.file_transitions
+(
+    id          bigserial constraint file_transitions_pk primary key,
+    file_id     bigint      not null references public.files (id) on delete cascade,
+    from_status text,
+    to_status   text        not null,
+    reason      text,
+    created_at  timestamptz not null default now()
+);
+
+create index file_transitions_file_id_idx on public.file_transitions (file_id);
@@ -0,0 +1,7 @@
+update public.files set upload_status = 'FAILED' where upload_status = 'MISSING';
+
+alter table public.files
+    drop column if exists etag,
+    drop constraint files_upload_status_check,
+    add constraint files_upload_status_check check (upload_status in
+        ('PENDING', 'UPLOADING', 'ASSEMBLING', 'PROCESSING', 'READY', 'FAILED', 'CANCELLED', 'DELETED'));
@@ -0,0 +1,6 @@
+alter table public.files
+    add column etag text,
+    drop constraint files_upload_status_check,
+    add constraint files_upload_status_check check (upload_status in
+        ('PENDING', 'UPLOADING', 'ASSEMBLING', 'PROCESSING', 'READY', 'FAILED', 'CANCELLED', 'DELETED',
+         'MISSING'));
@@ -0,0 +1,17 @@
+-- Состояние resumable загрузок по протоколу tus: смещение и номер последней части S3 multipart сессии
+create table public.tus_uploads
+(
+    id            text        not null constraint tus_uploads_pk primary key,
+    file_name     text        not null,
+    s3_upload_id  text        not null,
+    upload_length bigint      not null,
+    upload_offset bigint      not null default 0,
+    part_size     bigint      not null,
+    parts         integer     not null default 0,
+    metadata      text,
+    expires_at    timestamptz not null,
+    created_at    timestamptz not null default now(),
+    updated_at    timestamptz not null default now()
+);
+
+create index tus_uploads_expires_at_idx on public.tus_uploads (expires_at);
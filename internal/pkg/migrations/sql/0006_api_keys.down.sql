@@ -0,0 +1,4 @@
+alter table public.files
+    drop column if exists owner;
+
+drop table if exists public.api_keys;
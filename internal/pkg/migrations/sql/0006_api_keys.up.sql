@@ -0,0 +1,17 @@
+-- Ключи доступа S3 шлюза. Секрет хранится открыто: SigV4 проверяется HMAC подписью,
+-- для которой серверу нужен исходный секрет
+create table public.api_keys
+(
+    access_key  text        not null constraint api_keys_pk primary key,
+    secret_key  text        not null,
+    owner       text        not null,
+    quota_bytes bigint,
+    disabled    boolean     not null default false,
+    created_at  timestamptz not null default now()
+);
+
+-- Владелец файла, загруженного через S3 шлюз. Файлы других загрузчиков владельца не имеют
+alter table public.files
+    add column owner text;
+
+create index files_owner_idx on public.files (owner);
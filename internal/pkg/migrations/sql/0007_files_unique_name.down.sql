@@ -0,0 +1,3 @@
+-- Удаленные дубликаты не восстанавливаются
+alter table public.files
+    drop constraint if exists files_file_name_key;
@@ -0,0 +1,20 @@
+-- До уникального имени одновременные старты загрузки могли создать несколько записей одного файла.
+-- Оставляем самую свежую, историю переходов остальных переносим к ней
+with ranked as (select id,
+                       first_value(id) over (partition by file_name order by updated_at desc, id desc) as keep_id
+                from public.files)
+update public.file_transitions t
+set file_id = r.keep_id
+from ranked r
+where t.file_id = r.id
+  and r.id <> r.keep_id;
+
+delete
+from public.files f
+where exists (select 1
+              from public.files g
+              where g.file_name = f.file_name
+                and (g.updated_at, g.id) > (f.updated_at, f.id));
+
+alter table public.files
+    add constraint files_file_name_key unique (file_name);
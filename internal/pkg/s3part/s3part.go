@@ -0,0 +1,20 @@
+package s3part
+
+// Ограничения multipart загрузки S3
+const (
+	// MinSize минимальный размер части, кроме последней
+	MinSize = 5 << 20
+	// MaxSize максимальный размер части
+	MaxSize = 5 << 30
+	// MaxCount максимальное число частей одной загрузки
+	MaxCount = 10000
+)
+
+// SizeFor подбирает размер части S3 для файла размером size: не меньше настроенного partSize
+// и достаточно большой, чтобы файл уложился в MaxCount частей. Размер выравнивается до MiB
+func SizeFor(size int64, partSize int) int {
+	if minimal := int((size + MaxCount - 1) / MaxCount); minimal > partSize {
+		partSize = (minimal + 1<<20 - 1) &^ (1<<20 - 1)
+	}
+	return partSize
+}
@@ -0,0 +1,33 @@
+package s3part
+
+import "testing"
+
+func TestSizeFor(t *testing.T) {
+	const partSize = 8 << 20
+	tests := []struct {
+		name string
+		size int64
+		want int
+	}{
+		{name: "empty file", size: 0, want: partSize},
+		{name: "small file", size: 1 << 20, want: partSize},
+		{name: "exactly MaxCount configured parts", size: MaxCount * partSize, want: partSize},
+		{name: "one byte over MaxCount configured parts", size: MaxCount*partSize + 1, want: partSize + 1<<20},
+		{name: "100 GiB", size: 100 << 30, want: 11 << 20},
+		{name: "largest S3 object", size: MaxCount * MaxSize, want: MaxSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SizeFor(tt.size, partSize)
+			if got != tt.want {
+				t.Fatalf("SizeFor(%d) = %d, want %d", tt.size, got, tt.want)
+			}
+			if got%(1<<20) != 0 {
+				t.Errorf("part size %d is not MiB aligned", got)
+			}
+			if parts := (tt.size + int64(got) - 1) / int64(got); parts > MaxCount {
+				t.Errorf("%d bytes need %d parts of %d bytes, more than %d", tt.size, parts, got, MaxCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentS3 добавляет в обработчики aws сессии спан на каждый вызов S3, включая повторы SDK.
+// Родитель спана берется из контекста запроса, то есть вызовы ...WithContext попадают в трассировку загрузки
+func InstrumentS3(handlers *request.Handlers) {
+	handlers.Validate.PushFront(func(r *request.Request) {
+		ctx, _ := Tracer().Start(r.Context(), "S3."+r.Operation.Name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.RPCSystemKey.String("aws-api"),
+				semconv.RPCService(r.ClientInfo.ServiceName),
+				semconv.RPCMethod(r.Operation.Name),
+			))
+		r.SetContext(ctx)
+	})
+	handlers.Complete.PushBack(func(r *request.Request) {
+		span := trace.SpanFromContext(r.Context())
+		if r.HTTPResponse != nil {
+			span.SetAttributes(semconv.HTTPResponseStatusCode(r.HTTPResponse.StatusCode))
+		}
+		if r.RetryCount > 0 {
+			span.SetAttributes(attribute.Int("aws.retry_count", r.RetryCount))
+		}
+		if r.Error != nil {
+			span.RecordError(r.Error)
+			span.SetStatus(codes.Error, r.Error.Error())
+		} else if r.HTTPResponse != nil && r.HTTPResponse.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, http.StatusText(r.HTTPResponse.StatusCode))
+		}
+		span.End()
+	})
+}
@@ -1,9 +1,9 @@
 package gs
 
 import (
-	"context"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"demo-storage/internal/pkg/app"
 	logdoc "github.com/SandQuattro/logdoc-go-appender/logrus"
@@ -13,17 +13,21 @@ func GracefulShutdown(app *app.App) {
 	logger := logdoc.GetLogger()
 	// Используем буферизированный канал, как рекомендовано внутри signal.Notify функции
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
-	// Блокируемся и ожидаем из канала quit - interrupt signal,
-	// чтобы сделать graceful shutdown сервака с таймаутом в 10 сек
-	<-quit
+	// Блокируемся и ожидаем из канала quit - SIGINT или SIGTERM от оркестратора,
+	// чтобы сделать graceful shutdown сервака с ожиданием активных загрузок
+	sig := <-quit
 
-	// Получили SIGINT (0x2), выполняем grace shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	logger.Warn("Gracefully shutdown server...")
-	if err := app.Echo.Shutdown(ctx); err != nil {
-		logger.Error("gracefully shutdown error")
+	// Повторный сигнал - не ждем загрузки и выходим сразу
+	go func() {
+		<-quit
+		logger.Warn("Second signal received, exiting immediately")
+		os.Exit(1)
+	}()
+
+	logger.Warn("Gracefully shutdown server on " + sig.String() + "...")
+	if err := app.Shutdown(); err != nil {
+		logger.Error("gracefully shutdown error: ", err)
 	}
 }
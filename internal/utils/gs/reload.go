@@ -0,0 +1,19 @@
+package gs
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"demo-storage/internal/pkg/app"
+)
+
+// ReloadOnSIGHUP перечитывает конфигурацию по каждому SIGHUP. Ошибки пишет в лог сам app.Reload,
+// прежние настройки при этом продолжают действовать
+func ReloadOnSIGHUP(app *app.App) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		_, _ = app.Reload()
+	}
+}